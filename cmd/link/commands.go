@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/email"
+	"github.com/spoonboy-io/link/internal/morpheus"
+)
+
+// auditExport writes the approval workflows in the audit trail between two dates as CSV
+// or JSON, to stdout or a file, returning the process exit code
+func auditExport(args []string) int {
+	fs := flag.NewFlagSet("audit-export", flag.ContinueOnError)
+	from := fs.String("from", "", "first day of the export, inclusive (YYYY-MM-DD)")
+	to := fs.String("to", "", "last day of the export, inclusive (YYYY-MM-DD)")
+	format := fs.String("format", audit.FORMAT_CSV, "export format, 'csv' or 'json'")
+	out := fs.String("out", "", "file to write the export to (default stdout)")
+	trail := fs.String("trail", "", "audit trail file to read (default from application configuration)")
+	config := configFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// the trail the server writes is read unless another is given
+	if *trail == "" {
+		file := config()
+		if err := app.LoadConfig(file); err != nil {
+			logger.Error(fmt.Sprintf("Failed to load application configuration '%s', give the audit trail with -trail", file), err)
+			return 1
+		}
+		if err := app.ValidateConfig(); err != nil {
+			logger.Error(fmt.Sprintf("Application configuration '%s' is not valid, give the audit trail with -trail", file), err)
+			return 1
+		}
+		*trail = app.Config.AuditFile
+	}
+
+	start, end, err := audit.ParseDateRange(*from, *to)
+	if err != nil {
+		logger.Error("Invalid export date range", err)
+		return 1
+	}
+
+	records, err := audit.Read(*trail)
+	if err != nil {
+		logger.Error("Could not read audit trail", err)
+		return 1
+	}
+	records = audit.Filter(records, start, end)
+
+	var w io.Writer = os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			logger.Error("Could not create export file", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err := audit.Export(w, records, *format); err != nil {
+		logger.Error("Could not export audit trail", err)
+		return 1
+	}
+
+	if *out != "" {
+		logger.Info(fmt.Sprintf("Exported %d approval workflows to '%s'", len(records), *out))
+	}
+	return 0
+}
+
+// validate checks the application and approval configuration files without starting
+// the server or creating any files, and with connectivity set, checks the Morpheus API
+// and SMTP server can be logged in to. A report is written to stdout and the exit code
+// is non-zero if any check failed
+func validate(args []string, connectivity bool) int {
+	name := "validate"
+	if connectivity {
+		name = "check-config"
+	}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	config := configFlags(fs)
+	approvalConfig := fs.String("approval-config", "", "approval configuration file (default from application configuration)")
+	timeout := fs.Duration("timeout", 30*time.Second, "timeout for the connectivity checks")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	failed := false
+	report := func(check string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s\n", check)
+			for _, line := range strings.Split(err.Error(), "\n") {
+				fmt.Printf("      %s\n", line)
+			}
+			return
+		}
+		fmt.Printf("OK    %s\n", check)
+	}
+
+	// application configuration
+	appConfig := config()
+	appErr := app.LoadConfig(appConfig)
+	if appErr == nil {
+		appErr = app.ValidateConfig()
+	}
+	if appErr == nil {
+		approval.SetTemplateFolder(app.Config.TemplateFolder)
+	}
+	report(fmt.Sprintf("application configuration '%s'", appConfig), appErr)
+
+	// approval configuration
+	approvalFile := approvalConfigFile(*approvalConfig)
+	approvalErr := approval.ReadAndParseConfig(approvalFile)
+	if approvalErr == nil {
+		approvalErr = approval.ValidateConfig()
+	}
+	report(fmt.Sprintf("approval configuration '%s'", approvalFile), approvalErr)
+
+	if connectivity {
+		if appErr != nil {
+			report("Morpheus API connectivity", errors.New("skipped, application configuration is not valid"))
+			report("SMTP login", errors.New("skipped, application configuration is not valid"))
+		} else {
+			ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+			defer cancel()
+
+			for _, ap := range app.Config.Appliances {
+				check := fmt.Sprintf("Morpheus API connectivity to '%s' (%s)", ap.Host, ap.Name)
+				var username string
+				client, err := morpheus.NewClient(ap, logger)
+				if err == nil {
+					username, err = client.CheckConnection(ctx)
+				}
+				if err == nil {
+					check += fmt.Sprintf(" as '%s'", username)
+				}
+				report(check, err)
+			}
+
+			err := email.CheckLogin(app.Config.SmtpServer, app.Config.SmtpPort, app.Config.SmtpUser, app.Config.SmtpPassword)
+			report(fmt.Sprintf("SMTP login to '%s:%d' as '%s'", app.Config.SmtpServer, app.Config.SmtpPort, app.Config.SmtpUser), err)
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+// approvalConfigFile returns the approval configuration file named by a flag, or if not set,
+// by the application configuration which has been loaded
+func approvalConfigFile(flagValue string) string {
+	if flagValue != "" {
+		return flagValue
+	}
+	if app.Config.ApprovalConfig != "" {
+		return app.Config.ApprovalConfig
+	}
+	return internal.APPROVAL_CONFIG
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/email/emailtest"
+	"github.com/spoonboy-io/link/internal/morpheus/morpheustest"
+)
+
+// testConfig is a configuration with relative paths, which are found in the data directory
+const testConfig = `morpheus:
+  host: https://morpheus.test.io
+  token: xxx-testtoken-xxx
+smtp:
+  server: smtp.test.io
+  port: 587
+  user: link@test.io
+  password: secret
+paths:
+  auditTrail: trail.jsonl
+routing:
+  file: rules.yaml
+`
+
+const testApprovals = `- approval:
+    description: everything
+    onProvision: true
+    recipientList:
+      - ops@test.io
+`
+
+// dataDir returns a data directory holding the configuration, and resets the app so each
+// command loads it afresh
+func dataDir(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("could not write %s %v", name, err)
+		}
+	}
+	app = &internal.App{Logger: logger}
+	return dir
+}
+
+func TestAuditExport_DataDir(t *testing.T) {
+	dir := dataDir(t, map[string]string{internal.CONFIG_FILE: testConfig})
+	if err := audit.Write(filepath.Join(dir, "trail.jsonl"), audit.Record{ApprovalId: 7, ApprovalName: "APPROVAL-7", Created: time.Now()}); err != nil {
+		t.Fatalf("could not write audit record %v", err)
+	}
+
+	// the trail configured in the data directory is read, not one in the working directory
+	out := filepath.Join(t.TempDir(), "export.json")
+	if code := auditExport([]string{"-data-dir", dir, "-format", "json", "-out", out}); code != 0 {
+		t.Fatalf("wanted exit code 0 got %d", code)
+	}
+	exported, err := os.ReadFile(out)
+	if err != nil || !strings.Contains(string(exported), "APPROVAL-7") {
+		t.Errorf("wanted APPROVAL-7 exported got %s %v", exported, err)
+	}
+
+	// without a configuration the trail must be given
+	dataDir(t, nil)
+	if code := auditExport([]string{"-data-dir", t.TempDir(), "-out", out}); code != 1 {
+		t.Errorf("wanted exit code 1 got %d", code)
+	}
+}
+
+func TestValidate_DataDir(t *testing.T) {
+	// the approvals file named in the configuration is found in the data directory
+	dir := dataDir(t, map[string]string{internal.CONFIG_FILE: testConfig, "rules.yaml": testApprovals})
+	if code := validate([]string{"-data-dir", dir}, false); code != 0 {
+		t.Errorf("wanted exit code 0 got %d", code)
+	}
+	if app.Config.AuditFile != filepath.Join(dir, "trail.jsonl") {
+		t.Errorf("wanted the audit trail in the data directory got %s", app.Config.AuditFile)
+	}
+
+	// the configuration can be named, and is still read with the deprecated flag
+	dataDir(t, nil)
+	if code := validate([]string{"-data-dir", dir, "-app-config", filepath.Join(dir, internal.CONFIG_FILE)}, false); code != 0 {
+		t.Errorf("wanted exit code 0 got %d", code)
+	}
+
+	// without the approvals file the configuration is not valid
+	dir = dataDir(t, map[string]string{internal.CONFIG_FILE: testConfig})
+	if code := validate([]string{"-data-dir", dir}, false); code != 1 {
+		t.Errorf("wanted exit code 1 got %d", code)
+	}
+}
+
+func TestValidate_CheckConfig(t *testing.T) {
+	morpheusSrv := morpheustest.NewServer()
+	defer morpheusSrv.Close()
+	withAuth := emailtest.NewServer("link@test.io", "secret")
+	defer withAuth.Close()
+	noAuth := emailtest.NewServer("", "")
+	defer noAuth.Close()
+
+	config := func(token string, smtp *emailtest.Server) string {
+		return fmt.Sprintf(`morpheus:
+  host: %s
+  token: %s
+smtp:
+  server: %s
+  port: %d
+  user: link@test.io
+  password: secret
+routing:
+  file: rules.yaml
+`, morpheusSrv.URL, token, smtp.Host, smtp.Port)
+	}
+
+	testCases := []struct {
+		name     string
+		config   string
+		wantCode int
+	}{
+		{"connected", config(morpheustest.TOKEN, withAuth), 0},
+		{"morpheus token refused", config("guess", withAuth), 1},
+		{"smtp login not checked", config(morpheustest.TOKEN, noAuth), 1},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := dataDir(t, map[string]string{internal.CONFIG_FILE: tc.config, "rules.yaml": testApprovals})
+			if code := validate([]string{"-data-dir", dir, "-timeout", "5s"}, true); code != tc.wantCode {
+				t.Errorf("wanted exit code %d got %d", tc.wantCode, code)
+			}
+		})
+	}
+}
@@ -16,25 +16,30 @@ state - we need to manage the approval state, while it is out for approval
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/spoonboy-io/link/internal/morpheus"
-	"github.com/spoonboy-io/link/internal/state"
-
 	"github.com/spoonboy-io/link/internal/routes"
 
 	"github.com/gorilla/mux"
 
-	"github.com/spoonboy-io/koan"
 	"github.com/spoonboy-io/link/internal"
 	"github.com/spoonboy-io/link/internal/approval"
 	"github.com/spoonboy-io/link/internal/certificate"
+	"github.com/spoonboy-io/link/internal/email"
+	"github.com/spoonboy-io/link/internal/health"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/watch"
+	"github.com/spoonboy-io/link/internal/workflow"
 	"github.com/spoonboy-io/reprise"
 )
 
@@ -43,67 +48,120 @@ var (
 	goversion = "Unknown"
 )
 
-var logger *koan.Logger
-var st *state.State
+var logger *logging.Logger
 var app *internal.App
+var monitor *health.Tracker
+var engine *workflow.Engine
 
 func init() {
-	logger = &koan.Logger{}
-	st = &state.State{}
+	logger = logging.New()
 	app = &internal.App{
 		Logger: logger,
-		State:  st,
 	}
+	monitor = health.NewTracker(time.Now())
+	engine = workflow.New(app, logger, monitor)
+}
+
+// appConfigFile returns the application configuration file in the data directory, the YAML
+// config file is used if it exists, otherwise config.env
+func appConfigFile(dataDir string) string {
+	yamlConfig := filepath.Join(dataDir, internal.CONFIG_FILE)
+	if _, err := os.Stat(yamlConfig); err == nil {
+		return yamlConfig
+	}
+	return filepath.Join(dataDir, internal.APP_CONFIG)
+}
+
+// configFlags adds the flags giving the data directory and configuration file to the flags of a
+// command, so every command finds the configuration and the files it names as serve does. The
+// function returned sets the data directory of the app and returns the configuration file
+func configFlags(fs *flag.FlagSet) func() string {
+	dataDir := fs.String("data-dir", ".", "directory for templates, certificates, the audit trail and relative paths in the configuration")
+	config := fs.String("config", "", "application configuration file (default link.yaml, or config.env, in the data directory)")
+	fs.StringVar(config, "app-config", "", "application configuration file, deprecated, use -config")
+
+	return func() string {
+		app.DataDir = *dataDir
+		configFile := *config
+		if configFile == "" {
+			configFile = appConfigFile(*dataDir)
+		}
+		// the absolute path is used so approvals set inline are found wherever the data directory is
+		if abs, err := filepath.Abs(configFile); err == nil {
+			configFile = abs
+		}
+		return configFile
+	}
+}
+
+// setup loads the configuration and creates the folders, default email template and certificate
+// needed to run the server, any problem is fatal
+func setup(configFile string) {
+	// load application config
+	if err := app.LoadConfig(configFile); err != nil {
+		logger.FatalError("Failed to load application", err)
+	}
+
+	// validate it here rather than later
+	if err := app.ValidateConfig(); err != nil {
+		logger.FatalError("Application configuration is not sufficient", err)
+	}
+	if err := logger.Configure(app.Config.LogLevel, app.Config.LogFormat, app.Config.LogOutput); err != nil {
+		logger.FatalError("Failed to configure logging", err)
+	}
+	approval.SetTemplateFolder(app.Config.TemplateFolder)
 
 	// check/create data folder
-	templatesPath := filepath.Join(".", internal.TEMPLATE_FOLDER)
-	if err := os.MkdirAll(templatesPath, os.ModePerm); err != nil {
+	if err := os.MkdirAll(app.Config.TemplateFolder, os.ModePerm); err != nil {
 		logger.FatalError("Problem checking/creating templates folder", err)
 	}
 
-	// create starter default email template if not exist
-	defaultTemplate := fmt.Sprintf("%s/default.html", internal.TEMPLATE_FOLDER)
-	if _, err := os.Stat(defaultTemplate); errors.Is(err, os.ErrNotExist) {
+	// create starter default email template if not exist, or replace the placeholder of earlier
+	// versions which has no vote links
+	defaultTemplate := filepath.Join(app.Config.TemplateFolder, email.DEFAULT_TEMPLATE)
+	content, err := os.ReadFile(defaultTemplate)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
 		logger.Info("Creating default email template")
 		if err := os.WriteFile(defaultTemplate, []byte(internal.DefaultTemplate), 0644); err != nil {
 			logger.FatalError("Problem creating the default email template", err)
 		}
+	case err == nil && internal.IsPlaceholderTemplate(content):
+		logger.Info("Replacing the placeholder default email template")
+		if err := os.WriteFile(defaultTemplate, []byte(internal.DefaultTemplate), 0644); err != nil {
+			logger.FatalError("Problem replacing the default email template", err)
+		}
 	}
 
 	// check/create certificates folder
-	tlsPath := filepath.Join(".", internal.TLS_FOLDER)
-	if err := os.MkdirAll(tlsPath, os.ModePerm); err != nil {
+	if err := os.MkdirAll(app.Config.TLSFolder, os.ModePerm); err != nil {
 		logger.FatalError("Problem checking/creating 'certificates' folder", err)
 	}
 
 	// add self-signed certificate only if folder empty, if the cert expires it
 	// it can be deleted so the code here creates a new cert.pem and key.pem file
-	cert := fmt.Sprintf("%s/cert.pem", internal.TLS_FOLDER)
+	cert := filepath.Join(app.Config.TLSFolder, "cert.pem")
 	if _, err := os.Stat(cert); errors.Is(err, os.ErrNotExist) {
 		logger.Info("Creating self-signed TLS certificate for the server")
-		if err := certificate.Make(logger); err != nil {
+		if err := certificate.Make(logger, app.Config.TLSFolder); err != nil {
 			logger.FatalError("Problem creating the certificate/key", err)
 		}
 	}
 
-	// load application config
-	if err := app.LoadConfig(internal.APP_CONFIG); err != nil {
-		logger.FatalError("Failed to load application", err)
-	}
-
-	// validate it here rather than later
-	if err := app.ValidateConfig(); err != nil {
-		logger.FatalError("Application configuration is not sufficient", err)
-	}
-
 	// load approval YAML and validate we can use
-	if err := approval.ReadAndParseConfig(internal.APPROVAL_CONFIG); err != nil {
+	if err := approval.ReadAndParseConfig(app.Config.ApprovalConfig); err != nil {
 		logger.FatalError("Failed to read approval configuration file", err)
 	}
 
 	if err := approval.ValidateConfig(); err != nil {
 		logger.FatalError("Failed to validate approval configuration", err)
 	}
+	approval.SetMatchMode(app.Config.MatchMode)
+
+	// resume the workflows in flight on each appliance when the server stopped
+	if _, err := engine.OpenAppliances(app.Config.Appliances); err != nil {
+		logger.FatalError("Failed to open the Morpheus appliances", err)
+	}
 }
 
 // Shutdown runs on SIGINT and panic
@@ -116,12 +174,76 @@ func Shutdown(cancel context.CancelFunc) {
 	cancel()
 
 	logger.Info("Saving application state")
-	if err := st.CreateAndWrite(); err != nil {
-		logger.Error("Failed to save application state", err)
-	}
+	engine.SaveState()
+}
+
+// usage describes the commands and is written for 'help' or an unknown command
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: link <command> [flags]
+
+Commands:
+  serve          run the server, polling Morpheus and sending notifications (default)
+  validate       check the application and approval configuration files
+  check-config   validate, and check the Morpheus API and SMTP server can be logged in to
+  simulate       route an approval read from a JSON file and show the email which would be sent
+  replay         evaluate past approvals against a candidate approval configuration
+  audit-export   export the audit trail as CSV or JSON
+  version        print the version
+
+Run 'link <command> -h' for the flags of a command.
+`)
 }
 
 func main() {
+	// with no command, or only flags, the server is started
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		os.Exit(serve(os.Args[1:]))
+	}
+
+	args := os.Args[2:]
+	switch os.Args[1] {
+	case "serve":
+		os.Exit(serve(args))
+	case "audit-export":
+		os.Exit(auditExport(args))
+	case "validate":
+		os.Exit(validate(args, false))
+	case "check-config":
+		os.Exit(validate(args, true))
+	case "simulate":
+		os.Exit(simulate(args))
+	case "replay":
+		os.Exit(replayApprovals(args))
+	case "version":
+		fmt.Printf("link %s (%s)\n", version, goversion)
+	case "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command '%s'\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+}
+
+// serve runs the server until interrupted. The configuration file and the files the server
+// creates are found in the data directory unless given as absolute paths, so the server does
+// not depend on the working directory
+func serve(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	config := configFlags(fs)
+	listen := fs.String("listen", "", "address to listen on as host:port, overriding the configuration, e.g. ':8443'")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		return 2
+	}
+
+	app.Listen = *listen
+	configFile := config()
+	setup(configFile)
+
 	ctx, cancel := context.WithCancel(context.Background())
 	app.Ctx = ctx
 	defer Shutdown(cancel)
@@ -138,34 +260,97 @@ func main() {
 		EmailAddress: "hello@spoonboy.io",
 	})
 
-	// api poller which initiates most of the work
+	if app.Config.DryRun {
+		logger.Warn("Dry run mode, approvals will be routed but no notifications sent")
+	}
+	if !app.Config.MorpheusTLSVerify {
+		logger.Warn("The Morpheus API certificate is not verified, set a CA bundle or enable TLS verify")
+	}
+
+	// reload configuration when the files change, or on SIGHUP. The approvals file watched is
+	// the one currently configured, so a change to routing.file is followed
+	reload := make(chan string)
 	go func() {
-		pollInterval := time.NewTicker(time.Duration(app.Config.PollInterval) * time.Second)
-		for range pollInterval.C {
-			newApprovals, err := morpheus.CheckNewApprovals(ctx, app)
-			if err != nil {
-				logger.Error("Morpheus API request error", err)
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		files := func() []string {
+			approvalConfig := app.Settings().ApprovalConfig
+			if approvalConfig == configFile {
+				return []string{configFile}
 			}
-			lastCheckMsg := fmt.Sprintf("Checking for new Morpheus Approvals at %s", time.Now())
-			logger.Info(lastCheckMsg)
+			return []string{configFile, approvalConfig}
+		}
+		changed := watch.Paths(ctx, internal.CONFIG_WATCH_INTERVAL, files)
+		for {
+			select {
+			case <-hup:
+				logger.Info("Received SIGHUP, reloading configuration")
+				for _, file := range files() {
+					reload <- file
+				}
+			case file, ok := <-changed:
+				if !ok {
+					return
+				}
+				reload <- file
+			}
+		}
+	}()
 
-			// match against the configuration
-			_ = newApprovals // TODO
+	// webhook calls ask for a poll, a poll already asked for covers any further calls. Polling at
+	// the interval continues so approvals are found should a webhook call be missed
+	pollNow := make(chan struct{}, 1)
+
+	// api poller which initiates most of the work, configuration is reloaded by the
+	// same goroutine so a poll always works with a consistent configuration
+	go func() {
+		pollInterval := time.NewTicker(time.Duration(app.Config.PollInterval) * time.Second)
+
+		// secrets are refreshed at the interval set when the server started
+		var refresh <-chan time.Time
+		if app.Config.SecretRefresh > 0 {
+			refreshInterval := time.NewTicker(time.Duration(app.Config.SecretRefresh) * time.Second)
+			refresh = refreshInterval.C
+		}
+
+		for {
+			select {
+			case <-pollInterval.C:
+				engine.Poll(ctx)
+			case <-pollNow:
+				engine.Poll(ctx)
+			case file := <-reload:
+				reloadConfig(configFile, file, pollInterval)
+			case <-refresh:
+				refreshSecrets(configFile, pollInterval)
+			}
 		}
 	}()
 
 	// handlers
 	mux := mux.NewRouter()
 	handler := &routes.Routes{
-		App: app,
+		App:       app,
+		PollNow:   pollNow,
+		Health:    monitor,
+		Workflows: engine,
 	}
 
 	//mux.HandleFunc(`/`, handler.Ping).Methods("GET")
 	mux.HandleFunc(`/ping`, handler.Ping).Methods("GET")
+	mux.HandleFunc(`/audit/export`, handler.AuditExport).Methods("GET")
+	mux.HandleFunc(`/webhook`, handler.Webhook).Methods("POST")
+	mux.HandleFunc(`/metrics`, handler.Metrics).Methods("GET")
+	mux.HandleFunc(`/healthz`, handler.Healthz).Methods("GET")
+	mux.HandleFunc(`/readyz`, handler.Readyz).Methods("GET")
+	mux.HandleFunc(workflow.VOTE_PATH, handler.Vote).Methods("GET", "POST")
+	mux.Use(handler.RequestId)
 
 	// start HTTPS server
 	go func() {
-		hostPort := net.JoinHostPort(internal.SRV_HOST, internal.SRV_PORT)
+		hostPort := net.JoinHostPort(app.Config.ListenHost, strconv.Itoa(app.Config.ListenPort))
+		// a vote is waited for up to routes.VOTE_WAIT, well within the write timeout, after
+		// which it is made apart from the request
 		srvTLS := &http.Server{
 			Addr:         hostPort,
 			Handler:      mux,
@@ -174,14 +359,15 @@ func main() {
 		}
 
 		logger.Info(fmt.Sprintf("Starting HTTPS server on %s", hostPort))
-		if err := srvTLS.ListenAndServeTLS(fmt.Sprintf("%s/cert.pem", internal.TLS_FOLDER), fmt.Sprintf("%s/key.pem", internal.TLS_FOLDER)); err != nil {
+		if err := srvTLS.ListenAndServeTLS(filepath.Join(app.Config.TLSFolder, "cert.pem"), filepath.Join(app.Config.TLSFolder, "key.pem")); err != nil {
 			logger.FatalError("Failed to start HTTPS server", err)
 		}
 	}()
 
 	// shutdown
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	<-c
 
+	return 0
 }
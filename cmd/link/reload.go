@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/metrics"
+)
+
+// reloadConfig reloads a configuration file which has changed, the configuration in use
+// is only replaced if the new configuration is valid. Approvals already routed keep the
+// approval configuration they were routed with. When the approvals are set inline in the
+// application config file both are reloaded
+func reloadConfig(configFile, file string, pollInterval *time.Ticker) {
+	approvalConfig := app.Config.ApprovalConfig
+	if file == configFile {
+		changes, err := reloadAppConfig(file, pollInterval)
+		logReload(file, changes, err)
+	}
+
+	if file == app.Config.ApprovalConfig || app.Config.ApprovalConfig != approvalConfig {
+		reloadApprovals()
+	}
+}
+
+// reloadApprovals reloads the approvals config, from a different file if routing.file changed
+func reloadApprovals() {
+	changes, err := approval.Reload(app.Config.ApprovalConfig)
+	logReload(app.Config.ApprovalConfig, changes, err)
+}
+
+// refreshSecrets resolves the secrets in the application configuration again, so secrets
+// rotated in their backend are used without a restart. Only changes and errors are logged
+func refreshSecrets(configFile string, pollInterval *time.Ticker) {
+	approvalConfig := app.Config.ApprovalConfig
+	changes, err := reloadAppConfig(configFile, pollInterval)
+	if err != nil {
+		logger.Error("Could not refresh secrets, keeping the current configuration", err)
+		return
+	}
+	if len(changes) > 0 {
+		logger.Info(fmt.Sprintf("Refreshed configuration: %s", strings.Join(changes, ", ")))
+	}
+	if app.Config.ApprovalConfig != approvalConfig {
+		reloadApprovals()
+	}
+}
+
+// reloadAppConfig reloads the application configuration and applies the settings used
+// outside of the app, the Morpheus API clients are replaced to use the new settings
+func reloadAppConfig(file string, pollInterval *time.Ticker) ([]string, error) {
+	before := app.Config
+	changes, err := app.ReloadConfig(file)
+	if err != nil || len(changes) == 0 {
+		return changes, err
+	}
+
+	// the HTTPS server listens with the address and certificate it started with
+	if app.Config.ListenHost != before.ListenHost || app.Config.ListenPort != before.ListenPort || app.Config.TLSFolder != before.TLSFolder {
+		logger.Warn(fmt.Sprintf("Server address or certificates changed, restart Link to apply them, still listening on %s",
+			net.JoinHostPort(before.ListenHost, strconv.Itoa(before.ListenPort))))
+	}
+
+	pollInterval.Reset(time.Duration(app.Config.PollInterval) * time.Second)
+	if err := logger.Configure(app.Config.LogLevel, app.Config.LogFormat, app.Config.LogOutput); err != nil {
+		logger.Error("Could not reconfigure logging, keeping the current logging", err)
+	}
+	approval.SetMatchMode(app.Config.MatchMode)
+	approval.SetTemplateFolder(app.Config.TemplateFolder)
+
+	removed, err := engine.OpenAppliances(app.Config.Appliances)
+	if err != nil {
+		return changes, fmt.Errorf("%v, keeping the current appliances", err)
+	}
+	for _, name := range removed {
+		logger.Warn(fmt.Sprintf("Appliance '%s' removed, it is no longer polled", name))
+		metrics.ForgetAppliance(name)
+	}
+	return changes, nil
+}
+
+// logReload logs the outcome of reloading a configuration file
+func logReload(file string, changes []string, err error) {
+	if err != nil {
+		logger.Error(fmt.Sprintf("Configuration in '%s' is not valid, keeping the current configuration", file), err)
+		return
+	}
+
+	if len(changes) == 0 {
+		logger.Info(fmt.Sprintf("Reloaded '%s', no changes", file))
+		return
+	}
+
+	logger.Info(fmt.Sprintf("Reloaded '%s': %s", file, strings.Join(changes, ", ")))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/morpheus"
+	"github.com/spoonboy-io/link/internal/replay"
+)
+
+// replayApprovals fetches past approvals from the Morpheus API and evaluates them against
+// a candidate approvals config, reporting where routing differs from the current config
+func replayApprovals(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ContinueOnError)
+	candidateConfig := fs.String("candidate", "", "candidate approval configuration file")
+	currentConfig := fs.String("current", "", "current approval configuration file (default from application configuration)")
+	config := configFlags(fs)
+	from := fs.String("from", "", "replay approvals created on or after this day (YYYY-MM-DD)")
+	to := fs.String("to", "", "replay approvals created on or before this day (YYYY-MM-DD)")
+	lookup := fs.Bool("lookup", false, "look up the instances and apps subject to each approval, to match on scope and cost")
+	matchMode := fs.String("match-mode", "", "match mode, 'all', 'first' or 'combined' (default from application configuration)")
+	format := fs.String("format", "text", "report format, 'text' or 'json'")
+	all := fs.Bool("all", false, "list every approval in the text report, not only those which differ")
+	applianceName := fs.String("appliance", "", "appliance to replay the approvals of (default the first configured)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *candidateConfig == "" {
+		fmt.Fprintln(os.Stderr, "The -candidate flag is required")
+		fs.Usage()
+		return 2
+	}
+	if *format != "text" && *format != "json" {
+		fmt.Fprintln(os.Stderr, "The -format flag must be 'text' or 'json'")
+		return 2
+	}
+
+	start, end, err := audit.ParseDateRange(*from, *to)
+	if err != nil {
+		logger.Error("Invalid replay date range", err)
+		return 2
+	}
+
+	if err := app.LoadConfig(config()); err != nil {
+		logger.Error("Failed to load application", err)
+		return 1
+	}
+	if err := app.ValidateConfig(); err != nil {
+		logger.Error("Application configuration is not sufficient", err)
+		return 1
+	}
+
+	mode := *matchMode
+	if mode == "" {
+		mode = app.Config.MatchMode
+	}
+	if mode != internal.MATCH_MODE_ALL && mode != internal.MATCH_MODE_FIRST && mode != internal.MATCH_MODE_COMBINED {
+		logger.Error("Invalid match mode", internal.ERR_BAD_MATCH_MODE)
+		return 2
+	}
+
+	approval.SetTemplateFolder(app.Config.TemplateFolder)
+	currentFile := approvalConfigFile(*currentConfig)
+	current, err := approval.LoadConfig(currentFile)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Current approval configuration '%s' is not valid", currentFile), err)
+		return 1
+	}
+	candidate, err := approval.LoadConfig(*candidateConfig)
+	if err != nil {
+		logger.Error(fmt.Sprintf("Candidate approval configuration '%s' is not valid", *candidateConfig), err)
+		return 1
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ap, ok := app.Config.Appliance(*applianceName)
+	if !ok {
+		logger.Error(fmt.Sprintf("Appliance '%s' is not configured", *applianceName), internal.ERR_BAD_APPLIANCE)
+		return 2
+	}
+	client, err := morpheus.NewClient(ap, logger)
+	if err != nil {
+		logger.Error("Could not create the Morpheus API client", err)
+		return 1
+	}
+
+	listed, err := client.ListApprovals(ctx)
+	if err != nil {
+		logger.Error("Could not list approvals", err)
+		return 1
+	}
+
+	var approvals []approval.Approval
+	for i := range listed {
+		if listed[i].DateCreated.Before(start) || !listed[i].DateCreated.Before(end) {
+			continue
+		}
+
+		a := listed[i]
+		if *lookup {
+			// instances and apps may since have been deleted, so replay with what we have
+			if a, err = client.GetApproval(ctx, listed[i].Id); err != nil {
+				logger.Warn(fmt.Sprintf("Could not get approval %d, skipping (%v)", listed[i].Id, err))
+				continue
+			}
+			if err := client.GetDetails(ctx, &a); err != nil {
+				logger.Warn(fmt.Sprintf("Could not look up details of approval %d, scope and cost may be incomplete (%v)", a.Id, err))
+			}
+		}
+		approvals = append(approvals, a)
+	}
+
+	logger.Info(fmt.Sprintf("Replaying %d approvals created between %s and %s", len(approvals),
+		start.Format(audit.DATE_LAYOUT), end.Add(-time.Nanosecond).Format(audit.DATE_LAYOUT)))
+
+	report := replay.Evaluate(approvals, current, candidate, mode)
+	if *format == "json" {
+		err = report.WriteJSON(os.Stdout)
+	} else {
+		err = report.WriteText(os.Stdout, *all)
+	}
+	if err != nil {
+		logger.Error("Could not write replay report", err)
+		return 1
+	}
+
+	return 0
+}
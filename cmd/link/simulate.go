@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/email"
+	"github.com/spoonboy-io/link/internal/morpheus"
+)
+
+// simulation is the input to the simulate command, the approval as returned by the
+// Morpheus API and optionally the instances subject to it, so scope and cost can be
+// matched on without calling the API
+type simulation struct {
+	Approval  *approval.Approval  `json:"approval"`
+	Instances []morpheus.Instance `json:"instances"`
+}
+
+// simulate runs an approval read from a JSON file through the approval routing, printing
+// the approval configs matched, the recipients and the email which would be sent. Nothing
+// is sent and no Morpheus actions are called
+func simulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ContinueOnError)
+	approvalJSON := fs.String("approval-json", "", "file holding the approval JSON, as returned by /api/approvals/:id")
+	approvalConfig := fs.String("approval-config", "", "approval configuration file (default from application configuration)")
+	config := configFlags(fs)
+	matchMode := fs.String("match-mode", "", "match mode, 'all', 'first' or 'combined' (default from application configuration)")
+	lookup := fs.Bool("lookup", false, "look up the instances and apps subject to the approval using the Morpheus API")
+	applianceName := fs.String("appliance", "", "appliance the approval is from (default the first configured)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *approvalJSON == "" {
+		fmt.Fprintln(os.Stderr, "The -approval-json flag is required")
+		fs.Usage()
+		return 2
+	}
+
+	a, err := readSimulation(*approvalJSON)
+	if err != nil {
+		logger.Error("Could not read approval JSON", err)
+		return 1
+	}
+
+	// the application configuration is optional unless looking up details
+	if err := app.LoadConfig(config()); err == nil {
+		err = app.ValidateConfig()
+		if err != nil && *lookup {
+			logger.Error("Application configuration is not sufficient", err)
+			return 1
+		}
+	} else if *lookup {
+		logger.Error("Failed to load application", err)
+		return 1
+	}
+
+	if *lookup {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		ap, ok := app.Config.Appliance(*applianceName)
+		if !ok {
+			logger.Error(fmt.Sprintf("Appliance '%s' is not configured", *applianceName), internal.ERR_BAD_APPLIANCE)
+			return 2
+		}
+		client, err := morpheus.NewClient(ap, logger)
+		if err != nil {
+			logger.Error("Could not create the Morpheus API client", err)
+			return 1
+		}
+		a.Appliance = ap.Name
+		if err := client.GetDetails(ctx, &a); err != nil {
+			logger.Error("Could not look up approval details", err)
+			return 1
+		}
+	}
+
+	// scopes can target an appliance without it being configured
+	if *applianceName != "" {
+		a.Appliance = *applianceName
+	}
+
+	mode := *matchMode
+	if mode == "" {
+		mode = app.Config.MatchMode
+	}
+	switch mode {
+	case "":
+		mode = internal.MATCH_MODE_ALL
+	case internal.MATCH_MODE_ALL, internal.MATCH_MODE_FIRST, internal.MATCH_MODE_COMBINED:
+	default:
+		logger.Error("Invalid match mode", internal.ERR_BAD_MATCH_MODE)
+		return 2
+	}
+	approval.SetMatchMode(mode)
+	if app.Config.TemplateFolder != "" {
+		approval.SetTemplateFolder(app.Config.TemplateFolder)
+	}
+
+	if err := approval.ReadAndParseConfig(approvalConfigFile(*approvalConfig)); err != nil {
+		logger.Error("Failed to read approval configuration file", err)
+		return 1
+	}
+	if err := approval.ValidateConfig(); err != nil {
+		logger.Error("Failed to validate approval configuration", err)
+		return 1
+	}
+
+	fmt.Printf("Approval '%s' (%d), %s requested by '%s'\n", a.Name, a.Id, a.Action(), a.RequestBy)
+	fmt.Printf("Match mode '%s'\n", mode)
+	fmt.Println("Facts:")
+	for _, line := range factLines(a) {
+		fmt.Printf("  %s\n", line)
+	}
+
+	routes := approval.RouteApproval(a)
+	if len(routes) == 0 {
+		fmt.Println("No approval configuration matched, no notification would be sent")
+		return 0
+	}
+
+	for i, route := range routes {
+		fmt.Printf("\nRoute %d\n", i+1)
+		fmt.Printf("  Approval configs: %s\n", strings.Join(route.Descriptions(), ", "))
+		fmt.Printf("  Recipients: %s\n", strings.Join(route.Recipients, ", "))
+
+		// each recipient is sent their own vote links, the email to the first is shown without them
+		msg, err := email.Compose(app.Config.SmtpFrom, a, route, route.Recipients[0], email.Links{})
+		if err != nil {
+			logger.Error("Could not compose email", err)
+			return 1
+		}
+		fmt.Printf("  Subject: %s\n", msg.Subject)
+		fmt.Printf("  Body:\n%s\n", msg.Body)
+	}
+
+	return 0
+}
+
+// readSimulation reads the approval, and any instances, from the JSON file
+func readSimulation(file string) (approval.Approval, error) {
+	var a approval.Approval
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return a, err
+	}
+
+	sim := simulation{}
+	if err := json.Unmarshal(data, &sim); err != nil {
+		return a, err
+	}
+
+	// accept the approval without the response wrapper
+	if sim.Approval == nil {
+		if err := json.Unmarshal(data, &a); err != nil {
+			return a, err
+		}
+	} else {
+		a = *sim.Approval
+	}
+
+	morpheus.AddInstanceDetails(&a, sim.Instances)
+	return a, nil
+}
+
+// factLines formats the facts known about the approval, sorted by field
+func factLines(a approval.Approval) []string {
+	facts := a.Facts()
+	fields := make([]string, 0, len(facts))
+	for field := range facts {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	lines := make([]string, 0, len(fields))
+	for _, field := range fields {
+		lines = append(lines, fmt.Sprintf("%s: %s", field, strings.Join(facts[field], ", ")))
+	}
+	return lines
+}
@@ -5,9 +5,6 @@ go 1.17
 require (
 	github.com/gorilla/mux v1.8.0
 	github.com/joho/godotenv v1.4.0
-	github.com/spoonboy-io/koan v0.1.0
 	github.com/spoonboy-io/reprise v0.0.1
-	gopkg.in/yaml.v2 v2.4.0
+	gopkg.in/yaml.v3 v3.0.1
 )
-
-require github.com/TwiN/go-color v1.1.0 // indirect
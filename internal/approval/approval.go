@@ -1,18 +1,34 @@
 package approval
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/mail"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/spoonboy-io/link/internal"
-
-	"gopkg.in/yaml.v2"
+	"github.com/spoonboy-io/link/internal/expr"
 )
 
-var config ApprovalsConfig
+// config is the approvals config in use, it is replaced (never modified) on reload, and
+// version incremented, so that approvals keep the config they were routed with
+var (
+	mu      sync.RWMutex
+	config  ApprovalsConfig
+	source  Source
+	version int
+
+	// matchMode determines how the approval configs matching an approval are routed
+	matchMode = internal.MATCH_MODE_ALL
+
+	// templateFolder is where the email templates named by approval configs are found
+	templateFolder = internal.TEMPLATE_FOLDER
+)
 
 var (
 	ERR_NO_DESCRIPTION      = errors.New("No description is set")
@@ -21,6 +37,9 @@ var (
 	ERR_BAD_RECIPIENT_EMAIL = errors.New("Recipient email address appears to be invalid")
 	ERR_TEMPLATE_NOT_EXIST  = errors.New("Configured message template cannot be found")
 	ERR_MULTIPLE_SCOPES     = errors.New("Multiple Scopes found")
+	ERR_BAD_SCOPE_MATCH     = errors.New("Scope match expression is invalid")
+	ERR_MULTIPLE_DEFAULTS   = errors.New("Only one default approval config is allowed")
+	ERR_DEFAULT_HAS_SCOPE   = errors.New("The default approval config cannot have a scope")
 )
 
 // ApprovalsConfig is a representation of the parsed YAML approvals.yaml configuration file
@@ -38,120 +57,289 @@ type ApprovalConfig struct {
 	LinkedApproval bool     `yaml:"linkedApproval"`
 	RecipientList  []string `yaml:"recipientList"`
 	Scope          Scope    `yaml:"scope"`
+
+	// Priority orders the evaluation of approval configs, highest first, configs with the same
+	// priority are evaluated in the order of the file. If StopOnMatch is set no lower priority
+	// configs are evaluated once this one matches. The Default config is only used when no
+	// other config matches an approval
+	Priority    int  `yaml:"priority"`
+	StopOnMatch bool `yaml:"stopOnMatch"`
+	Default     bool `yaml:"default"`
+
+	// match is the compiled Scope.Match expression, set by ValidateConfig
+	match *expr.Expr
 }
 
 // Scope represents the scope configuration options which can be set in the YAML.
-// Default scope is 'global' unless overridden here - by a single setting here
-
+// Default scope is 'global' unless overridden here - by a single setting here, or
+// by a match expression which can combine conditions on any of the MatchFields, e.g.
+// 'cloud in [AWS, Azure] and group != Sandbox'. Appliance and Tenant further restrict
+// the scope to approvals from the named appliance or tenant
 type Scope struct {
-	Group   string `yaml:"group"`
-	Cloud   string `yaml:"cloud"`
-	User    string `yaml:"user"`
-	Role    string `yaml:"role"`
-	Network string `yaml:"network"`
+	Group     string `yaml:"group"`
+	Cloud     string `yaml:"cloud"`
+	User      string `yaml:"user"`
+	Role      string `yaml:"role"`
+	Network   string `yaml:"network"`
+	Match     string `yaml:"match"`
+	Appliance string `yaml:"appliance"`
+	Tenant    string `yaml:"tenant"`
 }
 
-// hold an approval
+// Approval is a Morpheus approval as returned by /api/approvals/:id, with the scope and details
+// Link has looked up about the instances and apps subject to it. Status counts the items in each
+// status, e.g. '1 approved, 1 requested', State interprets it
 type Approval struct {
-	Id          int       `json:"id"`
-	Name        string    `json:"name"`
-	RequestType string    `json:"requestType"`
-	Status      string    `json:"status"`
-	DateCreated time.Time `json:"datedCreated"`
-	RequestBy   string    `json:"requestBy"`
-	Items       []Item    `json:"approvalItems"`
-	Scope       Scope     `json:"scope"`
+	Id           int         `json:"id"`
+	Name         string      `json:"name"`
+	InternalId   ExternalRef `json:"internalId"`
+	ExternalId   ExternalRef `json:"externalId"`
+	ExternalName string      `json:"externalName"`
+	RequestType  string      `json:"requestType"`
+	Status       string      `json:"status"`
+	ErrorMessage string      `json:"errorMessage"`
+	DateCreated  time.Time   `json:"dateCreated"`
+	LastUpdated  time.Time   `json:"lastUpdated"`
+	RequestBy    string      `json:"requestBy"`
+	Approver     Ref         `json:"approver"`
+	Items        []Item      `json:"approvalItems"`
+	Scope        Scope       `json:"scope"`
+	Details      Details     `json:"-"`
+
+	// Account is the tenant the approval was requested in, Appliance the name of the appliance
+	// it was polled from
+	Account   Ref    `json:"account"`
+	Appliance string `json:"appliance,omitempty"`
+}
+
+// Ref is a reference to a named Morpheus object, such as the tenant or approver
+type Ref struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
 }
 
+// ExternalRef is an id held by Morpheus for an approval integration, such as a ServiceNow
+// ticket, which may be a number or a string in the payload
+type ExternalRef string
+
+// UnmarshalJSON accepts a string, a number or null
+func (r *ExternalRef) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case nil:
+		*r = ""
+	case string:
+		*r = ExternalRef(v)
+	default:
+		*r = ExternalRef(strings.TrimSpace(string(data)))
+	}
+	return nil
+}
+
+// Item is a single instance or app subject to the approval, which is approved or denied
+// on its own. The dates of a decision are zero until it is made
 type Item struct {
-	Id int `json:"id"`
+	Id           int         `json:"id"`
+	Name         string      `json:"name"`
+	InternalId   ExternalRef `json:"internalId"`
+	ExternalId   ExternalRef `json:"externalId"`
+	ExternalName string      `json:"externalName"`
+	Status       ItemStatus  `json:"status"`
+	ApprovedBy   string      `json:"approvedBy"`
+	DeniedBy     string      `json:"deniedBy"`
+	ErrorMessage string      `json:"errorMessage"`
+	DateCreated  time.Time   `json:"dateCreated"`
+	LastUpdated  time.Time   `json:"lastUpdated"`
+	DateApproved time.Time   `json:"dateApproved"`
+	DateDenied   time.Time   `json:"dateDenied"`
+	Reference    Reference   `json:"reference"`
+}
+
+// Reference identifies the instance or app which an approval item is for
+type Reference struct {
+	Id          int           `json:"id"`
+	Type        ReferenceType `json:"type"`
+	Name        string        `json:"name"`
+	DisplayName string        `json:"displayName"`
+}
+
+// Details holds information about the subject of an approval which is not part of the
+// approval itself, and has been looked up from the Morpheus API
+type Details struct {
+	Priced      bool
+	MonthlyCost float64
+	Currency    string
+	Instances   []InstanceDetails
+}
+
+// InstanceDetails holds what we know about an instance subject to the approval, memory is in GB
+type InstanceDetails struct {
+	Name          string
+	InstanceType  string
+	Plan          string
+	Layout        string
+	Cores         int
+	Memory        float64
+	Labels        []string
+	Tags          map[string]string
+	CustomOptions map[string][]string
+}
+
+// SetMatchMode sets how the approval configs matching an approval are routed, one of
+// the internal.MATCH_MODE constants
+func SetMatchMode(mode string) {
+	mu.Lock()
+	defer mu.Unlock()
+	matchMode = mode
+}
+
+// SetTemplateFolder sets the folder in which the email templates are found
+func SetTemplateFolder(folder string) {
+	mu.Lock()
+	defer mu.Unlock()
+	templateFolder = folder
+}
+
+// TemplateFolder returns the folder in which the email templates are found
+func TemplateFolder() string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return templateFolder
 }
 
 // ReadAndParseConfig reads the contents of the YAML approvals config filer
-// and parses it to a map of Approval structs
+// and parses it to a map of Approval structs, unknown keys are reported as problems
 func ReadAndParseConfig(cfgFile string) error {
-	yamlConfig, err := os.ReadFile(cfgFile)
+	parsed, src, err := parseConfig(cfgFile)
 	if err != nil {
 		return err
 	}
 
-	if err := yaml.Unmarshal(yamlConfig, &config); err != nil {
+	mu.Lock()
+	defer mu.Unlock()
+	config = parsed
+	source = src
+	version++
+
+	return nil
+}
+
+// ValidateConfig will check that the config parsed can be used by application, every
+// problem found is reported, with the line in the file where it was read from one. A copy
+// of the config is checked, which replaces the config in use unless it has been reloaded since
+func ValidateConfig() error {
+	mu.RLock()
+	checked := append(ApprovalsConfig(nil), config...)
+	src, checkedVersion := source, version
+	mu.RUnlock()
+
+	if err := checked.check(src); err != nil {
 		return err
 	}
 
+	mu.Lock()
+	defer mu.Unlock()
+	if version == checkedVersion {
+		config = checked
+	}
 	return nil
 }
 
-// ValidateConfig will check that the config parsed can be used by application
-func ValidateConfig() error {
-	for i := range config {
+// Validate checks that the approval configs can be used by the application, compiling
+// any scope match expressions. The error returned is Problems listing everything wrong
+func (c ApprovalsConfig) Validate() error {
+	return c.check(Source{})
+}
+
+func (c ApprovalsConfig) check(src Source) error {
+	var problems Problems
+	add := func(path string, err error, detail string) {
+		problems = append(problems, Problem{File: src.File, Line: src.line(path), Path: path, Err: err, Detail: detail})
+	}
+
+	var hasDefault bool
+
+	for i := range c {
+		path := fmt.Sprintf("approval[%d]", i)
+
 		// check description
-		if config[i].Description == "" {
-			return ERR_NO_DESCRIPTION
+		if c[i].Description == "" {
+			add(path+".description", ERR_NO_DESCRIPTION, "")
 		}
 
 		// check has action
-		if !config[i].OnProvision && !config[i].OnDelete && !config[i].OnReconfigure {
-			return ERR_NO_ACTION
+		if !c[i].OnProvision && !c[i].OnDelete && !c[i].OnReconfigure {
+			add(path, ERR_NO_ACTION, "")
 		}
 
 		// if template configured check it exists
-		if config[i].TemplateFile != "" {
-			tmplFile := fmt.Sprintf("%s/%s", internal.TEMPLATE_FOLDER, config[i].TemplateFile)
+		if c[i].TemplateFile != "" {
+			tmplFile := filepath.Join(TemplateFolder(), c[i].TemplateFile)
 			if _, err := os.Stat(tmplFile); errors.Is(err, os.ErrNotExist) {
-				return ERR_TEMPLATE_NOT_EXIST
+				add(path+".template", ERR_TEMPLATE_NOT_EXIST, tmplFile)
 			}
 		}
 
 		// check at least one recipient
-		if len(config[i].RecipientList) == 0 {
-			return ERR_NO_RECIPIENTS
+		if len(c[i].RecipientList) == 0 {
+			add(path+".recipientList", ERR_NO_RECIPIENTS, "")
 		}
 
 		// check recipient email addresses seem valid
-		for _, email := range config[i].RecipientList {
+		for j, email := range c[i].RecipientList {
 			if _, err := mail.ParseAddress(email); err != nil {
-				return ERR_BAD_RECIPIENT_EMAIL
+				add(fmt.Sprintf("%s.recipientList[%d]", path, j), ERR_BAD_RECIPIENT_EMAIL, email)
 			}
 		}
 
 		// if scope is set we need to further validate that
-		scope := config[i].Scope
-		if scope != (Scope{}) {
-			var set bool
-			if scope.Group != "" {
-				set = true
-			}
+		scope := c[i].Scope
 
-			if scope.Cloud != "" {
-				if set {
-					return ERR_MULTIPLE_SCOPES
-				}
-				set = true
+		// only one default, which applies when nothing else matched so has no scope
+		if c[i].Default {
+			if hasDefault {
+				add(path+".default", ERR_MULTIPLE_DEFAULTS, "")
 			}
-
-			if scope.User != "" {
-				if set {
-					return ERR_MULTIPLE_SCOPES
-				}
-				set = true
+			if scope != (Scope{}) {
+				add(path+".scope", ERR_DEFAULT_HAS_SCOPE, "")
 			}
+			hasDefault = true
+		}
 
-			if scope.Role != "" {
-				if set {
-					return ERR_MULTIPLE_SCOPES
+		if scope != (Scope{}) {
+			var set []string
+			for _, s := range []struct{ key, value string }{
+				{"group", scope.Group},
+				{"cloud", scope.Cloud},
+				{"user", scope.User},
+				{"role", scope.Role},
+				{"network", scope.Network},
+				{"match", scope.Match},
+			} {
+				if s.value != "" {
+					set = append(set, s.key)
 				}
-				set = true
+			}
+			if len(set) > 1 {
+				add(path+".scope", ERR_MULTIPLE_SCOPES, strings.Join(set, ", "))
 			}
 
-			if scope.Network != "" {
-				if set {
-					return ERR_MULTIPLE_SCOPES
+			if scope.Match != "" {
+				match, err := compileMatch(scope.Match)
+				if err != nil {
+					add(path+".scope.match", ERR_BAD_SCOPE_MATCH, err.Error())
+					continue
 				}
-				set = true
+				c[i].match = match
 			}
 		}
 	}
 
-	return nil
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
 }
@@ -254,13 +254,52 @@ func TestValidateConfig(t *testing.T) {
 			},
 			wantErr: ERR_MULTIPLE_SCOPES,
 		},
+		{
+			name: "multiple defaults, should fail",
+			config: ApprovalsConfig{
+				{
+					ApprovalConfig{
+						Description:   "default 1",
+						OnProvision:   true,
+						RecipientList: []string{"test@test.com"},
+						Default:       true,
+					},
+				},
+				{
+					ApprovalConfig{
+						Description:   "default 2",
+						OnProvision:   true,
+						RecipientList: []string{"test@test.com"},
+						Default:       true,
+					},
+				},
+			},
+			wantErr: ERR_MULTIPLE_DEFAULTS,
+		},
+		{
+			name: "default with scope, should fail",
+			config: ApprovalsConfig{
+				{
+					ApprovalConfig{
+						Description:   "default",
+						OnProvision:   true,
+						RecipientList: []string{"test@test.com"},
+						Default:       true,
+						Scope: Scope{
+							Cloud: "Azure",
+						},
+					},
+				},
+			},
+			wantErr: ERR_DEFAULT_HAS_SCOPE,
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			config = tc.config
 			gotErr := ValidateConfig()
-			if gotErr != tc.wantErr {
+			if !errors.Is(gotErr, tc.wantErr) {
 				t.Errorf("wanted %v got %v", tc.wantErr, gotErr)
 			}
 		})
@@ -268,3 +307,69 @@ func TestValidateConfig(t *testing.T) {
 
 	removeTestTemplateFile(t)
 }
+
+func TestValidateConfig_ScopeMatch(t *testing.T) {
+	testCases := []struct {
+		name    string
+		match   string
+		group   string
+		wantErr error
+	}{
+		{
+			name:    "valid expression, should pass",
+			match:   "cloud in [AWS, Azure] and group != Sandbox",
+			wantErr: nil,
+		},
+		{
+			name:    "expression and group, should fail",
+			match:   "cloud == AWS",
+			group:   "All Clouds",
+			wantErr: ERR_MULTIPLE_SCOPES,
+		},
+		{
+			name:    "parse error, should fail",
+			match:   "cloud in AWS",
+			wantErr: ERR_BAD_SCOPE_MATCH,
+		},
+		{
+			name:    "unknown field, should fail",
+			match:   "colud == AWS",
+			wantErr: ERR_BAD_SCOPE_MATCH,
+		},
+		{
+			name:    "prefixed fields, should pass",
+			match:   "tag.environment == production and customOption.size in [large, xlarge]",
+			wantErr: nil,
+		},
+		{
+			name:    "prefix without name, should fail",
+			match:   "tag. == production",
+			wantErr: ERR_BAD_SCOPE_MATCH,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			config = ApprovalsConfig{
+				{
+					ApprovalConfig{
+						Description:   "test approval config 1",
+						OnProvision:   true,
+						RecipientList: []string{"test@test.com"},
+						Scope: Scope{
+							Group: tc.group,
+							Match: tc.match,
+						},
+					},
+				},
+			}
+			gotErr := ValidateConfig()
+			if !errors.Is(gotErr, tc.wantErr) {
+				t.Errorf("wanted %v got %v", tc.wantErr, gotErr)
+			}
+			if gotErr == nil && config[0].match == nil {
+				t.Error("wanted compiled match expression")
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package approval
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/expr"
+)
+
+const (
+	ACTION_PROVISION   = "provision"
+	ACTION_DELETE      = "delete"
+	ACTION_RECONFIGURE = "reconfigure"
+)
+
+// MatchFields are the fields which can be used in a scope match expression
+var MatchFields = []string{
+	"name",
+	"action",
+	"requestType",
+	"requestBy",
+	"group",
+	"cloud",
+	"user",
+	"role",
+	"network",
+	"appliance",
+	"tenant",
+	"cost",
+	"currency",
+	"instance",
+	"instanceType",
+	"plan",
+	"layout",
+	"cores",
+	"memory",
+	"label",
+}
+
+// MatchFieldPrefixes are prefixes of match fields which are named by the user, such as
+// 'tag.environment' for the value of the 'environment' tag
+var MatchFieldPrefixes = []string{
+	"tag.",
+	"customOption.",
+}
+
+// compileMatch compiles a scope match expression, checking it only refers to known fields
+func compileMatch(src string) (*expr.Expr, error) {
+	match, err := expr.Compile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, field := range match.Fields() {
+		if !isMatchField(field) {
+			return nil, fmt.Errorf("unknown field '%s', expected one of %s, or a name prefixed with %s",
+				field, strings.Join(MatchFields, ", "), strings.Join(MatchFieldPrefixes, " or "))
+		}
+	}
+
+	return match, nil
+}
+
+func isMatchField(field string) bool {
+	for _, f := range MatchFields {
+		if f == field {
+			return true
+		}
+	}
+	for _, prefix := range MatchFieldPrefixes {
+		if strings.HasPrefix(field, prefix) && len(field) > len(prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Action returns the action which the approval was requested for, derived from the request type
+func (a Approval) Action() string {
+	requestType := strings.ToLower(a.RequestType)
+	switch {
+	case strings.Contains(requestType, ACTION_DELETE):
+		return ACTION_DELETE
+	case strings.Contains(requestType, ACTION_RECONFIGURE):
+		return ACTION_RECONFIGURE
+	default:
+		return ACTION_PROVISION
+	}
+}
+
+// Facts returns the values known about the approval which scopes are matched against
+func (a Approval) Facts() expr.Facts {
+	facts := expr.Facts{}
+	facts.Set("name", a.Name)
+	facts.Set("action", a.Action())
+	facts.Set("requestType", a.RequestType)
+	facts.Set("requestBy", a.RequestBy)
+	facts.Set("group", a.Scope.Group)
+	facts.Set("cloud", a.Scope.Cloud)
+	facts.Set("user", a.Scope.User)
+	facts.Set("role", a.Scope.Role)
+	facts.Set("network", a.Scope.Network)
+	facts.Set("appliance", a.Appliance)
+	facts.Set("tenant", a.Account.Name)
+
+	// estimated monthly cost, only set when the price is known
+	if a.Details.Priced {
+		facts.Set("cost", strconv.FormatFloat(a.Details.MonthlyCost, 'f', 2, 64))
+		facts.Set("currency", a.Details.Currency)
+	}
+
+	// instance facts hold a value for each instance, so a condition is satisfied if any
+	// instance subject to the approval satisfies it
+	for _, inst := range a.Details.Instances {
+		facts.Add("instance", inst.Name)
+		facts.Add("instanceType", inst.InstanceType)
+		facts.Add("plan", inst.Plan)
+		facts.Add("layout", inst.Layout)
+		if inst.Cores > 0 {
+			facts.Add("cores", strconv.Itoa(inst.Cores))
+		}
+		if inst.Memory > 0 {
+			facts.Add("memory", strconv.FormatFloat(inst.Memory, 'f', -1, 64))
+		}
+		facts.Add("label", inst.Labels...)
+		for name, value := range inst.Tags {
+			facts.Add("tag."+name, value)
+		}
+		for name, values := range inst.CustomOptions {
+			facts.Add("customOption."+name, values...)
+		}
+	}
+
+	return facts
+}
+
+// Matches reports whether the approval configuration applies to the approval, the action
+// must be enabled and the approval must be in scope
+func (ac *ApprovalConfig) Matches(a Approval) bool {
+	switch a.Action() {
+	case ACTION_PROVISION:
+		if !ac.OnProvision {
+			return false
+		}
+	case ACTION_DELETE:
+		if !ac.OnDelete {
+			return false
+		}
+	case ACTION_RECONFIGURE:
+		if !ac.OnReconfigure {
+			return false
+		}
+	}
+
+	return ac.inScope(a.Facts())
+}
+
+// inScope checks the facts against the scope, an empty scope is global
+func (ac *ApprovalConfig) inScope(facts expr.Facts) bool {
+	scope := ac.Scope
+	if scope.Appliance != "" && !strings.EqualFold(facts.Get("appliance"), scope.Appliance) {
+		return false
+	}
+	if scope.Tenant != "" && !strings.EqualFold(facts.Get("tenant"), scope.Tenant) {
+		return false
+	}
+
+	switch {
+	case scope.Group != "":
+		return strings.EqualFold(facts.Get("group"), scope.Group)
+	case scope.Cloud != "":
+		return strings.EqualFold(facts.Get("cloud"), scope.Cloud)
+	case scope.User != "":
+		return strings.EqualFold(facts.Get("user"), scope.User)
+	case scope.Role != "":
+		return strings.EqualFold(facts.Get("role"), scope.Role)
+	case scope.Network != "":
+		return strings.EqualFold(facts.Get("network"), scope.Network)
+	case scope.Match != "":
+		// the expression is compiled when the configuration is validated
+		return ac.match != nil && ac.match.Eval(facts)
+	}
+	return true
+}
+
+// Match returns the approval configurations which apply to the approval, in priority order.
+// Evaluation stops at the first matching config with StopOnMatch set, and the default config
+// is returned only if no other config matched
+func (c ApprovalsConfig) Match(a Approval) []ApprovalConfig {
+	var matched []ApprovalConfig
+	var defaults []ApprovalConfig
+
+	for _, i := range c.byPriority() {
+		ac := c[i].ApprovalConfig
+		if ac.Default {
+			defaults = append(defaults, ac)
+			continue
+		}
+		if ac.Matches(a) {
+			matched = append(matched, ac)
+			if ac.StopOnMatch {
+				break
+			}
+		}
+	}
+
+	if len(matched) == 0 {
+		for _, ac := range defaults {
+			if ac.Matches(a) {
+				matched = append(matched, ac)
+			}
+		}
+	}
+
+	return matched
+}
+
+// byPriority returns the indexes of the configs ordered highest priority first,
+// keeping the file order for configs of the same priority
+func (c ApprovalsConfig) byPriority() []int {
+	order := make([]int, len(c))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(x, y int) bool {
+		return c[order[x]].Priority > c[order[y]].Priority
+	})
+	return order
+}
+
+// Route is a notification of an approval to a set of recipients, produced from one or
+// more matching approval configs depending on the match mode
+type Route struct {
+	Configs    []ApprovalConfig
+	Recipients []string
+	Version    int
+}
+
+// Descriptions returns the descriptions of the approval configs in the route
+func (r Route) Descriptions() []string {
+	descriptions := make([]string, 0, len(r.Configs))
+	for _, ac := range r.Configs {
+		descriptions = append(descriptions, ac.Description)
+	}
+	return descriptions
+}
+
+// Route returns the routes for the approval according to the match mode, 'all' gives
+// a route for each matching config, 'first' a route for the highest priority match only
+// and 'combined' a single route with the recipients of every match merged
+func (c ApprovalsConfig) Route(a Approval, mode string) []Route {
+	matched := c.Match(a)
+	if len(matched) == 0 {
+		return nil
+	}
+
+	switch mode {
+	case internal.MATCH_MODE_FIRST:
+		return []Route{newRoute(matched[:1])}
+	case internal.MATCH_MODE_COMBINED:
+		return []Route{newRoute(matched)}
+	default:
+		routes := make([]Route, 0, len(matched))
+		for i := range matched {
+			routes = append(routes, newRoute(matched[i:i+1]))
+		}
+		return routes
+	}
+}
+
+// newRoute creates a route for the configs, recipients are de-duplicated ignoring case
+func newRoute(configs []ApprovalConfig) Route {
+	route := Route{Configs: configs}
+	seen := map[string]bool{}
+	for _, ac := range configs {
+		for _, recipient := range ac.RecipientList {
+			key := strings.ToLower(recipient)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			route.Recipients = append(route.Recipients, recipient)
+		}
+	}
+	return route
+}
+
+// Match returns the approval configurations loaded from the approvals config file which
+// apply to the approval
+func Match(a Approval) []ApprovalConfig {
+	current, _ := Snapshot()
+	return current.Match(a)
+}
+
+// RouteApproval returns the routes for the approval using the loaded approvals config file
+// and the configured match mode, each route records the version of the config used
+func RouteApproval(a Approval) []Route {
+	mu.RLock()
+	current, ver, mode := config, version, matchMode
+	mu.RUnlock()
+
+	routes := current.Route(a, mode)
+	for i := range routes {
+		routes[i].Version = ver
+	}
+	return routes
+}
@@ -0,0 +1,307 @@
+package approval
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/spoonboy-io/link/internal"
+)
+
+func TestMatch(t *testing.T) {
+	config = ApprovalsConfig{
+		{
+			ApprovalConfig{
+				Description:   "global provision",
+				OnProvision:   true,
+				RecipientList: []string{"ops@test.io"},
+			},
+		},
+		{
+			ApprovalConfig{
+				Description:   "azure group",
+				OnProvision:   true,
+				OnDelete:      true,
+				RecipientList: []string{"azure@test.io"},
+				Scope: Scope{
+					Cloud: "azure",
+				},
+			},
+		},
+		{
+			ApprovalConfig{
+				Description:   "public cloud outside sandbox",
+				OnProvision:   true,
+				OnDelete:      true,
+				RecipientList: []string{"cloud@test.io"},
+				Scope: Scope{
+					Match: "cloud in [AWS, Azure] and group != Sandbox",
+				},
+			},
+		},
+		{
+			ApprovalConfig{
+				Description:   "finance over 1000 per month",
+				OnProvision:   true,
+				RecipientList: []string{"finance@test.io"},
+				Scope: Scope{
+					Match: "cost > 1000 and currency == USD",
+				},
+			},
+		},
+		{
+			ApprovalConfig{
+				Description:   "gpu or production tagged",
+				OnProvision:   true,
+				RecipientList: []string{"gpu@test.io"},
+				Scope: Scope{
+					Match: `plan like "*GPU*" or (tag.environment == production and customOption.dataClass in [confidential, secret])`,
+				},
+			},
+		},
+		{
+			ApprovalConfig{
+				Description:   "large vm",
+				OnProvision:   true,
+				RecipientList: []string{"capacity@test.io"},
+				Scope: Scope{
+					Match: "cores >= 16 or memory > 64 or label == large",
+				},
+			},
+		},
+		{
+			ApprovalConfig{
+				Description:   "emea on premise deletes",
+				OnDelete:      true,
+				RecipientList: []string{"emea@test.io"},
+				Scope: Scope{
+					Cloud:     "VMware",
+					Appliance: "emea",
+				},
+			},
+		},
+		{
+			ApprovalConfig{
+				Description:   "acme tenant deletes",
+				OnDelete:      true,
+				RecipientList: []string{"acme@test.io"},
+				Scope: Scope{
+					Match: "tenant == Acme and appliance != emea",
+				},
+			},
+		},
+	}
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("could not validate test config %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		approval Approval
+		want     []string
+	}{
+		{
+			name:     "provision in azure production",
+			approval: Approval{RequestType: "Instance Approval", Scope: Scope{Cloud: "Azure", Group: "Production"}},
+			want:     []string{"global provision", "azure group", "public cloud outside sandbox"},
+		},
+		{
+			name:     "provision in azure sandbox",
+			approval: Approval{RequestType: "Instance Approval", Scope: Scope{Cloud: "Azure", Group: "Sandbox"}},
+			want:     []string{"global provision", "azure group"},
+		},
+		{
+			name: "provision over cost threshold",
+			approval: Approval{
+				RequestType: "Instance Approval",
+				Scope:       Scope{Cloud: "VMware"},
+				Details:     Details{Priced: true, MonthlyCost: 1460, Currency: "USD"},
+			},
+			want: []string{"global provision", "finance over 1000 per month"},
+		},
+		{
+			name: "provision under cost threshold",
+			approval: Approval{
+				RequestType: "Instance Approval",
+				Scope:       Scope{Cloud: "VMware"},
+				Details:     Details{Priced: true, MonthlyCost: 999.99, Currency: "USD"},
+			},
+			want: []string{"global provision"},
+		},
+		{
+			name: "provision gpu plan",
+			approval: Approval{
+				RequestType: "Instance Approval",
+				Scope:       Scope{Cloud: "VMware"},
+				Details: Details{Instances: []InstanceDetails{
+					{Name: "web", Plan: "Small", Cores: 2, Memory: 4},
+					{Name: "ml", Plan: "G4 GPU Large", Cores: 8, Memory: 32},
+				}},
+			},
+			want: []string{"global provision", "gpu or production tagged"},
+		},
+		{
+			name: "provision production confidential",
+			approval: Approval{
+				RequestType: "Instance Approval",
+				Scope:       Scope{Cloud: "VMware"},
+				Details: Details{Instances: []InstanceDetails{
+					{
+						Plan:          "Large",
+						Cores:         16,
+						Memory:        64,
+						Tags:          map[string]string{"environment": "Production"},
+						CustomOptions: map[string][]string{"dataClass": {"confidential"}},
+					},
+				}},
+			},
+			want: []string{"global provision", "gpu or production tagged", "large vm"},
+		},
+		{
+			name: "provision production public",
+			approval: Approval{
+				RequestType: "Instance Approval",
+				Scope:       Scope{Cloud: "VMware"},
+				Details: Details{Instances: []InstanceDetails{
+					{
+						Plan:          "Small",
+						Labels:        []string{"large"},
+						Tags:          map[string]string{"environment": "production"},
+						CustomOptions: map[string][]string{"dataClass": {"public"}},
+					},
+				}},
+			},
+			want: []string{"global provision", "large vm"},
+		},
+		{
+			name:     "delete in aws",
+			approval: Approval{RequestType: "Delete Approval", Scope: Scope{Cloud: "AWS", Group: "Production"}},
+			want:     []string{"public cloud outside sandbox"},
+		},
+		{
+			name:     "delete on premise",
+			approval: Approval{RequestType: "Delete Approval", Scope: Scope{Cloud: "VMware"}},
+			want:     nil,
+		},
+		{
+			name:     "delete on premise in emea",
+			approval: Approval{RequestType: "Delete Approval", Scope: Scope{Cloud: "VMware"}, Appliance: "emea", Account: Ref{Name: "Acme"}},
+			want:     []string{"emea on premise deletes"},
+		},
+		{
+			name:     "delete in acme tenant",
+			approval: Approval{RequestType: "Delete Approval", Scope: Scope{Cloud: "VMware"}, Appliance: "default", Account: Ref{Name: "acme"}},
+			want:     []string{"acme tenant deletes"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, ac := range Match(tc.approval) {
+				got = append(got, ac.Description)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wanted %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestMatch_PriorityAndDefault(t *testing.T) {
+	testConfig := ApprovalsConfig{
+		{
+			ApprovalConfig{
+				Description:   "catch all",
+				OnProvision:   true,
+				RecipientList: []string{"ops@test.io"},
+				Default:       true,
+			},
+		},
+		{
+			ApprovalConfig{
+				Description:   "azure",
+				OnProvision:   true,
+				RecipientList: []string{"azure@test.io", "ops@test.io"},
+				Scope:         Scope{Cloud: "Azure"},
+			},
+		},
+		{
+			ApprovalConfig{
+				Description:   "expensive",
+				OnProvision:   true,
+				RecipientList: []string{"finance@test.io", "Azure@test.io"},
+				Scope:         Scope{Match: "cost > 1000"},
+				Priority:      10,
+			},
+		},
+		{
+			ApprovalConfig{
+				Description:   "very expensive",
+				OnProvision:   true,
+				RecipientList: []string{"cfo@test.io"},
+				Scope:         Scope{Match: "cost > 10000"},
+				Priority:      20,
+				StopOnMatch:   true,
+			},
+		},
+	}
+	config = testConfig
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("could not validate test config %v", err)
+	}
+	testConfig, _ = Snapshot()
+
+	azure := Approval{Scope: Scope{Cloud: "Azure"}, Details: Details{Priced: true, MonthlyCost: 2000}}
+	veryExpensive := Approval{Scope: Scope{Cloud: "Azure"}, Details: Details{Priced: true, MonthlyCost: 20000}}
+	onPremise := Approval{Scope: Scope{Cloud: "VMware"}}
+
+	testCases := []struct {
+		name     string
+		approval Approval
+		mode     string
+		want     [][]string
+	}{
+		{
+			name:     "all, priority order",
+			approval: azure,
+			mode:     internal.MATCH_MODE_ALL,
+			want:     [][]string{{"finance@test.io", "Azure@test.io"}, {"azure@test.io", "ops@test.io"}},
+		},
+		{
+			name:     "first, highest priority only",
+			approval: azure,
+			mode:     internal.MATCH_MODE_FIRST,
+			want:     [][]string{{"finance@test.io", "Azure@test.io"}},
+		},
+		{
+			name:     "combined, recipients merged",
+			approval: azure,
+			mode:     internal.MATCH_MODE_COMBINED,
+			want:     [][]string{{"finance@test.io", "Azure@test.io", "ops@test.io"}},
+		},
+		{
+			name:     "stop on match",
+			approval: veryExpensive,
+			mode:     internal.MATCH_MODE_ALL,
+			want:     [][]string{{"cfo@test.io"}},
+		},
+		{
+			name:     "nothing matched, default",
+			approval: onPremise,
+			mode:     internal.MATCH_MODE_ALL,
+			want:     [][]string{{"ops@test.io"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got [][]string
+			for _, route := range testConfig.Route(tc.approval, tc.mode) {
+				got = append(got, route.Recipients)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wanted %v got %v", tc.want, got)
+			}
+		})
+	}
+}
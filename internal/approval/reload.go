@@ -0,0 +1,98 @@
+package approval
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Snapshot returns the approvals config in use and its version, the config returned must
+// not be modified
+func Snapshot() (ApprovalsConfig, int) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return config, version
+}
+
+// Reload reads, parses and validates the approvals config file, replacing the config in
+// use only if it is valid. The changes made are returned, approvals already routed keep
+// the config version they were routed with
+func Reload(cfgFile string) ([]string, error) {
+	parsed, src, err := parseConfig(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parsed.check(src); err != nil {
+		return nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	changes := diffConfig(config, parsed)
+	if len(changes) == 0 {
+		return changes, nil
+	}
+	config = parsed
+	source = src
+	version++
+
+	return changes, nil
+}
+
+// diffConfig describes the approval configs added, removed and changed between two
+// configs, approval configs are identified by their description
+func diffConfig(before, after ApprovalsConfig) []string {
+	var changes []string
+
+	index := map[string]ApprovalConfig{}
+	for _, ac := range before {
+		index[ac.Description] = ac.ApprovalConfig
+	}
+
+	seen := map[string]bool{}
+	for i, ac := range after {
+		seen[ac.Description] = true
+		old, ok := index[ac.Description]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("added '%s'", ac.Description))
+			continue
+		}
+		if !sameConfig(old, ac.ApprovalConfig) {
+			changes = append(changes, fmt.Sprintf("changed '%s'", ac.Description))
+			continue
+		}
+		if i >= len(before) || before[i].Description != ac.Description {
+			changes = append(changes, fmt.Sprintf("moved '%s'", ac.Description))
+		}
+	}
+
+	for _, ac := range before {
+		if !seen[ac.Description] {
+			changes = append(changes, fmt.Sprintf("removed '%s'", ac.Description))
+		}
+	}
+
+	return changes
+}
+
+// sameConfig compares approval configs ignoring the compiled match expression
+func sameConfig(a, b ApprovalConfig) bool {
+	a.match, b.match = nil, nil
+	return reflect.DeepEqual(a, b)
+}
+
+// LoadConfig reads, parses and validates an approvals config file without replacing the
+// config in use, for evaluating a candidate config
+func LoadConfig(cfgFile string) (ApprovalsConfig, error) {
+	parsed, src, err := parseConfig(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := parsed.check(src); err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
@@ -0,0 +1,92 @@
+package approval
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	writeTestYamlFile(t)
+	defer removeTestYamlFile(t)
+
+	if err := ReadAndParseConfig(testYamlFile); err != nil {
+		t.Fatalf("could not read test yaml file %+v", err)
+	}
+	_, startVersion := Snapshot()
+	routed := Match(Approval{RequestType: "Reconfigure Approval"})
+
+	// valid change, swapped in and reported
+	data := `---
+- approval:
+    description: test approval config 2
+    onReconfigure: true
+    recipientList:
+        - ollie@test.io
+        - new@test.io
+
+- approval:
+    description: test approval config 3
+    onDelete: true
+    recipientList:
+        - ollie@test.io`
+	if err := os.WriteFile(testYamlFile, []byte(data), 0644); err != nil {
+		t.Fatalf("could not write test yaml file %+v", err)
+	}
+
+	changes, err := Reload(testYamlFile)
+	if err != nil {
+		t.Fatalf("wanted no error got %v", err)
+	}
+	wantChanges := []string{"changed 'test approval config 2'", "added 'test approval config 3'", "removed 'test approval config 1'"}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("wanted %v got %v", wantChanges, changes)
+	}
+
+	current, ver := Snapshot()
+	if ver != startVersion+1 || len(current) != 2 {
+		t.Errorf("wanted version %d with 2 configs got version %d with %d", startVersion+1, ver, len(current))
+	}
+
+	// previously routed approvals keep the config they were routed with
+	if len(routed) != 1 || len(routed[0].RecipientList) != 1 {
+		t.Errorf("routed config was modified by reload %+v", routed)
+	}
+
+	// invalid change, rejected and the config in use kept
+	if err := os.WriteFile(testYamlFile, []byte("---\n- approval:\n    description: no action\n"), 0644); err != nil {
+		t.Fatalf("could not write test yaml file %+v", err)
+	}
+	if _, err := Reload(testYamlFile); !errors.Is(err, ERR_NO_ACTION) {
+		t.Errorf("wanted %v got %v", ERR_NO_ACTION, err)
+	}
+	if _, got := Snapshot(); got != ver {
+		t.Errorf("wanted version %d got %d", ver, got)
+	}
+}
+
+func TestValidateConfig_Snapshot(t *testing.T) {
+	mu.Lock()
+	config = ApprovalsConfig{{ApprovalConfig{
+		Description:   "test approval config 1",
+		OnProvision:   true,
+		RecipientList: []string{"test@test.com"},
+		Scope:         Scope{Match: "cloud == AWS"},
+	}}}
+	mu.Unlock()
+
+	// a config in use by routing is replaced, never modified, by validation
+	before, ver := Snapshot()
+	if err := ValidateConfig(); err != nil {
+		t.Fatalf("wanted no error got %v", err)
+	}
+	if before[0].match != nil {
+		t.Error("validation modified the config in use")
+	}
+
+	after, afterVer := Snapshot()
+	if after[0].match == nil || afterVer != ver {
+		t.Errorf("wanted the validated config at version %d got version %d %+v", ver, afterVer, after)
+	}
+}
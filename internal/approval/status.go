@@ -0,0 +1,109 @@
+package approval
+
+import (
+	"strconv"
+	"strings"
+)
+
+// ItemStatus is the status of an approval item, an item is requested until it is decided
+type ItemStatus string
+
+const (
+	ITEM_REQUESTED ItemStatus = "requested"
+	ITEM_APPROVED  ItemStatus = "approved"
+	ITEM_DENIED    ItemStatus = "denied"
+	ITEM_CANCELLED ItemStatus = "cancelled"
+)
+
+// Status is the state of an approval as a whole, derived from the status of its items
+type Status string
+
+const (
+	// STATUS_REQUESTED is an approval with items still to be decided
+	STATUS_REQUESTED Status = "requested"
+	STATUS_APPROVED  Status = "approved"
+	STATUS_DENIED    Status = "denied"
+	STATUS_CANCELLED Status = "cancelled"
+	STATUS_UNKNOWN   Status = "unknown"
+)
+
+// ReferenceType is the type of object an approval item is for
+type ReferenceType string
+
+const (
+	REFERENCE_INSTANCE ReferenceType = "instance"
+	REFERENCE_APP      ReferenceType = "app"
+)
+
+// itemStatusAliases are spellings of item statuses seen in approval statuses
+var itemStatusAliases = map[string]ItemStatus{
+	"rejected": ITEM_DENIED,
+	"canceled": ITEM_CANCELLED,
+}
+
+// ParseItemStatus normalises the status of an item
+func ParseItemStatus(s string) ItemStatus {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if alias, ok := itemStatusAliases[s]; ok {
+		return alias
+	}
+	return ItemStatus(s)
+}
+
+// Decided reports whether the item has been approved, denied or cancelled
+func (s ItemStatus) Decided() bool {
+	return s == ITEM_APPROVED || s == ITEM_DENIED || s == ITEM_CANCELLED
+}
+
+// CanTransition reports whether an item with the status can move to next, a requested item
+// can be decided once and a decision is final
+func (s ItemStatus) CanTransition(next ItemStatus) bool {
+	return ParseItemStatus(string(s)) == ITEM_REQUESTED && next.Decided()
+}
+
+// ItemCounts parses an approval status, e.g. '1 approved, 2 requested', counting the items
+// in each status
+func ItemCounts(status string) map[ItemStatus]int {
+	counts := map[ItemStatus]int{}
+	for _, part := range strings.Split(status, ",") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+		n, err := strconv.Atoi(fields[0])
+		if err != nil {
+			// a status without a count is a single item
+			counts[ParseItemStatus(strings.Join(fields, " "))]++
+			continue
+		}
+		counts[ParseItemStatus(strings.Join(fields[1:], " "))] += n
+	}
+	return counts
+}
+
+// ParseStatus returns the state of an approval from its status. An approval is requested while
+// any item is, once decided any denial denies it, then any cancellation cancels it
+func ParseStatus(status string) Status {
+	counts := ItemCounts(status)
+	switch {
+	case counts[ITEM_REQUESTED] > 0:
+		return STATUS_REQUESTED
+	case counts[ITEM_DENIED] > 0:
+		return STATUS_DENIED
+	case counts[ITEM_CANCELLED] > 0:
+		return STATUS_CANCELLED
+	case counts[ITEM_APPROVED] > 0:
+		return STATUS_APPROVED
+	}
+	return STATUS_UNKNOWN
+}
+
+// State returns the state of the approval from its status
+func (a Approval) State() Status {
+	return ParseStatus(a.Status)
+}
+
+// Resolved reports whether the approval has been decided
+func (s Status) Resolved() bool {
+	return s == STATUS_APPROVED || s == STATUS_DENIED || s == STATUS_CANCELLED
+}
@@ -0,0 +1,100 @@
+package approval
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestParseStatus(t *testing.T) {
+	testCases := []struct {
+		name     string
+		status   string
+		want     Status
+		counts   map[ItemStatus]int
+		resolved bool
+	}{
+		{"requested", "1 requested", STATUS_REQUESTED, map[ItemStatus]int{ITEM_REQUESTED: 1}, false},
+		{"partly decided", "1 approved, 2 requested", STATUS_REQUESTED, map[ItemStatus]int{ITEM_APPROVED: 1, ITEM_REQUESTED: 2}, false},
+		{"approved", "2 approved", STATUS_APPROVED, map[ItemStatus]int{ITEM_APPROVED: 2}, true},
+		{"denied wins", "1 approved, 1 denied", STATUS_DENIED, map[ItemStatus]int{ITEM_APPROVED: 1, ITEM_DENIED: 1}, true},
+		{"rejected is denied", "1 Rejected", STATUS_DENIED, map[ItemStatus]int{ITEM_DENIED: 1}, true},
+		{"cancelled", "1 canceled", STATUS_CANCELLED, map[ItemStatus]int{ITEM_CANCELLED: 1}, true},
+		{"no count", "approved", STATUS_APPROVED, map[ItemStatus]int{ITEM_APPROVED: 1}, true},
+		{"empty", "", STATUS_UNKNOWN, map[ItemStatus]int{}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := ParseStatus(tc.status); got != tc.want {
+				t.Errorf("wanted '%s' got '%s'", tc.want, got)
+			}
+			if got := ItemCounts(tc.status); !reflect.DeepEqual(got, tc.counts) {
+				t.Errorf("wanted counts %v got %v", tc.counts, got)
+			}
+			if got := (Approval{Status: tc.status}).State(); got != tc.want {
+				t.Errorf("wanted state '%s' got '%s'", tc.want, got)
+			}
+			if got := ParseStatus(tc.status).Resolved(); got != tc.resolved {
+				t.Errorf("wanted resolved %v got %v", tc.resolved, got)
+			}
+		})
+	}
+}
+
+func TestItemStatus_CanTransition(t *testing.T) {
+	testCases := []struct {
+		from, to ItemStatus
+		want     bool
+	}{
+		{ITEM_REQUESTED, ITEM_APPROVED, true},
+		{ITEM_REQUESTED, ITEM_DENIED, true},
+		{ITEM_REQUESTED, ITEM_CANCELLED, true},
+		{"Requested", ITEM_APPROVED, true},
+		{ITEM_REQUESTED, ITEM_REQUESTED, false},
+		{ITEM_APPROVED, ITEM_DENIED, false},
+		{ITEM_DENIED, ITEM_APPROVED, false},
+		{ITEM_CANCELLED, ITEM_APPROVED, false},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.from.CanTransition(tc.to); got != tc.want {
+			t.Errorf("%s to %s wanted %v got %v", tc.from, tc.to, tc.want, got)
+		}
+	}
+}
+
+func TestApproval_Unmarshal(t *testing.T) {
+	data := `{
+		"id": 7, "name": "APPROVAL-0000007", "internalId": null, "externalId": 12345,
+		"externalName": "CHG0001", "requestType": "Instance Approval", "status": "1 approved",
+		"dateCreated": "2022-03-01T10:00:00Z", "requestBy": "ollie",
+		"approver": {"id": 2, "name": "ServiceNow"}, "account": {"id": 1, "name": "Acme"},
+		"approvalItems": [{
+			"id": 70, "name": "web-1", "internalId": "INT-1", "status": "approved",
+			"approvedBy": "alice", "dateApproved": "2022-03-01T11:00:00Z",
+			"reference": {"id": 11, "type": "instance", "name": "web-1", "displayName": "Web 1"}
+		}]
+	}`
+
+	var a Approval
+	if err := json.Unmarshal([]byte(data), &a); err != nil {
+		t.Fatalf("could not unmarshal %v", err)
+	}
+	if a.InternalId != "" || a.ExternalId != "12345" || a.Approver.Name != "ServiceNow" || a.Account.Name != "Acme" {
+		t.Errorf("unexpected approval %+v", a)
+	}
+	if a.DateCreated.IsZero() || a.State() != STATUS_APPROVED {
+		t.Errorf("wanted a dated approved approval got %+v", a)
+	}
+	if len(a.Items) != 1 {
+		t.Fatalf("wanted 1 item got %d", len(a.Items))
+	}
+	item := a.Items[0]
+	if item.Status != ITEM_APPROVED || item.ApprovedBy != "alice" || item.DateApproved.IsZero() || item.InternalId != "INT-1" {
+		t.Errorf("unexpected item %+v", item)
+	}
+	if item.Reference.Type != REFERENCE_INSTANCE || item.Reference.DisplayName != "Web 1" {
+		t.Errorf("unexpected reference %+v", item.Reference)
+	}
+}
@@ -0,0 +1,286 @@
+package approval
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/spoonboy-io/link/internal"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	ERR_BAD_YAML    = errors.New("YAML is not valid")
+	ERR_UNKNOWN_KEY = errors.New("Unknown key")
+	ERR_BAD_VALUE   = errors.New("Value is not of the expected type")
+)
+
+// Source is the file an approvals config was read from, with the line on which each
+// value was found, keyed by path, for example 'approval[2].recipientList[1]'
+type Source struct {
+	File  string
+	Lines map[string]int
+}
+
+// line returns the line of the value at path, or of its nearest parent if the value
+// was not in the file, 0 is returned if the line is not known
+func (s Source) line(path string) int {
+	for path != "" {
+		if line, ok := s.Lines[path]; ok {
+			return line
+		}
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+// Problem is a single problem found in the approvals config
+type Problem struct {
+	File   string
+	Line   int
+	Path   string
+	Err    error
+	Detail string
+}
+
+// Error formats the problem as 'approvals.yaml:14: approval[2].recipientList[1]: message (detail)'
+func (p Problem) Error() string {
+	var sb strings.Builder
+	if p.File != "" {
+		sb.WriteString(p.File)
+		if p.Line > 0 {
+			sb.WriteString(fmt.Sprintf(":%d", p.Line))
+		}
+		sb.WriteString(": ")
+	}
+	if p.Path != "" {
+		sb.WriteString(p.Path + ": ")
+	}
+	sb.WriteString(p.Err.Error())
+	if p.Detail != "" {
+		sb.WriteString(fmt.Sprintf(" (%s)", p.Detail))
+	}
+	return sb.String()
+}
+
+// Unwrap returns the sentinel error describing the problem
+func (p Problem) Unwrap() error {
+	return p.Err
+}
+
+// Problems is every problem found in the approvals config
+type Problems []Problem
+
+// Error lists the problems, one per line
+func (p Problems) Error() string {
+	lines := make([]string, 0, len(p))
+	for _, problem := range p {
+		lines = append(lines, problem.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Is reports whether any of the problems is target, so errors.Is can be used to check
+// for a particular problem
+func (p Problems) Is(target error) bool {
+	for _, problem := range p {
+		if errors.Is(problem, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseConfig reads and parses the YAML approvals config file, or the approvals set inline in
+// the application config file, recording the line of each
+// value. Keys which are not part of the approval config, typically typos, are problems
+// since they would otherwise silently change the behaviour of an approval config
+func parseConfig(cfgFile string) (ApprovalsConfig, Source, error) {
+	var parsed ApprovalsConfig
+	src := Source{File: cfgFile, Lines: map[string]int{}}
+
+	yamlConfig, err := os.ReadFile(cfgFile)
+	if err != nil {
+		return parsed, src, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(yamlConfig, &root); err != nil {
+		return parsed, src, Problems{{File: cfgFile, Err: ERR_BAD_YAML, Detail: strings.TrimPrefix(err.Error(), "yaml: ")}}
+	}
+
+	// an empty file is an empty config
+	if len(root.Content) == 0 {
+		return parsed, src, nil
+	}
+	doc := inlineApprovals(root.Content[0])
+
+	var problems Problems
+	if doc.Kind != yaml.SequenceNode {
+		problems = append(problems, Problem{File: cfgFile, Line: doc.Line, Err: ERR_BAD_YAML, Detail: "expected a list of approvals"})
+		return parsed, src, problems
+	}
+
+	itemType := reflect.TypeOf(parsed).Elem()
+	for i, item := range doc.Content {
+		problems = append(problems, walk(item, itemType, fmt.Sprintf("approval[%d]", i), src)...)
+	}
+
+	if err := doc.Decode(&parsed); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return parsed, src, append(problems, Problem{File: cfgFile, Err: ERR_BAD_YAML, Detail: err.Error()})
+		}
+		for _, msg := range typeErr.Errors {
+			problems = append(problems, Problem{File: cfgFile, Err: ERR_BAD_VALUE, Detail: msg})
+		}
+	}
+
+	if len(problems) > 0 {
+		return parsed, src, problems
+	}
+	return parsed, src, nil
+}
+
+// inlineApprovals returns the approvals set inline under routing.approvals when the file is
+// the application YAML config file, otherwise the document itself
+func inlineApprovals(doc *yaml.Node) *yaml.Node {
+	node := doc
+	for _, key := range strings.Split(internal.ROUTING_APPROVALS, ".") {
+		if node.Kind != yaml.MappingNode {
+			return doc
+		}
+		var found *yaml.Node
+		for k := 0; k+1 < len(node.Content); k += 2 {
+			if node.Content[k].Value == key {
+				found = node.Content[k+1]
+			}
+		}
+		if found == nil {
+			return doc
+		}
+		node = found
+	}
+	return node
+}
+
+// walk records the line of the node and its children, and checks the keys of mappings
+// are fields of the struct type t decodes to
+func walk(node *yaml.Node, t reflect.Type, path string, src Source) Problems {
+	var problems Problems
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if _, ok := src.Lines[path]; !ok {
+		src.Lines[path] = node.Line
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for k := 0; k+1 < len(node.Content); k += 2 {
+			key, value := node.Content[k], node.Content[k+1]
+
+			field, ok := fields[key.Value]
+			if !ok {
+				problems = append(problems, Problem{
+					File:   src.File,
+					Line:   key.Line,
+					Path:   path,
+					Err:    ERR_UNKNOWN_KEY,
+					Detail: unknownKeyDetail(key.Value, fields),
+				})
+				continue
+			}
+
+			// embedded structs, the approval key wrapping each approval config, share the path
+			childPath := path + "." + key.Value
+			if field.Anonymous {
+				childPath = path
+			}
+			src.Lines[childPath] = key.Line
+			problems = append(problems, walk(value, field.Type, childPath, src)...)
+		}
+
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for j, child := range node.Content {
+			problems = append(problems, walk(child, t.Elem(), fmt.Sprintf("%s[%d]", path, j), src)...)
+		}
+	}
+
+	return problems
+}
+
+// yamlFields returns the exported fields of the struct type by yaml key
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		fields[key] = f
+	}
+	return fields
+}
+
+// unknownKeyDetail names the unknown key, suggesting the closest known key if it looks like a typo
+func unknownKeyDetail(key string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for known := range fields {
+		if d := distance(strings.ToLower(key), strings.ToLower(known)); d < bestDistance ||
+			(d == bestDistance && best != "" && known < best) {
+			best, bestDistance = known, d
+		}
+	}
+	if best != "" {
+		return fmt.Sprintf("'%s', did you mean '%s'?", key, best)
+	}
+	return fmt.Sprintf("'%s'", key)
+}
+
+// distance is the Levenshtein edit distance between a and b
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	m := values[0]
+	for _, v := range values[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -0,0 +1,119 @@
+package approval
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestReadAndParseConfig_UnknownKeys(t *testing.T) {
+	data := `---
+- approval:
+    description: test approval config 1
+    onProvison: true
+    recipientList:
+        - ollie@test.io
+    scope:
+        clod: Azure
+    unexpected: true`
+	if err := os.WriteFile(testYamlFile, []byte(data), 0644); err != nil {
+		t.Fatalf("could not write test yaml file %+v", err)
+	}
+	defer removeTestYamlFile(t)
+
+	err := ReadAndParseConfig(testYamlFile)
+	if !errors.Is(err, ERR_UNKNOWN_KEY) {
+		t.Fatalf("wanted %v got %v", ERR_UNKNOWN_KEY, err)
+	}
+
+	want := []string{
+		"test_approvals.yaml:4: approval[0]: Unknown key ('onProvison', did you mean 'onProvision'?)",
+		"test_approvals.yaml:8: approval[0].scope: Unknown key ('clod', did you mean 'cloud'?)",
+		"test_approvals.yaml:9: approval[0]: Unknown key ('unexpected')",
+	}
+	if got := strings.Split(err.Error(), "\n"); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("\n\nWanted\n%s\n\ngot\n%s\n", strings.Join(want, "\n"), err)
+	}
+}
+
+func TestValidateConfig_AllProblems(t *testing.T) {
+	data := `---
+- approval:
+    description: test approval config 1
+    onProvision: true
+    recipientList:
+        - ollie@test.io
+
+- approval:
+    description: test approval config 2
+    recipientList:
+        - ollie@test.io
+        - badaddress&xyz.com
+    scope:
+        cloud: Azure
+        match: "cloud in AWS"
+
+- approval:
+    onDelete: true
+    recipientList: []`
+	if err := os.WriteFile(testYamlFile, []byte(data), 0644); err != nil {
+		t.Fatalf("could not write test yaml file %+v", err)
+	}
+	defer removeTestYamlFile(t)
+
+	if err := ReadAndParseConfig(testYamlFile); err != nil {
+		t.Fatalf("could not read test yaml file %+v", err)
+	}
+
+	err := ValidateConfig()
+	problems, ok := err.(Problems)
+	if !ok {
+		t.Fatalf("wanted Problems got %v", err)
+	}
+
+	want := []string{
+		"test_approvals.yaml:8: approval[1]: " + ERR_NO_ACTION.Error(),
+		"test_approvals.yaml:12: approval[1].recipientList[1]: " + ERR_BAD_RECIPIENT_EMAIL.Error() + " (badaddress&xyz.com)",
+		"test_approvals.yaml:13: approval[1].scope: " + ERR_MULTIPLE_SCOPES.Error() + " (cloud, match)",
+		"test_approvals.yaml:15: approval[1].scope.match: " + ERR_BAD_SCOPE_MATCH.Error() + " (column 10: expected '[' after 'in', found 'AWS')",
+		"test_approvals.yaml:17: approval[2].description: " + ERR_NO_DESCRIPTION.Error(),
+		"test_approvals.yaml:19: approval[2].recipientList: " + ERR_NO_RECIPIENTS.Error(),
+	}
+	if len(problems) != len(want) {
+		t.Fatalf("wanted %d problems got %d\n%v", len(want), len(problems), err)
+	}
+	for i := range want {
+		if problems[i].Error() != want[i] {
+			t.Errorf("\nwanted %s\ngot    %s", want[i], problems[i].Error())
+		}
+	}
+}
+
+func TestReadAndParseConfig_Inline(t *testing.T) {
+	data := `morpheus:
+  host: https://testhost
+routing:
+  approvals:
+    - approval:
+        description: inline approval config
+        onProvision: true
+        recipientList:
+          - ollie@test.io
+`
+	if err := os.WriteFile(testYamlFile, []byte(data), 0644); err != nil {
+		t.Fatalf("could not write test yaml file %+v", err)
+	}
+	defer removeTestYamlFile(t)
+
+	if err := ReadAndParseConfig(testYamlFile); err != nil {
+		t.Fatalf("wanted no error got %v", err)
+	}
+	current, _ := Snapshot()
+	if len(current) != 1 || current[0].Description != "inline approval config" {
+		t.Errorf("wanted the inline approval config got %+v", current)
+	}
+	if line := source.line("approval[0].description"); line != 6 {
+		t.Errorf("wanted description on line 6 got %d", line)
+	}
+}
@@ -0,0 +1,247 @@
+// Package audit provides an append-only trail of the approval workflows Link has managed
+// and the means to export it, filtered by date, for access reviews
+package audit
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OUTCOME_PENDING   = "pending"
+	OUTCOME_APPROVED  = "approved"
+	OUTCOME_DENIED    = "denied"
+	OUTCOME_CANCELLED = "cancelled"
+
+	FORMAT_CSV  = "csv"
+	FORMAT_JSON = "json"
+
+	DATE_LAYOUT = "2006-01-02"
+)
+
+var (
+	ERR_BAD_FORMAT     = errors.New("Export format must be 'csv' or 'json'")
+	ERR_BAD_DATE       = errors.New("Date must be in the format YYYY-MM-DD")
+	ERR_BAD_DATE_RANGE = errors.New("The 'from' date must not be after the 'to' date")
+)
+
+// mu serialises writes to the trail file, the poller and http handlers may both write
+var mu sync.Mutex
+
+// Record represents a single approval workflow in the audit trail, a workflow may be written
+// more than once as it progresses, the last record written for an approval is authoritative
+type Record struct {
+	ApprovalId    int       `json:"approvalId"`
+	ApprovalName  string    `json:"approvalName"`
+	Appliance     string    `json:"appliance,omitempty"`
+	RequestBy     string    `json:"requestBy"`
+	Descriptions  []string  `json:"descriptions"`
+	ConfigVersion int       `json:"configVersion,omitempty"`
+	Recipients    []string  `json:"recipients"`
+	Votes         []Vote    `json:"votes"`
+	Created       time.Time `json:"created"`
+	// Decided is nil until the approval is decided
+	Decided *time.Time `json:"decided,omitempty"`
+	Outcome string     `json:"outcome"`
+	// ResolvedExternally is set when the approval was resolved in Morpheus rather than by Link
+	ResolvedExternally bool `json:"resolvedExternally,omitempty"`
+}
+
+// Vote is a single decision made by a recipient of the approval notification
+type Vote struct {
+	Voter    string    `json:"voter"`
+	Decision string    `json:"decision"`
+	Date     time.Time `json:"date"`
+}
+
+// Write appends a record to the audit trail file, creating the file if it does not exist
+func Write(trailFile string, rec Record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, err := os.OpenFile(trailFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Read loads the audit trail file, collapsing multiple records for the same approval
+// to the last one written. Records are returned ordered by creation date
+func Read(trailFile string) ([]Record, error) {
+	var records []Record
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	f, err := os.Open(trailFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return records, nil
+		}
+		return records, err
+	}
+	defer f.Close()
+
+	// approval ids are only unique within an appliance
+	type key struct {
+		appliance string
+		id        int
+	}
+	index := map[key]int{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		rec := Record{}
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return records, fmt.Errorf("Could not parse audit record on line %d: %v", line, err)
+		}
+
+		k := key{rec.Appliance, rec.ApprovalId}
+		if i, ok := index[k]; ok {
+			records[i] = rec
+			continue
+		}
+		index[k] = len(records)
+		records = append(records, rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return records, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Created.Before(records[j].Created)
+	})
+
+	return records, nil
+}
+
+// ParseDateRange parses the from and to dates (YYYY-MM-DD) of an export, both dates are
+// inclusive so the upper bound returned is the start of the day after 'to'. An empty
+// 'from' is unbounded, an empty 'to' is now
+func ParseDateRange(from, to string) (time.Time, time.Time, error) {
+	var start, end time.Time
+
+	if from != "" {
+		d, err := time.ParseInLocation(DATE_LAYOUT, from, time.UTC)
+		if err != nil {
+			return start, end, ERR_BAD_DATE
+		}
+		start = d
+	}
+
+	end = time.Now().UTC()
+	if to != "" {
+		d, err := time.ParseInLocation(DATE_LAYOUT, to, time.UTC)
+		if err != nil {
+			return start, end, ERR_BAD_DATE
+		}
+		end = d.AddDate(0, 0, 1)
+	}
+
+	if start.After(end) {
+		return start, end, ERR_BAD_DATE_RANGE
+	}
+
+	return start, end, nil
+}
+
+// Filter returns the records created in the range start (inclusive) to end (exclusive)
+func Filter(records []Record, start, end time.Time) []Record {
+	filtered := []Record{}
+	for _, rec := range records {
+		if rec.Created.Before(start) || !rec.Created.Before(end) {
+			continue
+		}
+		filtered = append(filtered, rec)
+	}
+	return filtered
+}
+
+// Export writes the records to w in the requested format
+func Export(w io.Writer, records []Record, format string) error {
+	switch strings.ToLower(format) {
+	case FORMAT_CSV:
+		return ExportCSV(w, records)
+	case FORMAT_JSON:
+		return ExportJSON(w, records)
+	default:
+		return ERR_BAD_FORMAT
+	}
+}
+
+// ExportJSON writes the records as an indented JSON array
+func ExportJSON(w io.Writer, records []Record) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(records)
+}
+
+// ExportCSV writes the records as CSV with a header row, multi-value fields are
+// separated with a semicolon and each vote is written as voter:decision@date
+func ExportCSV(w io.Writer, records []Record) error {
+	cw := csv.NewWriter(w)
+
+	header := []string{"approval_id", "approval_name", "request_by", "descriptions", "recipients", "votes", "created", "decided", "outcome", "appliance"}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+
+	for _, rec := range records {
+		votes := make([]string, 0, len(rec.Votes))
+		for _, v := range rec.Votes {
+			votes = append(votes, fmt.Sprintf("%s:%s@%s", v.Voter, v.Decision, v.Date.UTC().Format(time.RFC3339)))
+		}
+
+		var decided string
+		if rec.Decided != nil {
+			decided = rec.Decided.UTC().Format(time.RFC3339)
+		}
+
+		row := []string{
+			strconv.Itoa(rec.ApprovalId),
+			rec.ApprovalName,
+			rec.RequestBy,
+			strings.Join(rec.Descriptions, ";"),
+			strings.Join(rec.Recipients, ";"),
+			strings.Join(votes, ";"),
+			rec.Created.UTC().Format(time.RFC3339),
+			decided,
+			rec.Outcome,
+			rec.Appliance,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
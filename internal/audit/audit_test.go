@@ -0,0 +1,150 @@
+package audit
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteAndRead(t *testing.T) {
+	trail := filepath.Join(t.TempDir(), "audit.jsonl")
+	created := time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+	decided := created.Add(2 * time.Hour)
+
+	records := []Record{
+		{ApprovalId: 2, ApprovalName: "second", Created: created.Add(time.Hour), Outcome: OUTCOME_PENDING},
+		{ApprovalId: 1, ApprovalName: "first", Created: created, Outcome: OUTCOME_PENDING},
+		{ApprovalId: 1, ApprovalName: "emea", Appliance: "emea", Created: created.Add(2 * time.Hour), Outcome: OUTCOME_PENDING},
+		{
+			ApprovalId:   1,
+			ApprovalName: "first",
+			Descriptions: []string{"test approval config 1"},
+			Recipients:   []string{"ollie@test.io"},
+			Votes:        []Vote{{Voter: "ollie@test.io", Decision: OUTCOME_APPROVED, Date: decided}},
+			Created:      created,
+			Decided:      &decided,
+			Outcome:      OUTCOME_APPROVED,
+		},
+	}
+	for _, rec := range records {
+		if err := Write(trail, rec); err != nil {
+			t.Fatalf("could not write record %+v", err)
+		}
+	}
+
+	got, err := Read(trail)
+	if err != nil {
+		t.Fatalf("could not read trail %+v", err)
+	}
+
+	want := []Record{records[3], records[0], records[2]}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("\n\nWanted\n%+v\n\ngot\n%+v\n", want, got)
+	}
+}
+
+func TestRead_NoTrail(t *testing.T) {
+	got, err := Read(filepath.Join(t.TempDir(), "missing.jsonl"))
+	if err != nil {
+		t.Fatalf("wanted no error got %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("wanted no records got %d", len(got))
+	}
+}
+
+func TestParseDateRangeAndFilter(t *testing.T) {
+	records := []Record{
+		{ApprovalId: 1, Created: time.Date(2025, 12, 31, 23, 59, 0, 0, time.UTC)},
+		{ApprovalId: 2, Created: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ApprovalId: 3, Created: time.Date(2026, 3, 31, 23, 59, 0, 0, time.UTC)},
+		{ApprovalId: 4, Created: time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	testCases := []struct {
+		name    string
+		from    string
+		to      string
+		wantIds []int
+		wantErr error
+	}{
+		{"quarter, should include first and last day", "2026-01-01", "2026-03-31", []int{2, 3}, nil},
+		{"no from, should be unbounded", "", "2026-01-01", []int{1, 2}, nil},
+		{"bad date, should fail", "01/01/2026", "", nil, ERR_BAD_DATE},
+		{"from after to, should fail", "2026-04-01", "2026-01-01", nil, ERR_BAD_DATE_RANGE},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end, err := ParseDateRange(tc.from, tc.to)
+			if err != tc.wantErr {
+				t.Fatalf("wanted %v got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+
+			var gotIds []int
+			for _, rec := range Filter(records, start, end) {
+				gotIds = append(gotIds, rec.ApprovalId)
+			}
+			if !reflect.DeepEqual(gotIds, tc.wantIds) {
+				t.Errorf("wanted %v got %v", tc.wantIds, gotIds)
+			}
+		})
+	}
+}
+
+func TestExport(t *testing.T) {
+	created := time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC)
+	decided := created.Add(time.Hour)
+	records := []Record{
+		{
+			ApprovalId:   7,
+			ApprovalName: "APPROVAL-0000007",
+			Appliance:    "emea",
+			RequestBy:    "admin",
+			Descriptions: []string{"prod", "finance"},
+			Recipients:   []string{"a@test.io", "b@test.io"},
+			Votes:        []Vote{{Voter: "a@test.io", Decision: OUTCOME_APPROVED, Date: created.Add(time.Hour)}},
+			Created:      created,
+			Decided:      &decided,
+			Outcome:      OUTCOME_APPROVED,
+		},
+		{ApprovalId: 8, ApprovalName: "APPROVAL-0000008", RequestBy: "admin", Created: created, Outcome: OUTCOME_PENDING},
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := Export(&buf, records, "CSV"); err != nil {
+			t.Fatalf("could not export %+v", err)
+		}
+		want := "approval_id,approval_name,request_by,descriptions,recipients,votes,created,decided,outcome,appliance\n" +
+			"7,APPROVAL-0000007,admin,prod;finance,a@test.io;b@test.io,a@test.io:approved@2026-01-10T10:00:00Z,2026-01-10T09:00:00Z,2026-01-10T10:00:00Z,approved,emea\n" +
+			"8,APPROVAL-0000008,admin,,,,2026-01-10T09:00:00Z,,pending,\n"
+		if buf.String() != want {
+			t.Errorf("\n\nWanted\n%s\n\ngot\n%s\n", want, buf.String())
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := Export(&buf, records, FORMAT_JSON); err != nil {
+			t.Fatalf("could not export %+v", err)
+		}
+		// the approval still pending has no decided date
+		if !strings.Contains(buf.String(), `"descriptions": [`) || !strings.Contains(buf.String(), `"outcome": "approved"`) ||
+			strings.Count(buf.String(), `"decided": "2026-01-10T10:00:00Z"`) != 1 || strings.Count(buf.String(), `"decided"`) != 1 {
+			t.Errorf("unexpected json export %s", buf.String())
+		}
+	})
+
+	t.Run("bad format", func(t *testing.T) {
+		if err := Export(&bytes.Buffer{}, records, "xml"); err != ERR_BAD_FORMAT {
+			t.Errorf("wanted %v got %v", ERR_BAD_FORMAT, err)
+		}
+	})
+}
@@ -14,16 +14,16 @@ import (
 	"math/big"
 	"net"
 	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/spoonboy-io/link/internal"
-
-	"github.com/spoonboy-io/koan"
+	"github.com/spoonboy-io/link/internal/logging"
 )
 
 // Make generates a self-signed X.509 certificate for a TLS server, code based on example code
 // from the crypto/tls package found here https://go.dev/src/crypto/tls/generate_cert.go
-func Make(logger *koan.Logger) error {
+func Make(logger *logging.Logger, folder string) error {
 	// make private key
 	var priv interface{}
 	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
@@ -79,8 +79,8 @@ func Make(logger *koan.Logger) error {
 	}
 
 	// file targets
-	certDest := fmt.Sprintf("%s/cert.pem", internal.TLS_FOLDER)
-	keyDest := fmt.Sprintf("%s/key.pem", internal.TLS_FOLDER)
+	certDest := filepath.Join(folder, "cert.pem")
+	keyDest := filepath.Join(folder, "key.pem")
 
 	// write the certificate
 	certOut, err := os.Create(certDest)
@@ -118,6 +118,23 @@ func Make(logger *koan.Logger) error {
 	return nil
 }
 
+// Expiry returns when the first certificate in the PEM file expires
+func Expiry(file string) (time.Time, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to read certificate: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return time.Time{}, fmt.Errorf("No certificate found in '%s'", file)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Failed to parse certificate: %v", err)
+	}
+	return cert.NotAfter, nil
+}
+
 func publicKey(priv interface{}) interface{} {
 	switch k := priv.(type) {
 	case *rsa.PrivateKey:
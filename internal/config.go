@@ -0,0 +1,388 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/joho/godotenv"
+	"github.com/spoonboy-io/link/internal/secret"
+	"gopkg.in/yaml.v3"
+)
+
+// ENV_PREFIX prefixes the environment variables which override settings in the config file,
+// for example LINK_SMTP_PASSWORD overrides smtp.password. Secrets can also be read from a file
+// named by the same variable with a _FILE suffix, as mounted by Kubernetes
+const (
+	ENV_PREFIX  = "LINK_"
+	FILE_SUFFIX = "_FILE"
+)
+
+var (
+	ERR_BAD_CONFIG_YAML   = errors.New("Application configuration file is not valid YAML")
+	ERR_UNKNOWN_SETTING   = errors.New("Unknown setting")
+	ERR_SECRET_FILE       = errors.New("Could not read secret file")
+	ERR_SECRET            = errors.New("Could not resolve secret")
+	ERR_ROUTING_FILE_USED = errors.New("Approvals can be set inline with routing.approvals or in routing.file, not both")
+	ERR_BAD_APPLIANCE     = errors.New("Appliance names must be lowercase letters and digits, starting with a letter")
+)
+
+// setting maps a key of the YAML config file to the config.env variable it replaces
+type setting struct {
+	key    string
+	env    string
+	secret bool
+}
+
+// settings are the keys of the YAML config file, a secret may also be set as a path to a
+// file containing it by suffixing the key with 'File', smtp.passwordFile for example
+var settings = []setting{
+	{key: "server.host", env: "SRV_HOST"},
+	{key: "server.port", env: "SRV_PORT"},
+	{key: "server.url", env: "SRV_URL"},
+	{key: "paths.templates", env: "TEMPLATE_FOLDER"},
+	{key: "paths.certificates", env: "TLS_FOLDER"},
+	{key: "paths.auditTrail", env: "AUDIT_FILE"},
+	{key: "paths.state", env: "STATE_FILE"},
+	{key: "morpheus.host", env: "MORPHEUS_API_HOST"},
+	{key: "morpheus.token", env: "MORPHEUS_API_BEARER_TOKEN", secret: true},
+	{key: "morpheus.username", env: "MORPHEUS_API_USERNAME"},
+	{key: "morpheus.password", env: "MORPHEUS_API_PASSWORD", secret: true},
+	{key: "morpheus.clientId", env: "MORPHEUS_API_CLIENT_ID"},
+	{key: "morpheus.pollInterval", env: "POLL_INTERVAL"},
+	{key: "morpheus.caBundle", env: "MORPHEUS_CA_BUNDLE"},
+	{key: "morpheus.tlsVerify", env: "MORPHEUS_TLS_VERIFY"},
+	{key: "morpheus.timeout", env: "MORPHEUS_TIMEOUT"},
+	{key: "morpheus.maxRetries", env: "MORPHEUS_MAX_RETRIES"},
+	{key: "morpheus.logRequests", env: "MORPHEUS_LOG_REQUESTS"},
+	{key: "morpheus.rateLimit", env: "MORPHEUS_RATE_LIMIT"},
+	{key: "morpheus.rateBurst", env: "MORPHEUS_RATE_BURST"},
+	{key: "smtp.server", env: "SMTP_SERVER"},
+	{key: "smtp.port", env: "SMTP_PORT"},
+	{key: "smtp.user", env: "SMTP_USER"},
+	{key: "smtp.password", env: "SMTP_PASSWORD", secret: true},
+	{key: "smtp.from", env: "SMTP_FROM"},
+	{key: "notifiers.dryRun", env: "DRY_RUN"},
+	{key: "routing.file", env: "APPROVAL_CONFIG"},
+	{key: "routing.matchMode", env: "MATCH_MODE"},
+	{key: "audit.exportToken", env: "AUDIT_EXPORT_TOKEN", secret: true},
+	{key: "webhook.token", env: "WEBHOOK_TOKEN", secret: true},
+	{key: "secrets.refreshInterval", env: "SECRETS_REFRESH_INTERVAL"},
+	{key: "secrets.vault.address", env: "VAULT_ADDR"},
+	{key: "secrets.vault.token", env: "VAULT_TOKEN", secret: true},
+	{key: "secrets.vault.namespace", env: "VAULT_NAMESPACE"},
+	{key: "log.level", env: "LOG_LEVEL"},
+	{key: "log.format", env: "LOG_FORMAT"},
+	{key: "log.output", env: "LOG_OUTPUT"},
+}
+
+// ROUTING_APPROVALS is the key under which the approval configs can be set inline in the
+// YAML config file, rather than in a separate approvals file
+const ROUTING_APPROVALS = "routing.approvals"
+
+// APPLIANCES is the key under which further Morpheus appliances, or subtenants, are set in the
+// YAML config file by name, appliances.emea.host for example. In config.env the names are listed
+// in APPLIANCES and each setting is the morpheus variable prefixed with APPLIANCE_<NAME>_, such
+// as APPLIANCE_EMEA_MORPHEUS_API_HOST
+const (
+	APPLIANCES     = "appliances"
+	APPLIANCES_ENV = "APPLIANCES"
+)
+
+// applianceFields are the morpheus settings which can be set for each appliance
+var applianceFields = []string{"host", "token", "username", "password", "clientId", "caBundle", "tlsVerify", "timeout", "maxRetries", "logRequests", "rateLimit", "rateBurst"}
+
+// IsYAML reports whether the configuration file is a YAML config file rather than config.env
+func IsYAML(configFile string) bool {
+	switch strings.ToLower(filepath.Ext(configFile)) {
+	case ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// EnvName returns the environment variable which overrides a YAML config file key,
+// server.port is overridden by LINK_SERVER_PORT for example
+func EnvName(key string) string {
+	var sb strings.Builder
+	sb.WriteString(ENV_PREFIX)
+	for i, r := range key {
+		switch {
+		case r == '.':
+			sb.WriteRune('_')
+		case unicode.IsUpper(r) && i > 0:
+			sb.WriteRune('_')
+			sb.WriteRune(r)
+		default:
+			sb.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return sb.String()
+}
+
+// readConfigFile reads the settings in a YAML or config.env file keyed by config.env variable
+func readConfigFile(configFile string) (map[string]string, error) {
+	if !IsYAML(configFile) {
+		values, err := godotenv.Read(configFile)
+		if err != nil {
+			return nil, ERR_FAILED_READ_CONFIG
+		}
+		return values, nil
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, ERR_FAILED_READ_CONFIG
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("%w: %s", ERR_BAD_CONFIG_YAML, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+
+	values := map[string]string{}
+	if len(root.Content) == 0 {
+		return values, nil
+	}
+	if err := flatten(root.Content[0], "", values, configFile); err != nil {
+		return nil, err
+	}
+
+	// approvals set inline are read from this file by the approval package
+	if _, ok := values[ROUTING_APPROVALS]; ok {
+		if _, ok := values["APPROVAL_CONFIG"]; ok {
+			return nil, ERR_ROUTING_FILE_USED
+		}
+		delete(values, ROUTING_APPROVALS)
+		values["APPROVAL_CONFIG"] = configFile
+	}
+
+	return values, nil
+}
+
+// flatten walks the YAML mappings, storing the value of each known setting keyed by its
+// config.env variable. Unknown keys are an error so that typos are not silently ignored
+func flatten(node *yaml.Node, prefix string, values map[string]string, configFile string) error {
+	if node.Kind != yaml.MappingNode {
+		return fmt.Errorf("%s:%d: %w, expected a mapping of settings", configFile, node.Line, ERR_BAD_CONFIG_YAML)
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		k, v := node.Content[i], node.Content[i+1]
+		key := k.Value
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+
+		if key == ROUTING_APPROVALS {
+			values[key] = ""
+			continue
+		}
+
+		// the names of the appliances are listed as they would be in config.env
+		if prefix == APPLIANCES {
+			if !validApplianceName(k.Value) {
+				return fmt.Errorf("%s:%d: %w, '%s'", configFile, k.Line, ERR_BAD_APPLIANCE, k.Value)
+			}
+			if values[APPLIANCES_ENV] != "" {
+				values[APPLIANCES_ENV] += ","
+			}
+			values[APPLIANCES_ENV] += k.Value
+		}
+
+		if v.Kind == yaml.MappingNode {
+			if err := flatten(v, key, values, configFile); err != nil {
+				return err
+			}
+			continue
+		}
+
+		env, ok := settingEnv(key)
+		if !ok {
+			return fmt.Errorf("%s:%d: %w '%s'", configFile, k.Line, ERR_UNKNOWN_SETTING, key)
+		}
+		if v.Kind != yaml.ScalarNode {
+			return fmt.Errorf("%s:%d: %w, '%s' must be a single value", configFile, v.Line, ERR_BAD_CONFIG_YAML, key)
+		}
+		values[env] = v.Value
+	}
+
+	return nil
+}
+
+// settingEnv returns the config.env variable for a YAML key, or for the file of a secret
+func settingEnv(key string) (string, bool) {
+	candidates := settings
+	if parts := strings.SplitN(key, ".", 3); len(parts) == 3 && parts[0] == APPLIANCES {
+		candidates = applianceSettings(parts[1])
+	}
+	for _, s := range candidates {
+		if key == s.key {
+			return s.env, true
+		}
+		if s.secret && key == s.key+"File" {
+			return s.env + FILE_SUFFIX, true
+		}
+	}
+	return "", false
+}
+
+// applianceSettings returns the settings of the named appliance
+func applianceSettings(name string) []setting {
+	var appSettings []setting
+	for _, s := range settings {
+		field := strings.TrimPrefix(s.key, "morpheus.")
+		for _, f := range applianceFields {
+			if field == f && field != s.key {
+				appSettings = append(appSettings, setting{
+					key:    APPLIANCES + "." + name + "." + field,
+					env:    applianceEnv(name, s.env),
+					secret: s.secret,
+				})
+			}
+		}
+	}
+	return appSettings
+}
+
+// applianceEnv returns the config.env variable of a morpheus setting for the named appliance
+func applianceEnv(name, env string) string {
+	return "APPLIANCE_" + strings.ToUpper(name) + "_" + env
+}
+
+// validApplianceName reports whether the name can be used for an appliance, it must map to
+// and from environment variable names
+func validApplianceName(name string) bool {
+	for i, r := range name {
+		if !(r >= 'a' && r <= 'z') && (i == 0 || !(r >= '0' && r <= '9')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// applianceNames returns the names of the appliances set in the config file values or listed
+// in the APPLIANCES variable, in name order
+func applianceNames(values map[string]string, getenv func(string) string) ([]string, error) {
+	seen := map[string]bool{}
+	var names []string
+	add := func(name string) error {
+		if !validApplianceName(name) {
+			return fmt.Errorf("%w, '%s'", ERR_BAD_APPLIANCE, name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+		return nil
+	}
+
+	for _, list := range []string{getenv(EnvName(APPLIANCES)), values[APPLIANCES_ENV], getenv(APPLIANCES_ENV)} {
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			if err := add(name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// resolveSettings returns the value of each setting keyed by config.env variable. In order of
+// precedence a setting is taken from its LINK_ environment variable, the config file values and
+// finally the config.env variable in the environment. A secret set with a _FILE variant at any of
+// these levels is read from the file, and a secret reference is resolved from its backend
+func resolveSettings(values map[string]string, getenv func(string) string) (map[string]string, error) {
+	resolved := map[string]string{}
+
+	names, err := applianceNames(values, getenv)
+	if err != nil {
+		return nil, err
+	}
+	all := append([]setting{}, settings...)
+	for _, name := range names {
+		all = append(all, applianceSettings(name)...)
+	}
+	resolved[APPLIANCES_ENV] = strings.Join(names, ",")
+
+	for _, s := range all {
+		sources := []func(string) (string, bool){
+			func(name string) (string, bool) {
+				if name == s.env {
+					name = EnvName(s.key)
+				} else {
+					name = EnvName(s.key) + FILE_SUFFIX
+				}
+				v := getenv(name)
+				return v, v != ""
+			},
+			func(name string) (string, bool) {
+				v, ok := values[name]
+				return v, ok
+			},
+			func(name string) (string, bool) {
+				v := getenv(name)
+				return v, v != ""
+			},
+		}
+
+		for _, source := range sources {
+			if v, ok := source(s.env); ok {
+				resolved[s.env] = v
+				break
+			}
+			if !s.secret {
+				continue
+			}
+			if path, ok := source(s.env + FILE_SUFFIX); ok && path != "" {
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return nil, fmt.Errorf("%w for %s: %v", ERR_SECRET_FILE, s.key, err)
+				}
+				resolved[s.env] = strings.TrimSpace(string(data))
+				break
+			}
+		}
+	}
+
+	return resolved, resolveSecrets(resolved, all)
+}
+
+// resolveSecrets replaces secret references, such as 'vault:secret/data/link#smtpPassword', with
+// the secret they refer to. The Vault token itself can be a 'file:' or 'env:' reference
+func resolveSecrets(resolved map[string]string, all []setting) error {
+	ctx, cancel := context.WithTimeout(context.Background(), secret.VAULT_TIMEOUT)
+	defer cancel()
+
+	resolver := secret.NewResolver()
+	vaultToken, err := resolver.Resolve(ctx, resolved["VAULT_TOKEN"])
+	if err != nil {
+		return fmt.Errorf("%w for secrets.vault.token: %v", ERR_SECRET, err)
+	}
+	resolver.Register(secret.SCHEME_VAULT, secret.Vault{
+		Addr:      resolved["VAULT_ADDR"],
+		Token:     vaultToken,
+		Namespace: resolved["VAULT_NAMESPACE"],
+	})
+
+	for _, s := range all {
+		if !s.secret || s.env == "VAULT_TOKEN" {
+			continue
+		}
+		value, err := resolver.Resolve(ctx, resolved[s.env])
+		if err != nil {
+			return fmt.Errorf("%w for %s: %v", ERR_SECRET, s.key, err)
+		}
+		resolved[s.env] = value
+	}
+
+	return nil
+}
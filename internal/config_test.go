@@ -0,0 +1,317 @@
+package internal_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/spoonboy-io/link/internal"
+)
+
+const testYamlConfig = `server:
+  host: 127.0.0.1
+  port: 8443
+paths:
+  templates: /var/lib/link/templates
+morpheus:
+  host: https://testhost
+  token: xxx-testtoken-xxx
+  pollInterval: 30
+smtp:
+  server: testmailserver.net
+  port: 587
+  user: testuser
+  passwordFile: test_smtp_password
+routing:
+  matchMode: first
+  approvals:
+    - approval:
+        description: inline approval config
+log:
+  level: debug
+  format: JSON
+`
+
+func TestApp_LoadConfig_YAML(t *testing.T) {
+	filename := "test_config.yaml"
+	createTestConfigFile(filename, []byte(testYamlConfig), t)
+	defer removeTestConfigFileAndResetEnv(filename, t)
+	createTestConfigFile("test_smtp_password", []byte("secret-from-file\n"), t)
+	defer os.Remove("test_smtp_password")
+
+	// environment overrides the file, and secrets can be read from a file
+	os.Setenv("LINK_MORPHEUS_POLL_INTERVAL", "60")
+	createTestConfigFile("test_token", []byte("token-from-file"), t)
+	defer os.Remove("test_token")
+	os.Setenv("LINK_MORPHEUS_TOKEN_FILE", "test_token")
+
+	app := &internal.App{}
+	if err := app.LoadConfig(filename); err != nil {
+		t.Fatalf("could not load config %v", err)
+	}
+	if err := app.ValidateConfig(); err != nil {
+		t.Fatalf("could not validate config %v", err)
+	}
+
+	cfg := app.Settings()
+	checks := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"ListenHost", cfg.ListenHost, "127.0.0.1"},
+		{"ListenPort", cfg.ListenPort, 8443},
+		{"TemplateFolder", cfg.TemplateFolder, "/var/lib/link/templates"},
+		{"TLSFolder", cfg.TLSFolder, internal.TLS_FOLDER},
+		{"AuditFile", cfg.AuditFile, internal.AUDIT_FILE},
+		{"ApprovalConfig", cfg.ApprovalConfig, filename},
+		{"MorpheusToken", cfg.MorpheusToken, "token-from-file"},
+		{"PollInterval", cfg.PollInterval, 60},
+		{"SmtpPassword", cfg.SmtpPassword, "secret-from-file"},
+		{"MatchMode", cfg.MatchMode, internal.MATCH_MODE_FIRST},
+		{"LogLevel", cfg.LogLevel, "debug"},
+		{"LogFormat", cfg.LogFormat, "json"},
+		{"LogOutput", cfg.LogOutput, internal.LOG_OUTPUT},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: wanted '%v' got '%v'", c.name, c.want, c.got)
+		}
+	}
+}
+
+func TestApp_LoadConfig_YAMLErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		config  string
+		wantErr error
+	}{
+		{
+			name:    "unknown setting, should fail",
+			config:  "smtp:\n  pasword: secret\n",
+			wantErr: internal.ERR_UNKNOWN_SETTING,
+		},
+		{
+			name:    "not a mapping, should fail",
+			config:  "- smtp\n",
+			wantErr: internal.ERR_BAD_CONFIG_YAML,
+		},
+		{
+			name:    "bad appliance name, should fail",
+			config:  "appliances:\n  EMEA-1:\n    host: https://emea\n",
+			wantErr: internal.ERR_BAD_APPLIANCE,
+		},
+		{
+			name:    "unknown appliance setting, should fail",
+			config:  "appliances:\n  emea:\n    pollInterval: 30\n",
+			wantErr: internal.ERR_UNKNOWN_SETTING,
+		},
+		{
+			name:    "approvals inline and in a file, should fail",
+			config:  "routing:\n  file: approvals.yaml\n  approvals: []\n",
+			wantErr: internal.ERR_ROUTING_FILE_USED,
+		},
+	}
+
+	filename := "test_bad_config.yaml"
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			createTestConfigFile(filename, []byte(tc.config), t)
+			defer removeTestConfigFileAndResetEnv(filename, t)
+
+			app := &internal.App{}
+			if err := app.LoadConfig(filename); !errors.Is(err, tc.wantErr) {
+				t.Errorf("wanted %v got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	testCases := map[string]string{
+		"server.port":           "LINK_SERVER_PORT",
+		"morpheus.pollInterval": "LINK_MORPHEUS_POLL_INTERVAL",
+		"smtp.password":         "LINK_SMTP_PASSWORD",
+	}
+	for key, want := range testCases {
+		if got := internal.EnvName(key); got != want {
+			t.Errorf("%s: wanted %s got %s", key, want, got)
+		}
+	}
+}
+
+func TestApp_ValidateConfig_DataDirAndListen(t *testing.T) {
+	filename := "test_datadir.yaml"
+	config := `morpheus:
+  host: https://testhost
+  token: xxx-testtoken-xxx
+smtp:
+  server: testmailserver.net
+  port: 587
+  user: testuser
+  password: testpassword
+paths:
+  certificates: /etc/link/certs
+`
+	createTestConfigFile(filename, []byte(config), t)
+	defer removeTestConfigFileAndResetEnv(filename, t)
+
+	app := &internal.App{DataDir: "/var/lib/link", Listen: ":8443"}
+	if err := app.LoadConfig(filename); err != nil {
+		t.Fatalf("could not load config %v", err)
+	}
+	if err := app.ValidateConfig(); err != nil {
+		t.Fatalf("could not validate config %v", err)
+	}
+
+	cfg := app.Settings()
+	if cfg.TemplateFolder != "/var/lib/link/templates" || cfg.AuditFile != "/var/lib/link/audit.jsonl" {
+		t.Errorf("wanted paths in the data dir got '%s' and '%s'", cfg.TemplateFolder, cfg.AuditFile)
+	}
+	if cfg.TLSFolder != "/etc/link/certs" {
+		t.Errorf("wanted absolute path unchanged got '%s'", cfg.TLSFolder)
+	}
+	if cfg.ListenHost != "" || cfg.ListenPort != 8443 {
+		t.Errorf("wanted listen ':8443' got '%s:%d'", cfg.ListenHost, cfg.ListenPort)
+	}
+
+	if host, _ := os.Hostname(); cfg.ServerURL != "https://"+host+":8443" {
+		t.Errorf("wanted the server URL from the host name and port got '%s'", cfg.ServerURL)
+	}
+
+	// the links in emails use the server URL when set
+	os.Setenv("LINK_SERVER_URL", "https://link.example.com/")
+	if err := app.ValidateConfig(); err != nil || app.Settings().ServerURL != "https://link.example.com" {
+		t.Errorf("wanted the server URL set got '%s' %v", app.Settings().ServerURL, err)
+	}
+	os.Setenv("LINK_SERVER_URL", "link.example.com")
+	if err := app.ValidateConfig(); err != internal.ERR_BAD_SERVER_URL {
+		t.Errorf("wanted %v got %v", internal.ERR_BAD_SERVER_URL, err)
+	}
+	os.Unsetenv("LINK_SERVER_URL")
+
+	app.Listen = "8443"
+	if err := app.ValidateConfig(); err != internal.ERR_BAD_LISTEN_ADDRESS {
+		t.Errorf("wanted %v got %v", internal.ERR_BAD_LISTEN_ADDRESS, err)
+	}
+}
+
+func TestApp_ValidateConfig_Appliances(t *testing.T) {
+	filename := "test_appliances.yaml"
+	config := `morpheus:
+  host: https://default
+  token: default-token
+  timeout: 10
+smtp:
+  server: testmailserver.net
+  port: 587
+  user: testuser
+  password: testpassword
+appliances:
+  emea:
+    host: https://emea
+    username: svc-link
+    password: emea-password
+    logRequests: true
+  apac:
+    host: https://apac
+    token: apac-token
+    timeout: 60
+    rateLimit: 2.5
+`
+	createTestConfigFile(filename, []byte(config), t)
+	defer removeTestConfigFileAndResetEnv(filename, t)
+	os.Setenv("LINK_APPLIANCES_APAC_TOKEN", "apac-from-env")
+
+	app := &internal.App{}
+	if err := app.LoadConfig(filename); err != nil {
+		t.Fatalf("could not load config %v", err)
+	}
+	if err := app.ValidateConfig(); err != nil {
+		t.Fatalf("could not validate config %v", err)
+	}
+
+	want := []internal.Appliance{
+		{Name: "default", Host: "https://default", Token: "default-token", ClientId: internal.MORPHEUS_CLIENT_ID, Timeout: 10, MaxRetries: 3, RateLimit: 10, RateBurst: 20, StateFile: "state.json"},
+		{Name: "apac", Host: "https://apac", Token: "apac-from-env", ClientId: internal.MORPHEUS_CLIENT_ID, Timeout: 60, MaxRetries: 3, RateLimit: 2.5, RateBurst: 20, StateFile: "state-apac.json"},
+		{Name: "emea", Host: "https://emea", User: "svc-link", Password: "emea-password", ClientId: internal.MORPHEUS_CLIENT_ID, Timeout: 10, MaxRetries: 3, LogRequests: true, RateLimit: 10, RateBurst: 20, StateFile: "state-emea.json"},
+	}
+	got := app.Settings().Appliances
+	if len(got) != len(want) {
+		t.Fatalf("wanted %d appliances got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("wanted %+v got %+v", want[i], got[i])
+		}
+	}
+
+	if ap, ok := app.Config.Appliance("emea"); !ok || ap.Host != "https://emea" {
+		t.Errorf("wanted the emea appliance got %+v", ap)
+	}
+	if ap, ok := app.Config.Appliance(""); !ok || ap.Name != internal.DEFAULT_APPLIANCE {
+		t.Errorf("wanted the default appliance first got %+v", ap)
+	}
+
+	// an appliance without credentials is not valid
+	createTestConfigFile(filename, []byte(config+"  latam:\n    host: https://latam\n"), t)
+	if err := app.LoadConfig(filename); err != nil {
+		t.Fatalf("could not load config %v", err)
+	}
+	if err := app.ValidateConfig(); !errors.Is(err, internal.ERR_NO_API_TOKEN) {
+		t.Errorf("wanted %v for the latam appliance got %v", internal.ERR_NO_API_TOKEN, err)
+	}
+}
+
+func TestApp_ValidateConfig_SecretReferences(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" || r.URL.Path != "/v1/secret/data/link" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"data":{"data":{"smtpPassword":"from-vault"}}}`))
+	}))
+	defer vault.Close()
+
+	filename := "test_secrets.yaml"
+	config := `morpheus:
+  host: https://testhost
+  token: env:TEST_MORPHEUS_TOKEN
+smtp:
+  server: testmailserver.net
+  port: 587
+  user: testuser
+  password: vault:secret/data/link#smtpPassword
+secrets:
+  vault:
+    address: ` + vault.URL + `
+    token: env:TEST_VAULT_TOKEN
+`
+	createTestConfigFile(filename, []byte(config), t)
+	defer removeTestConfigFileAndResetEnv(filename, t)
+	os.Setenv("TEST_MORPHEUS_TOKEN", "from-env")
+	os.Setenv("TEST_VAULT_TOKEN", "vault-token")
+
+	app := &internal.App{}
+	if err := app.LoadConfig(filename); err != nil {
+		t.Fatalf("could not load config %v", err)
+	}
+	if err := app.ValidateConfig(); err != nil {
+		t.Fatalf("could not validate config %v", err)
+	}
+
+	cfg := app.Settings()
+	if cfg.MorpheusToken != "from-env" || cfg.SmtpPassword != "from-vault" {
+		t.Errorf("wanted secrets resolved got token '%s' password '%s'", cfg.MorpheusToken, cfg.SmtpPassword)
+	}
+	if cfg.SecretRefresh != internal.SECRETS_REFRESH_INTERVAL {
+		t.Errorf("wanted default refresh interval got %d", cfg.SecretRefresh)
+	}
+
+	// an unresolvable secret is an error
+	os.Unsetenv("TEST_MORPHEUS_TOKEN")
+	if err := app.ValidateConfig(); !errors.Is(err, internal.ERR_SECRET) {
+		t.Errorf("wanted %v got %v", internal.ERR_SECRET, err)
+	}
+}
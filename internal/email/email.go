@@ -0,0 +1,90 @@
+// Package email provides the SMTP connection used to send approval notifications
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"time"
+)
+
+const (
+	// SMTPS_PORT is the port on which SMTP servers expect implicit TLS rather than STARTTLS
+	SMTPS_PORT = 465
+
+	DIAL_TIMEOUT = 10 * time.Second
+)
+
+var ERR_NO_AUTH = errors.New("SMTP server does not offer AUTH, the user and password could not be checked")
+
+// Dial connects to the SMTP server, using implicit TLS on the SMTPS port and STARTTLS
+// elsewhere if the server supports it, and authenticates if the server supports AUTH
+func Dial(server string, port int, user, password string) (*smtp.Client, error) {
+	addr := net.JoinHostPort(server, strconv.Itoa(port))
+	tlsConf := &tls.Config{
+		ServerName: server,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: DIAL_TIMEOUT}
+	if port == SMTPS_PORT {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConf)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to SMTP server %s: %v", addr, err)
+	}
+
+	client, err := smtp.NewClient(conn, server)
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("Could not start SMTP session with %s: %v", addr, err)
+	}
+
+	if ok, _ := client.Extension("STARTTLS"); ok && port != SMTPS_PORT {
+		if err := client.StartTLS(tlsConf); err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("Could not start TLS with %s: %v", addr, err)
+		}
+	}
+
+	if ok, _ := client.Extension("AUTH"); ok {
+		if err := client.Auth(smtp.PlainAuth("", user, password, server)); err != nil {
+			_ = client.Close()
+			return nil, fmt.Errorf("SMTP login as '%s' failed: %v", user, err)
+		}
+	}
+
+	return client, nil
+}
+
+// CheckLogin connects and logs in to the SMTP server, then disconnects. With credentials
+// configured the server must offer AUTH, otherwise they would never have been checked
+func CheckLogin(server string, port int, user, password string) error {
+	client, err := Dial(server, port, user, password)
+	if err != nil {
+		return err
+	}
+	if ok, _ := client.Extension("AUTH"); !ok && user != "" {
+		_ = client.Close()
+		return ERR_NO_AUTH
+	}
+	return client.Quit()
+}
+
+// Reachable checks a connection can be made to the SMTP server within the timeout, without
+// logging in so frequent checks do not trip login rate limits
+func Reachable(server string, port int, timeout time.Duration) error {
+	addr := net.JoinHostPort(server, strconv.Itoa(port))
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return fmt.Errorf("Could not connect to SMTP server %s: %v", addr, err)
+	}
+	return conn.Close()
+}
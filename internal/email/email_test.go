@@ -0,0 +1,69 @@
+package email
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/spoonboy-io/link/internal/email/emailtest"
+)
+
+func TestCheckLogin(t *testing.T) {
+	withAuth := emailtest.NewServer("link@test.io", "secret")
+	defer withAuth.Close()
+	noAuth := emailtest.NewServer("", "")
+	defer noAuth.Close()
+
+	testCases := []struct {
+		name     string
+		srv      *emailtest.Server
+		user     string
+		password string
+		wantErr  string
+	}{
+		{"login", withAuth, "link@test.io", "secret", ""},
+		{"bad password", withAuth, "link@test.io", "guess", "SMTP login as 'link@test.io' failed"},
+		{"credentials not checked", noAuth, "link@test.io", "secret", ERR_NO_AUTH.Error()},
+		{"no credentials", noAuth, "", "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := CheckLogin(tc.srv.Host, tc.srv.Port, tc.user, tc.password)
+			if tc.wantErr == "" && err != nil {
+				t.Errorf("wanted no error got %v", err)
+			}
+			if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Errorf("wanted error containing %q got %v", tc.wantErr, err)
+			}
+		})
+	}
+	if logins := withAuth.Logins(); logins != 1 {
+		t.Errorf("wanted one login got %d", logins)
+	}
+	if err := CheckLogin(noAuth.Host, noAuth.Port, "link@test.io", "secret"); !errors.Is(err, ERR_NO_AUTH) {
+		t.Errorf("wanted %v got %v", ERR_NO_AUTH, err)
+	}
+
+	// nothing listening
+	ln, _ := net.Listen("tcp", "127.0.0.1:0")
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	if err := CheckLogin("127.0.0.1", port, "link@test.io", "secret"); err == nil || !strings.Contains(err.Error(), "Could not connect") {
+		t.Errorf("wanted a connection error got %v", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	srv := emailtest.NewServer("link@test.io", "secret")
+	defer srv.Close()
+
+	msg := Message{From: "link@test.io", To: []string{"ops@test.io"}, Subject: "Approval required", Body: "<p>approve</p>"}
+	if err := Send(srv.Host, srv.Port, "link@test.io", "secret", msg); err != nil {
+		t.Fatalf("could not send %v", err)
+	}
+	if srv.Messages() != 1 {
+		t.Errorf("wanted one message sent got %d", srv.Messages())
+	}
+}
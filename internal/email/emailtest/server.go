@@ -0,0 +1,139 @@
+// Package emailtest provides a fake SMTP server for tests. It offers AUTH PLAIN when given
+// credentials, accepting only those, and accepts any message sent without checking it
+package emailtest
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Server is a fake SMTP server listening on localhost, it is safe for use by multiple goroutines
+type Server struct {
+	Host string
+	Port int
+
+	user     string
+	password string
+	ln       net.Listener
+
+	mu       sync.Mutex
+	logins   int
+	messages int
+}
+
+// NewServer starts a fake SMTP server, which offers AUTH and accepts the credentials given
+// unless the user is empty. It should be closed when done
+func NewServer(user, password string) *Server {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("emailtest: failed to listen: %v", err))
+	}
+	addr := ln.Addr().(*net.TCPAddr)
+	s := &Server{Host: addr.IP.String(), Port: addr.Port, user: user, password: password, ln: ln}
+	go s.serve()
+	return s
+}
+
+// Close stops the server listening
+func (s *Server) Close() error {
+	return s.ln.Close()
+}
+
+// Logins returns the number of successful logins
+func (s *Server) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// Messages returns the number of messages accepted
+func (s *Server) Messages() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.messages
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		go s.session(conn)
+	}
+}
+
+// session speaks enough SMTP for net/smtp to log in and send a message
+func (s *Server) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(lines ...string) {
+		for i, line := range lines {
+			sep := " "
+			if i < len(lines)-1 {
+				sep = "-"
+			}
+			fmt.Fprintf(conn, "%s%s%s\r\n", line[:3], sep, line[4:])
+		}
+	}
+
+	reply("220 emailtest ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.TrimSpace(line)
+		verb := strings.ToUpper(strings.SplitN(cmd, " ", 2)[0])
+
+		switch verb {
+		case "EHLO", "HELO":
+			if s.user != "" {
+				reply("250 emailtest", "250 AUTH PLAIN")
+			} else {
+				reply("250 emailtest")
+			}
+		case "AUTH":
+			fields := strings.Fields(cmd)
+			if s.user == "" || len(fields) != 3 || strings.ToUpper(fields[1]) != "PLAIN" {
+				reply("504 unrecognised authentication type")
+				continue
+			}
+			given, _ := base64.StdEncoding.DecodeString(fields[2])
+			if string(given) != "\x00"+s.user+"\x00"+s.password {
+				reply("535 authentication failed")
+				continue
+			}
+			s.mu.Lock()
+			s.logins++
+			s.mu.Unlock()
+			reply("235 authenticated")
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			reply("250 OK")
+		case "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			for {
+				data, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if data == ".\r\n" {
+					break
+				}
+			}
+			s.mu.Lock()
+			s.messages++
+			s.mu.Unlock()
+			reply("250 OK")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
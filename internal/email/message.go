@@ -0,0 +1,198 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+const DEFAULT_TEMPLATE = "default.html"
+
+// resolvedTemplate tells the recipients of a workflow that the approval was resolved in Morpheus
+var resolvedTemplate = template.Must(template.New("resolved").Parse(`<!DOCTYPE html>
+<html>
+<body>
+<p>The approval '{{.Workflow.ApprovalName}}' requested by {{.Workflow.RequestBy}} was {{.Outcome}} in Morpheus.</p>
+<p>No further action is needed, the links in the approval email are no longer valid.</p>
+</body>
+</html>
+`))
+
+// Message is an approval notification email
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	Body    string
+}
+
+// Links are the links a recipient follows to approve or deny an approval, they are
+// valid only for the recipient and only while the workflow is open
+type Links struct {
+	Approve string
+	Deny    string
+}
+
+// TemplateData is made available to the email templates
+type TemplateData struct {
+	Approval     approval.Approval
+	Action       string
+	Descriptions []string
+	Recipients   []string
+	Recipient    string
+	Links        Links
+	Cost         string
+}
+
+// Compose renders the notification email to a recipient of an approval route, with their
+// links to vote. The template of the highest priority approval config in the route which
+// has one is used, or the default
+func Compose(from string, a approval.Approval, route approval.Route, recipient string, links Links) (Message, error) {
+	msg := Message{
+		From:    from,
+		To:      []string{recipient},
+		Subject: fmt.Sprintf("Approval required: %s", a.Name),
+	}
+
+	tmplFile := DEFAULT_TEMPLATE
+	for _, ac := range route.Configs {
+		if ac.TemplateFile != "" {
+			tmplFile = ac.TemplateFile
+			break
+		}
+	}
+
+	// the default template is used as built in if it has not been written to the templates folder,
+	// or the placeholder of earlier versions is still there
+	tmplPath := filepath.Join(approval.TemplateFolder(), tmplFile)
+	var tmpl *template.Template
+	var err error
+	if tmplFile == DEFAULT_TEMPLATE && builtIn(tmplPath) {
+		tmpl, err = template.New(tmplFile).Parse(internal.DefaultTemplate)
+	} else {
+		tmpl, err = template.ParseFiles(tmplPath)
+	}
+	if err != nil {
+		return msg, fmt.Errorf("Could not parse email template '%s': %v", tmplFile, err)
+	}
+
+	data := TemplateData{
+		Approval:     a,
+		Action:       a.Action(),
+		Descriptions: route.Descriptions(),
+		Recipients:   route.Recipients,
+		Recipient:    recipient,
+		Links:        links,
+	}
+	if a.Details.Priced {
+		data.Cost = fmt.Sprintf("%.2f %s", a.Details.MonthlyCost, a.Details.Currency)
+	}
+
+	var body bytes.Buffer
+	if err := tmpl.Execute(&body, data); err != nil {
+		return msg, fmt.Errorf("Could not render email template '%s': %v", tmplFile, err)
+	}
+	msg.Body = body.String()
+
+	return msg, nil
+}
+
+// builtIn reports whether the built in default template is used in place of the default template
+// at the path, because it does not exist or is the placeholder of earlier versions
+func builtIn(tmplPath string) bool {
+	content, err := os.ReadFile(tmplPath)
+	return errors.Is(err, os.ErrNotExist) || (err == nil && internal.IsPlaceholderTemplate(content))
+}
+
+// ComposeResolved renders the email telling a recipient of a workflow that the approval was
+// resolved outside of Link, with the outcome. As with Compose each recipient is sent their own
+// email, so the recipients do not see each other's addresses
+func ComposeResolved(from string, wf state.Workflow, outcome, recipient string) (Message, error) {
+	msg := Message{
+		From:    from,
+		To:      []string{recipient},
+		Subject: fmt.Sprintf("Approval %s: %s", outcome, wf.ApprovalName),
+	}
+
+	data := struct {
+		Workflow state.Workflow
+		Outcome  string
+	}{wf, outcome}
+
+	var body bytes.Buffer
+	if err := resolvedTemplate.Execute(&body, data); err != nil {
+		return msg, fmt.Errorf("Could not render resolved email: %v", err)
+	}
+	msg.Body = body.String()
+
+	return msg, nil
+}
+
+// Bytes returns the message formatted for sending, as an HTML email
+func (m Message) Bytes() []byte {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		buf.WriteString(fmt.Sprintf("%s: %s\r\n", name, value))
+	}
+
+	header("From", m.From)
+	header("To", strings.Join(m.To, ", "))
+	header("Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/html; charset=\"utf-8\"")
+	header("Content-Transfer-Encoding", "base64")
+	buf.WriteString("\r\n")
+
+	// wrap the encoded body at 76 characters as required by RFC 2045
+	encoded := base64.StdEncoding.EncodeToString([]byte(m.Body))
+	for len(encoded) > 76 {
+		buf.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	buf.WriteString(encoded + "\r\n")
+
+	return buf.Bytes()
+}
+
+// Send sends the message using the SMTP server
+func Send(server string, port int, user, password string, m Message) error {
+	client, err := Dial(server, port, user, password)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if err := client.Mail(m.From); err != nil {
+		return fmt.Errorf("SMTP server refused sender '%s': %v", m.From, err)
+	}
+	for _, to := range m.To {
+		if err := client.Rcpt(to); err != nil {
+			return fmt.Errorf("SMTP server refused recipient '%s': %v", to, err)
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(m.Bytes()); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
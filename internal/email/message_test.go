@@ -0,0 +1,113 @@
+package email
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+func TestCompose(t *testing.T) {
+	a := approval.Approval{
+		Id:          12,
+		Name:        "APPROVAL-0000012",
+		RequestType: "Instance Approval",
+		RequestBy:   "admin",
+		Scope:       approval.Scope{Cloud: "AWS"},
+		Details:     approval.Details{Priced: true, MonthlyCost: 146, Currency: "USD"},
+	}
+	route := approval.Route{
+		Configs:    []approval.ApprovalConfig{{Description: "expensive"}},
+		Recipients: []string{"finance@test.io", "ops@test.io"},
+	}
+
+	// no templates folder, so the built in default template is used
+	links := Links{
+		Approve: "https://link.test.io/vote?decision=approve&token=abc",
+		Deny:    "https://link.test.io/vote?decision=deny&token=abc",
+	}
+	msg, err := Compose("link@test.io", a, route, "ops@test.io", links)
+	if err != nil {
+		t.Fatalf("could not compose %v", err)
+	}
+
+	if msg.Subject != "Approval required: APPROVAL-0000012" || len(msg.To) != 1 || msg.To[0] != "ops@test.io" {
+		t.Errorf("unexpected subject %s or recipients %v", msg.Subject, msg.To)
+	}
+	for _, want := range []string{"<strong>admin</strong>", "<td>AWS</td>", "146.00 USD per month", "approval policy: expensive",
+		`href="https://link.test.io/vote?decision=approve&amp;token=abc"`, `href="https://link.test.io/vote?decision=deny&amp;token=abc"`, "for ops@test.io only"} {
+		if !strings.Contains(msg.Body, want) {
+			t.Errorf("wanted body to contain %q\n%s", want, msg.Body)
+		}
+	}
+
+	// the placeholder default template of earlier versions is not used, it has no links
+	defer approval.SetTemplateFolder(approval.TemplateFolder())
+	dir := t.TempDir()
+	approval.SetTemplateFolder(dir)
+	if err := os.WriteFile(filepath.Join(dir, DEFAULT_TEMPLATE), []byte(internal.PLACEHOLDER_TEMPLATE+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if msg, err := Compose("link@test.io", a, route, "ops@test.io", links); err != nil || !strings.Contains(msg.Body, ">Approve</a>") {
+		t.Errorf("wanted the built in template in place of the placeholder got %v\n%s", err, msg.Body)
+	}
+
+	// a missing custom template is an error
+	route.Configs[0].TemplateFile = "missing.html"
+	if _, err := Compose("link@test.io", a, route, "ops@test.io", links); err == nil {
+		t.Error("wanted error for missing template")
+	}
+}
+
+func TestMessage_Bytes(t *testing.T) {
+	msg := Message{
+		From:    "link@test.io",
+		To:      []string{"a@test.io", "b@test.io"},
+		Subject: "Approval required: APPROVAL-0000012",
+		Body:    strings.Repeat("<p>approve</p>", 20),
+	}
+
+	raw := string(msg.Bytes())
+	parts := strings.SplitN(raw, "\r\n\r\n", 2)
+	if len(parts) != 2 {
+		t.Fatalf("wanted headers and body got %q", raw)
+	}
+
+	for _, want := range []string{"From: link@test.io", "To: a@test.io, b@test.io", "Content-Type: text/html"} {
+		if !strings.Contains(parts[0], want) {
+			t.Errorf("wanted headers to contain %q\n%s", want, parts[0])
+		}
+	}
+
+	var encoded string
+	for _, line := range strings.Split(strings.TrimSpace(parts[1]), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("body line longer than 76 characters %q", line)
+		}
+		encoded += line
+	}
+	body, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || string(body) != msg.Body {
+		t.Errorf("wanted body %q got %q (%v)", msg.Body, body, err)
+	}
+}
+
+func TestComposeResolved(t *testing.T) {
+	wf := state.Workflow{ApprovalName: "APPROVAL-0000012", RequestBy: "admin", Recipients: []string{"ops@test.io", "finance@test.io"}}
+
+	msg, err := ComposeResolved("link@test.io", wf, "approved", "finance@test.io")
+	if err != nil {
+		t.Fatalf("could not compose %v", err)
+	}
+	if msg.Subject != "Approval approved: APPROVAL-0000012" || len(msg.To) != 1 || msg.To[0] != "finance@test.io" {
+		t.Errorf("unexpected subject %s or recipients %v", msg.Subject, msg.To)
+	}
+	if !strings.Contains(msg.Body, "requested by admin was approved in Morpheus") {
+		t.Errorf("unexpected body\n%s", msg.Body)
+	}
+}
@@ -0,0 +1,201 @@
+// Package expr provides a small boolean expression language used to match approvals
+// against the scope of an approval configuration, for example
+//
+//	cloud in [AWS, Azure] and group != Sandbox
+//	user like "svc-*" or (role == Admin and not network matches "^10\.")
+//
+// Expressions are compiled once when configuration is loaded so that errors can be
+// reported early, and evaluated against the Facts known about an approval
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Facts holds the values known about an approval keyed by field name, a field may
+// hold more than one value (labels for example) in which case a comparison is
+// satisfied if any of the values satisfy it
+type Facts map[string][]string
+
+// Set replaces the values held for a field, empty values are ignored
+func (f Facts) Set(field string, values ...string) {
+	var vals []string
+	for _, v := range values {
+		if v != "" {
+			vals = append(vals, v)
+		}
+	}
+	if len(vals) == 0 {
+		delete(f, field)
+		return
+	}
+	f[field] = vals
+}
+
+// Add appends values to those held for a field, empty values are ignored
+func (f Facts) Add(field string, values ...string) {
+	for _, v := range values {
+		if v != "" {
+			f[field] = append(f[field], v)
+		}
+	}
+}
+
+// Get returns the first value held for a field or an empty string
+func (f Facts) Get(field string) string {
+	if vals := f[field]; len(vals) > 0 {
+		return vals[0]
+	}
+	return ""
+}
+
+// Expr is a compiled expression
+type Expr struct {
+	src    string
+	root   node
+	fields []string
+}
+
+// ParseError describes a problem compiling an expression, Pos is the 1-based
+// column at which the problem was found
+type ParseError struct {
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("column %d: %s", e.Pos, e.Msg)
+}
+
+// Compile parses the expression, returning a *ParseError if it is not valid
+func Compile(src string) (*Expr, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens, seen: map[string]bool{}}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		return nil, p.errorf(tok, "unexpected %s, expected 'and', 'or' or end of expression", tok)
+	}
+
+	return &Expr{src: src, root: root, fields: p.fields}, nil
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Fields returns the field names referenced by the expression in the order first used
+func (e *Expr) Fields() []string {
+	return e.fields
+}
+
+// Eval reports whether the facts satisfy the expression
+func (e *Expr) Eval(facts Facts) bool {
+	return e.root.eval(facts)
+}
+
+// operators
+const (
+	opEq      = "=="
+	opNe      = "!="
+	opLt      = "<"
+	opLe      = "<="
+	opGt      = ">"
+	opGe      = ">="
+	opIn      = "in"
+	opNotIn   = "not in"
+	opMatches = "matches"
+	opLike    = "like"
+)
+
+type node interface {
+	eval(facts Facts) bool
+}
+
+type andNode struct{ left, right node }
+
+func (n *andNode) eval(facts Facts) bool { return n.left.eval(facts) && n.right.eval(facts) }
+
+type orNode struct{ left, right node }
+
+func (n *orNode) eval(facts Facts) bool { return n.left.eval(facts) || n.right.eval(facts) }
+
+type notNode struct{ operand node }
+
+func (n *notNode) eval(facts Facts) bool { return !n.operand.eval(facts) }
+
+type compareNode struct {
+	field  string
+	op     string
+	values []string
+	number float64
+	re     *regexp.Regexp
+}
+
+func (n *compareNode) eval(facts Facts) bool {
+	switch n.op {
+	case opNe:
+		return !n.any(facts, opEq)
+	case opNotIn:
+		return !n.any(facts, opIn)
+	default:
+		return n.any(facts, n.op)
+	}
+}
+
+// any reports whether any value of the field satisfies op
+func (n *compareNode) any(facts Facts, op string) bool {
+	for _, fact := range facts[n.field] {
+		switch op {
+		case opEq, opIn:
+			for _, v := range n.values {
+				if strings.EqualFold(fact, v) {
+					return true
+				}
+			}
+		case opMatches, opLike:
+			if n.re.MatchString(fact) {
+				return true
+			}
+		case opLt, opLe, opGt, opGe:
+			f, err := strconv.ParseFloat(fact, 64)
+			if err != nil {
+				continue
+			}
+			if (op == opLt && f < n.number) || (op == opLe && f <= n.number) ||
+				(op == opGt && f > n.number) || (op == opGe && f >= n.number) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// globToRegexp converts a glob pattern, supporting '*' and '?', to a case-insensitive
+// regular expression matching the whole value
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("(?i)^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.Compile(sb.String())
+}
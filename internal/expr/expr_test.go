@@ -0,0 +1,99 @@
+package expr
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCompileAndEval(t *testing.T) {
+	facts := Facts{
+		"cloud":   {"AWS"},
+		"group":   {"Production"},
+		"user":    {"svc-deploy"},
+		"role":    {"Admin"},
+		"network": {"10.1.0.0/16"},
+		"label":   {"gpu", "prod"},
+		"cost":    {"1250.50"},
+	}
+
+	testCases := []struct {
+		name string
+		src  string
+		want bool
+	}{
+		{"equals, case insensitive", "cloud == aws", true},
+		{"not equals", "group != Sandbox", true},
+		{"in list", "cloud in [AWS, Azure]", true},
+		{"not in list", "cloud not in [AWS, Azure]", false},
+		{"and", "cloud in [AWS, Azure] and group != Sandbox", true},
+		{"or", "cloud == Azure or role == Admin", true},
+		{"not and parentheses", "not (cloud == Azure or role == User)", true},
+		{"quoted value with spaces", `group == "All Clouds"`, false},
+		{"glob", `user like "svc-*"`, true},
+		{"glob whole value", `user like "svc"`, false},
+		{"regex", `network matches "^10\."`, true},
+		{"multi-value field, any value", "label == gpu", true},
+		{"multi-value field not equals", "label != gpu", false},
+		{"numeric greater than", "cost > 1000", true},
+		{"numeric less than or equal", "cost <= 1000", false},
+		{"missing field equals", "tenant == Acme", false},
+		{"missing field not equals", "tenant != Acme", true},
+		{"precedence, and binds tighter than or", "cloud == Azure and role == Admin or group == Production", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := Compile(tc.src)
+			if err != nil {
+				t.Fatalf("could not compile %q: %v", tc.src, err)
+			}
+			if got := e.Eval(facts); got != tc.want {
+				t.Errorf("wanted %v got %v", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestCompile_Errors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		src     string
+		wantPos int
+	}{
+		{"empty", "", 1},
+		{"missing value", "cloud ==", 9},
+		{"single equals", "cloud = AWS", 7},
+		{"unknown operator", "cloud is AWS", 7},
+		{"list without brackets", "cloud in AWS", 10},
+		{"unterminated list", "cloud in [AWS, Azure", 21},
+		{"unclosed paren", "(cloud == AWS", 14},
+		{"bad regex", `cloud matches "(["`, 15},
+		{"numeric compare with word", "cost > lots", 8},
+		{"unterminated string", `group == "All`, 10},
+		{"trailing tokens", "cloud == AWS group == Dev", 14},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Compile(tc.src)
+			perr, ok := err.(*ParseError)
+			if !ok {
+				t.Fatalf("wanted *ParseError got %v", err)
+			}
+			if perr.Pos != tc.wantPos {
+				t.Errorf("wanted error at column %d got %d (%v)", tc.wantPos, perr.Pos, perr)
+			}
+		})
+	}
+}
+
+func TestExpr_Fields(t *testing.T) {
+	e, err := Compile("cloud == AWS and (group != Sandbox or cloud == Azure) and tag.env == prod")
+	if err != nil {
+		t.Fatalf("could not compile %v", err)
+	}
+	want := []string{"cloud", "group", "tag.env"}
+	if got := e.Fields(); !reflect.DeepEqual(got, want) {
+		t.Errorf("wanted %v got %v", want, got)
+	}
+}
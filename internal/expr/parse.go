@@ -0,0 +1,307 @@
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokWord
+	tokString
+	tokOp
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of expression"
+	case tokString:
+		return strconv.Quote(t.text)
+	default:
+		return fmt.Sprintf("'%s'", t.text)
+	}
+}
+
+// isKeyword reports whether the token is the unquoted keyword kw
+func (t token) isKeyword(kw string) bool {
+	return t.kind == tokWord && strings.EqualFold(t.text, kw)
+}
+
+const wordDelimiters = "()[],\"'=!<>"
+
+// lex splits the expression into tokens
+func lex(src string) ([]token, error) {
+	var tokens []token
+	runes := []rune(src)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(':
+			tokens = append(tokens, token{tokLParen, "(", pos})
+			i++
+		case r == ')':
+			tokens = append(tokens, token{tokRParen, ")", pos})
+			i++
+		case r == '[':
+			tokens = append(tokens, token{tokLBracket, "[", pos})
+			i++
+		case r == ']':
+			tokens = append(tokens, token{tokRBracket, "]", pos})
+			i++
+		case r == ',':
+			tokens = append(tokens, token{tokComma, ",", pos})
+			i++
+		case r == '=' || r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(runes) && runes[i+1] == '=' {
+				op += "="
+			}
+			if op == "=" || op == "!" {
+				return nil, &ParseError{Pos: pos, Msg: fmt.Sprintf("unknown operator '%s', did you mean '%s='?", op, op)}
+			}
+			tokens = append(tokens, token{tokOp, op, pos})
+			i += len(op)
+		case r == '"' || r == '\'':
+			var sb strings.Builder
+			closed := false
+			j := i + 1
+			for ; j < len(runes); j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					// only quotes and backslashes are escaped, so regular expressions can be written naturally
+					if next := runes[j+1]; next == r || next == '\\' {
+						sb.WriteRune(next)
+						j++
+						continue
+					}
+				}
+				if runes[j] == r {
+					closed = true
+					break
+				}
+				sb.WriteRune(runes[j])
+			}
+			if !closed {
+				return nil, &ParseError{Pos: pos, Msg: "unterminated string"}
+			}
+			tokens = append(tokens, token{tokString, sb.String(), pos})
+			i = j + 1
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune(wordDelimiters, runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{tokWord, string(runes[i:j]), pos})
+			i = j
+		}
+	}
+
+	tokens = append(tokens, token{tokEOF, "", len(runes) + 1})
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []token
+	i      int
+	fields []string
+	seen   map[string]bool
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.i]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *parser) errorf(tok token, format string, args ...interface{}) error {
+	return &ParseError{Pos: tok.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+// parseOr := parseAnd ("or" parseAnd)*
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("or") {
+		p.next()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &orNode{left, right}
+	}
+	return left, nil
+}
+
+// parseAnd := parseUnary ("and" parseUnary)*
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().isKeyword("and") {
+		p.next()
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &andNode{left, right}
+	}
+	return left, nil
+}
+
+// parseUnary := "not" parseUnary | "(" parseOr ")" | comparison
+func (p *parser) parseUnary() (node, error) {
+	tok := p.peek()
+
+	if tok.isKeyword("not") {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &notNode{operand}, nil
+	}
+
+	if tok.kind == tokLParen {
+		p.next()
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if closing := p.next(); closing.kind != tokRParen {
+			return nil, p.errorf(closing, "expected ')' to close '(' at column %d, found %s", tok.pos, closing)
+		}
+		return inner, nil
+	}
+
+	return p.parseComparison()
+}
+
+var fieldPattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.\-]*$`)
+
+// parseComparison := field operator value
+func (p *parser) parseComparison() (node, error) {
+	fieldTok := p.next()
+	if fieldTok.kind != tokWord || !fieldPattern.MatchString(fieldTok.text) {
+		return nil, p.errorf(fieldTok, "expected a field name, found %s", fieldTok)
+	}
+	for _, kw := range []string{"and", "or", "in", "matches", "like"} {
+		if fieldTok.isKeyword(kw) {
+			return nil, p.errorf(fieldTok, "expected a field name, found keyword %s", fieldTok)
+		}
+	}
+
+	field := fieldTok.text
+	if !p.seen[field] {
+		p.seen[field] = true
+		p.fields = append(p.fields, field)
+	}
+
+	opTok := p.next()
+	var op string
+	switch {
+	case opTok.kind == tokOp:
+		op = opTok.text
+	case opTok.isKeyword("in"):
+		op = opIn
+	case opTok.isKeyword("matches"):
+		op = opMatches
+	case opTok.isKeyword("like"):
+		op = opLike
+	case opTok.isKeyword("not") && p.peek().isKeyword("in"):
+		p.next()
+		op = opNotIn
+	default:
+		return nil, p.errorf(opTok, "expected an operator (==, !=, <, <=, >, >=, in, not in, matches, like) after '%s', found %s", field, opTok)
+	}
+
+	n := &compareNode{field: field, op: op}
+
+	if op == opIn || op == opNotIn {
+		values, err := p.parseList(op)
+		if err != nil {
+			return nil, err
+		}
+		n.values = values
+		return n, nil
+	}
+
+	valTok := p.next()
+	if valTok.kind != tokWord && valTok.kind != tokString {
+		return nil, p.errorf(valTok, "expected a value after '%s', found %s", op, valTok)
+	}
+
+	var err error
+	switch op {
+	case opMatches:
+		if n.re, err = regexp.Compile(valTok.text); err != nil {
+			return nil, p.errorf(valTok, "invalid regular expression %s: %v", valTok, err)
+		}
+	case opLike:
+		if n.re, err = globToRegexp(valTok.text); err != nil {
+			return nil, p.errorf(valTok, "invalid pattern %s: %v", valTok, err)
+		}
+	case opLt, opLe, opGt, opGe:
+		if n.number, err = strconv.ParseFloat(valTok.text, 64); err != nil {
+			return nil, p.errorf(valTok, "expected a number after '%s', found %s", op, valTok)
+		}
+	default:
+		n.values = []string{valTok.text}
+	}
+
+	return n, nil
+}
+
+// parseList := "[" value ("," value)* "]"
+func (p *parser) parseList(op string) ([]string, error) {
+	open := p.next()
+	if open.kind != tokLBracket {
+		return nil, p.errorf(open, "expected '[' after '%s', found %s", op, open)
+	}
+
+	var values []string
+	for {
+		valTok := p.next()
+		if valTok.kind != tokWord && valTok.kind != tokString {
+			return nil, p.errorf(valTok, "expected a value in list, found %s", valTok)
+		}
+		values = append(values, valTok.text)
+
+		sep := p.next()
+		if sep.kind == tokRBracket {
+			return values, nil
+		}
+		if sep.kind != tokComma {
+			return nil, p.errorf(sep, "expected ',' or ']' in list opened at column %d, found %s", open.pos, sep)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Package health tracks the outcome of polling each Morpheus appliance and saving its state,
+// and reports it with the reachability of the SMTP server and the expiry of the server
+// certificate, for liveness and readiness probes
+package health
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/certificate"
+	"github.com/spoonboy-io/link/internal/email"
+)
+
+const (
+	STATUS_OK   = "ok"
+	STATUS_WARN = "warn"
+	STATUS_FAIL = "fail"
+
+	// STALL_INTERVALS is the number of poll intervals without a poll after which the poller
+	// is considered stalled
+	STALL_INTERVALS = 3
+
+	// CERT_EXPIRY_WARNING is how long before the server certificate expires it is warned about
+	CERT_EXPIRY_WARNING = 14 * 24 * time.Hour
+
+	// SMTP reachability is checked at most once an interval, so frequent probes do not
+	// each connect to the server
+	SMTP_CHECK_INTERVAL = 30 * time.Second
+	SMTP_CHECK_TIMEOUT  = 2 * time.Second
+)
+
+// Check is the outcome of a single check, times are omitted if not known
+type Check struct {
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	Detail      string     `json:"detail,omitempty"`
+	LastSuccess *time.Time `json:"lastSuccess,omitempty"`
+	LastError   *time.Time `json:"lastError,omitempty"`
+	Expires     *time.Time `json:"expires,omitempty"`
+	Checked     *time.Time `json:"checked,omitempty"`
+}
+
+// Report is the outcome of a set of checks, it fails if any check fails
+type Report struct {
+	Status string           `json:"status"`
+	Checks map[string]Check `json:"checks"`
+}
+
+// OK reports whether no check failed
+func (r Report) OK() bool {
+	return r.Status != STATUS_FAIL
+}
+
+func (r *Report) add(name string, check Check) {
+	if r.Checks == nil {
+		r.Checks = map[string]Check{}
+	}
+	r.Checks[name] = check
+	switch {
+	case check.Status == STATUS_FAIL:
+		r.Status = STATUS_FAIL
+	case check.Status == STATUS_WARN && r.Status != STATUS_FAIL:
+		r.Status = STATUS_WARN
+	case r.Status == "":
+		r.Status = STATUS_OK
+	}
+}
+
+// outcome is the last success and error of a repeated operation, such as polling
+type outcome struct {
+	lastSuccess time.Time
+	lastError   time.Time
+	err         string
+}
+
+func (o *outcome) record(err error, at time.Time) {
+	if err != nil {
+		o.lastError, o.err = at, err.Error()
+		return
+	}
+	o.lastSuccess = at
+}
+
+// check fails if the last attempt failed, the last error is kept after a success
+func (o outcome) check() Check {
+	c := Check{Status: STATUS_OK, Error: o.err}
+	if !o.lastSuccess.IsZero() {
+		c.LastSuccess = timePtr(o.lastSuccess)
+	}
+	if !o.lastError.IsZero() {
+		c.LastError = timePtr(o.lastError)
+		if o.lastError.After(o.lastSuccess) {
+			c.Status = STATUS_FAIL
+		}
+	}
+	return c
+}
+
+// Tracker records polls and state saves, it is safe for use by multiple goroutines
+type Tracker struct {
+	mu       sync.Mutex
+	started  time.Time
+	lastPoll time.Time
+	polls    map[string]*outcome
+	saves    map[string]*outcome
+
+	smtpMu   sync.Mutex
+	smtp     Check
+	smtpAddr string
+
+	// reachable checks the SMTP server, replaced in tests
+	reachable func(server string, port int, timeout time.Duration) error
+}
+
+// NewTracker creates a tracker for a server started at the time given
+func NewTracker(started time.Time) *Tracker {
+	return &Tracker{
+		started:   started,
+		polls:     map[string]*outcome{},
+		saves:     map[string]*outcome{},
+		reachable: email.Reachable,
+	}
+}
+
+// Polled records a poll of the appliance, with the error listing its approvals if any
+func (t *Tracker) Polled(appliance string, err error, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if at.After(t.lastPoll) {
+		t.lastPoll = at
+	}
+	t.outcome(t.polls, appliance).record(err, at)
+}
+
+// Saved records saving the state of the appliance
+func (t *Tracker) Saved(appliance string, err error, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.outcome(t.saves, appliance).record(err, at)
+}
+
+func (t *Tracker) outcome(outcomes map[string]*outcome, appliance string) *outcome {
+	o, ok := outcomes[appliance]
+	if !ok {
+		o = &outcome{}
+		outcomes[appliance] = o
+	}
+	return o
+}
+
+// Live reports whether the poller is running, it fails once no poll has started for
+// STALL_INTERVALS poll intervals
+func (t *Tracker) Live(now time.Time, pollInterval time.Duration) Report {
+	t.mu.Lock()
+	last := t.lastPoll
+	t.mu.Unlock()
+
+	c := Check{Status: STATUS_OK}
+	since := t.started
+	if !last.IsZero() {
+		c.LastSuccess = timePtr(last)
+		since = last
+	}
+	if stalled := now.Sub(since); stalled > STALL_INTERVALS*pollInterval {
+		c.Status = STATUS_FAIL
+		c.Error = fmt.Sprintf("no poll for %s", stalled.Round(time.Second))
+	}
+
+	r := Report{}
+	r.add("poller", c)
+	return r
+}
+
+// Ready reports whether the server can do its work: the last poll of each appliance listed its
+// approvals, its state was saved, the SMTP server can be reached and the server certificate has
+// not expired. In dry run mode the SMTP server is not checked
+func (t *Tracker) Ready(now time.Time, cfg internal.Config) Report {
+	r := Report{}
+
+	t.mu.Lock()
+	for _, ap := range cfg.Appliances {
+		poll, save := outcome{}, outcome{}
+		if o, ok := t.polls[ap.Name]; ok {
+			poll = *o
+		}
+		if o, ok := t.saves[ap.Name]; ok {
+			save = *o
+		}
+		r.add("morpheus:"+ap.Name, poll.check())
+		r.add("state:"+ap.Name, save.check())
+	}
+	t.mu.Unlock()
+
+	if cfg.DryRun {
+		r.add("smtp", Check{Status: STATUS_OK, Detail: "dry run, not checked"})
+	} else {
+		r.add("smtp", t.checkSMTP(now, cfg.SmtpServer, cfg.SmtpPort))
+	}
+	r.add("certificate", CheckCertificate(now, filepath.Join(cfg.TLSFolder, "cert.pem")))
+	return r
+}
+
+// checkSMTP checks the SMTP server can be reached, reusing the last check if it was made within
+// SMTP_CHECK_INTERVAL of the same server
+func (t *Tracker) checkSMTP(now time.Time, server string, port int) Check {
+	t.smtpMu.Lock()
+	defer t.smtpMu.Unlock()
+
+	addr := fmt.Sprintf("%s:%d", server, port)
+	if t.smtpAddr == addr && t.smtp.Checked != nil && now.Sub(*t.smtp.Checked) < SMTP_CHECK_INTERVAL {
+		return t.smtp
+	}
+
+	c := Check{Status: STATUS_OK, Detail: addr, Checked: timePtr(now)}
+	if err := t.reachable(server, port, SMTP_CHECK_TIMEOUT); err != nil {
+		c.Status, c.Error = STATUS_FAIL, err.Error()
+	}
+	t.smtp, t.smtpAddr = c, addr
+	return c
+}
+
+// CheckCertificate checks the certificate in the PEM file has not expired, it warns when
+// the certificate expires within CERT_EXPIRY_WARNING
+func CheckCertificate(now time.Time, file string) Check {
+	expires, err := certificate.Expiry(file)
+	if err != nil {
+		return Check{Status: STATUS_FAIL, Error: err.Error()}
+	}
+
+	c := Check{Status: STATUS_OK, Expires: timePtr(expires)}
+	switch {
+	case !now.Before(expires):
+		c.Status, c.Error = STATUS_FAIL, "certificate has expired"
+	case expires.Sub(now) < CERT_EXPIRY_WARNING:
+		c.Status, c.Detail = STATUS_WARN, fmt.Sprintf("certificate expires in %s", expires.Sub(now).Round(time.Hour))
+	}
+	return c
+}
+
+func timePtr(t time.Time) *time.Time {
+	t = t.UTC()
+	return &t
+}
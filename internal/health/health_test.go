@@ -0,0 +1,142 @@
+package health
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+)
+
+// writeCert writes a self-signed certificate expiring at the time given to cert.pem in dir
+func writeCert(t *testing.T, dir string, expires time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("could not generate key %v", err)
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1), NotBefore: expires.Add(-365 * 24 * time.Hour), NotAfter: expires}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("could not create certificate %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "cert.pem"), data, 0644); err != nil {
+		t.Fatalf("could not write certificate %v", err)
+	}
+}
+
+func TestTracker_Live(t *testing.T) {
+	start := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	tracker := NewTracker(start)
+
+	if r := tracker.Live(start.Add(2*time.Minute), time.Minute); !r.OK() {
+		t.Errorf("wanted live before the first poll is overdue got %+v", r)
+	}
+	if r := tracker.Live(start.Add(4*time.Minute), time.Minute); r.OK() {
+		t.Errorf("wanted stalled with no poll got %+v", r)
+	}
+
+	// a failed poll still shows the poller is running
+	tracker.Polled("default", errors.New("unauthorized"), start.Add(4*time.Minute))
+	r := tracker.Live(start.Add(5*time.Minute), time.Minute)
+	if !r.OK() || r.Checks["poller"].LastSuccess == nil {
+		t.Errorf("wanted live after a poll got %+v", r)
+	}
+}
+
+func TestTracker_Ready(t *testing.T) {
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	writeCert(t, dir, now.Add(365*24*time.Hour))
+
+	smtpErr := error(nil)
+	dials := 0
+	tracker := NewTracker(now)
+	tracker.reachable = func(string, int, time.Duration) error {
+		dials++
+		return smtpErr
+	}
+	cfg := internal.Config{
+		TLSFolder:  dir,
+		SmtpServer: "smtp.test.io",
+		SmtpPort:   587,
+		Appliances: []internal.Appliance{{Name: "default"}, {Name: "emea"}},
+	}
+
+	// nothing polled yet
+	if r := tracker.Ready(now, cfg); !r.OK() || r.Status != STATUS_OK || len(r.Checks) != 6 {
+		t.Fatalf("wanted ready before polling got %+v", r)
+	}
+
+	// a failed poll is not ready until a later poll succeeds, the error is kept
+	tracker.Polled("emea", errors.New("unauthorized"), now)
+	r := tracker.Ready(now, cfg)
+	if r.OK() || r.Checks["morpheus:emea"].Status != STATUS_FAIL || r.Checks["morpheus:default"].Status != STATUS_OK {
+		t.Errorf("wanted emea failing got %+v", r)
+	}
+	tracker.Polled("emea", nil, now.Add(time.Minute))
+	r = tracker.Ready(now, cfg)
+	if !r.OK() || r.Checks["morpheus:emea"].Error != "unauthorized" || r.Checks["morpheus:emea"].LastSuccess == nil {
+		t.Errorf("wanted emea recovered with its last error got %+v", r)
+	}
+
+	// state saves
+	tracker.Saved("default", errors.New("read-only file system"), now)
+	if r := tracker.Ready(now, cfg); r.OK() || r.Checks["state:default"].Status != STATUS_FAIL {
+		t.Errorf("wanted state failing got %+v", r)
+	}
+	tracker.Saved("default", nil, now)
+	tracker.Saved("default", nil, now.Add(time.Second))
+
+	// the SMTP check is reused within the interval
+	smtpErr = errors.New("connection refused")
+	if r := tracker.Ready(now.Add(SMTP_CHECK_INTERVAL/2), cfg); !r.OK() || dials != 1 {
+		t.Errorf("wanted the SMTP check reused got %+v after %d dials", r, dials)
+	}
+	if r := tracker.Ready(now.Add(SMTP_CHECK_INTERVAL), cfg); r.OK() || r.Checks["smtp"].Status != STATUS_FAIL || dials != 2 {
+		t.Errorf("wanted SMTP failing got %+v after %d dials", r, dials)
+	}
+
+	cfg.DryRun = true
+	if r := tracker.Ready(now.Add(SMTP_CHECK_INTERVAL), cfg); !r.OK() || dials != 2 {
+		t.Errorf("wanted SMTP not checked in dry run got %+v", r)
+	}
+}
+
+func TestCheckCertificate(t *testing.T) {
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "cert.pem")
+
+	if c := CheckCertificate(now, file); c.Status != STATUS_FAIL {
+		t.Errorf("wanted a missing certificate to fail got %+v", c)
+	}
+
+	testCases := []struct {
+		name    string
+		expires time.Time
+		want    string
+	}{
+		{"valid", now.Add(90 * 24 * time.Hour), STATUS_OK},
+		{"expiring", now.Add(7 * 24 * time.Hour), STATUS_WARN},
+		{"expired", now.Add(-time.Hour), STATUS_FAIL},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			writeCert(t, dir, tc.expires)
+			c := CheckCertificate(now, file)
+			if c.Status != tc.want || c.Expires == nil || !c.Expires.Equal(tc.expires) {
+				t.Errorf("wanted %s expiring %s got %+v", tc.want, tc.expires, c)
+			}
+		})
+	}
+}
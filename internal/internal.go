@@ -3,38 +3,138 @@ package internal
 import (
 	"context"
 	"errors"
+	"fmt"
+	"net"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
-	"github.com/spoonboy-io/link/internal/state"
-
-	"github.com/joho/godotenv"
-	"github.com/spoonboy-io/koan"
+	"github.com/spoonboy-io/link/internal/logging"
 )
 
-// App is provides a wrapper for passing the Config, Context, Logger & State around as dependencies
+// App is provides a wrapper for passing the Config, Context & Logger around as dependencies.
+// Config is only replaced by the goroutine which polls the API, other goroutines should use Settings.
+// DataDir and Listen are set from the command line, relative paths in the configuration are relative
+// to DataDir and Listen (host:port) overrides the configured server address
 type App struct {
-	Logger *koan.Logger
-	Ctx    context.Context
-	Config struct {
-		MorpheusHost  string
-		MorpheusToken string
-		PollInterval  int
-		SmtpServer    string
-		SmtpPort      int
-		SmtpUser      string
-		SmtpPassword  string
-	}
-	State *state.State
+	Logger  *logging.Logger
+	Ctx     context.Context
+	Config  Config
+	DataDir string
+	Listen  string
+
+	mu   sync.RWMutex
+	file map[string]string
+
+	// envLoaded are the config.env variables Link set in the environment, variables set before
+	// Link started take precedence over config.env and are never replaced by it
+	envLoaded map[string]bool
+}
+
+// Config is the application configuration read from the YAML config file or config.env
+type Config struct {
+	ListenHost string
+	ListenPort int
+	// ServerURL is the address of Link used in the links of the notification emails, which must
+	// be reachable by the recipients, by default the host name and port of the server
+	ServerURL      string
+	TemplateFolder string
+	TLSFolder      string
+	AuditFile      string
+	StateFile      string
+	ApprovalConfig string
+
+	MorpheusHost  string
+	MorpheusToken string
+	// MorpheusUser is a service account used instead of MorpheusToken, tokens are obtained
+	// and refreshed with the OAuth password grant
+	MorpheusUser     string
+	MorpheusPassword string
+	MorpheusClientId string
+	// TLS verification of the Morpheus API is off unless enabled or a CA bundle is set, since
+	// appliances commonly use self-signed certificates
+	MorpheusCABundle    string
+	MorpheusTLSVerify   bool
+	MorpheusTimeout     int
+	MorpheusMaxRetries  int
+	MorpheusLogRequests bool
+	// MorpheusRateLimit is the requests a second made to each appliance, with bursts of up to
+	// MorpheusRateBurst requests, 0 is unlimited
+	MorpheusRateLimit float64
+	MorpheusRateBurst int
+	PollInterval      int
+	SmtpServer        string
+	SmtpPort          int
+	SmtpUser          string
+	SmtpPassword      string
+	SmtpFrom          string
+	AuditToken        string
+	WebhookToken      string
+	MatchMode         string
+	DryRun            bool
+	SecretRefresh     int
+
+	// LogOutput is stdout, stderr or a file, which is relative to the data directory
+	LogLevel  string
+	LogFormat string
+	LogOutput string
+
+	// Appliances are polled for approvals, the default appliance set by the Morpheus settings
+	// above first, followed by those set by name
+	Appliances []Appliance
+}
+
+// Appliance is a Morpheus appliance, or a subtenant of one, which is polled for approvals with
+// its own credentials and state
+type Appliance struct {
+	Name        string
+	Host        string
+	Token       string
+	User        string
+	Password    string
+	ClientId    string
+	CABundle    string
+	TLSVerify   bool
+	Timeout     int
+	MaxRetries  int
+	LogRequests bool
+	RateLimit   float64
+	RateBurst   int
+	StateFile   string
 }
 
 const (
-	// config
-	APP_CONFIG      = "config.env"
-	APPROVAL_CONFIG = "approvals.yaml"
+	// config, files are checked for changes at the watch interval and reloaded. The YAML
+	// config file is used in preference to config.env if it exists
+	CONFIG_FILE           = "link.yaml"
+	APP_CONFIG            = "config.env"
+	APPROVAL_CONFIG       = "approvals.yaml"
+	CONFIG_WATCH_INTERVAL = 5 * time.Second
 
-	// server
+	// secrets which are references, to Vault for example, are resolved again at this interval
+	// (seconds) so rotated secrets are used without a restart, 0 disables the refresh
+	SECRETS_REFRESH_INTERVAL = 300
+
+	// the appliance set by the morpheus settings, rather than by name under appliances
+	DEFAULT_APPLIANCE = "default"
+
+	// the Morpheus OAuth client used to obtain tokens for a service account
+	MORPHEUS_CLIENT_ID = "morph-api"
+
+	// Morpheus API request timeout (seconds) and retries of requests which fail with 5xx or 429
+	MORPHEUS_TIMEOUT     = 30
+	MORPHEUS_MAX_RETRIES = 3
+
+	// requests a second made to each appliance, and the burst of requests allowed above the rate
+	MORPHEUS_RATE_LIMIT = 10
+	MORPHEUS_RATE_BURST = 20
+
+	// server defaults, these and the folders below can be set in the config file
 	SRV_HOST      = ""
 	SRV_PORT      = "18652"
 	POLL_INTERVAL = 30
@@ -42,49 +142,246 @@ const (
 	// email templates
 	TEMPLATE_FOLDER = "templates"
 
+	// audit trail
+	AUDIT_FILE = "audit.jsonl"
+
+	// the last approval polled and the workflows in flight, saved so a restart resumes them
+	STATE_FILE = "state.json"
+
+	// approval config match modes, 'all' notifies for each matching approval config separately,
+	// 'first' only for the highest priority match, 'combined' merges the recipients of all matches
+	MATCH_MODE_ALL      = "all"
+	MATCH_MODE_FIRST    = "first"
+	MATCH_MODE_COMBINED = "combined"
+
+	// logging, LOG_OUTPUT may also be a file
+	LOG_LEVEL  = "info"
+	LOG_FORMAT = logging.FORMAT_TEXT
+	LOG_OUTPUT = logging.OUTPUT_STDERR
+
 	// tls configuration
 	TLS_FOLDER    = "certs"
 	TLS_ORG       = "Spoon Boy"
 	TLS_VALID_FOR = 365 * 24 * time.Hour
 )
 
-var DefaultTemplate string = `Template here TODO`
+// DefaultTemplate is written to the templates folder as default.html, it is an html/template
+// executed with the email.TemplateData for an approval
+var DefaultTemplate string = `<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+  <h2>Approval required: {{ .Approval.Name }}</h2>
+  <p>A {{ .Action }} request made by <strong>{{ .Approval.RequestBy }}</strong> on
+    {{ .Approval.DateCreated.Format "2 Jan 2006 15:04 MST" }} requires your approval.</p>
+  <table>
+    {{- with .Approval.Scope.Group }}<tr><td>Group</td><td>{{ . }}</td></tr>{{ end }}
+    {{- with .Approval.Scope.Cloud }}<tr><td>Cloud</td><td>{{ . }}</td></tr>{{ end }}
+    {{- with .Cost }}<tr><td>Estimated cost</td><td>{{ . }} per month</td></tr>{{ end }}
+    {{- range .Approval.Details.Instances }}
+    <tr><td>Instance</td><td>{{ .Name }} {{ with .Plan }}({{ . }}){{ end }}</td></tr>
+    {{- end }}
+  </table>
+  {{- with .Links.Approve }}
+  <p><a href="{{ . }}">Approve</a> or <a href="{{ $.Links.Deny }}">Deny</a> this request.
+    The links are for {{ $.Recipient }} only, do not forward this email.</p>
+  {{- end }}
+  <p>You are receiving this because of the approval policy: {{ range $i, $d := .Descriptions }}{{ if $i }}, {{ end }}{{ $d }}{{ end }}</p>
+</body>
+</html>
+`
+
+// PLACEHOLDER_TEMPLATE is the default.html written by earlier versions, which has no vote links
+const PLACEHOLDER_TEMPLATE = "Template here TODO"
+
+// IsPlaceholderTemplate reports whether a default.html is the placeholder of earlier versions,
+// so it can be replaced by DefaultTemplate
+func IsPlaceholderTemplate(content []byte) bool {
+	return strings.TrimSpace(string(content)) == PLACEHOLDER_TEMPLATE
+}
+
 var (
 	ERR_FAILED_READ_CONFIG    = errors.New("Failed to read application configuration file")
 	ERR_NO_API_HOST           = errors.New("No Morpheus API Host found")
-	ERR_NO_API_TOKEN          = errors.New("No Morpheus API Token found")
+	ERR_NO_API_TOKEN          = errors.New("No Morpheus API Token nor username found")
+	ERR_NO_API_PASSWORD       = errors.New("No Morpheus API password found for the username")
 	ERR_POLL_INTERVAL_NOT_INT = errors.New("Poll interval not integer")
 	ERR_NO_SMTP_SERVER        = errors.New("No SMTP server found")
 	ERR_NO_SMTP_PORT          = errors.New("No SMTP Port found")
 	ERR_NO_SMTP_USER          = errors.New("No SMTP User found")
 	ERR_NO_SMTP_PASSWORD      = errors.New("No SMTP Password found")
+	ERR_BAD_MATCH_MODE        = errors.New("Match mode must be 'all', 'first' or 'combined'")
+	ERR_DRY_RUN_NOT_BOOL      = errors.New("Dry run must be 'true' or 'false'")
+	ERR_BAD_LISTEN_PORT       = errors.New("Server port must be a number between 1 and 65535")
+	ERR_BAD_LISTEN_ADDRESS    = errors.New("Listen address must be in the form host:port")
+	ERR_BAD_SERVER_URL        = errors.New("Server URL must be an http or https URL, such as https://link.example.com")
+	ERR_REFRESH_NOT_INT       = errors.New("Secrets refresh interval not integer")
+	ERR_BAD_LOG_LEVEL         = logging.ERR_BAD_LEVEL
+	ERR_BAD_LOG_FORMAT        = logging.ERR_BAD_FORMAT
+	ERR_BAD_MORPHEUS_CLIENT   = errors.New("Morpheus API timeout and max retries must be integers, TLS verify and log requests 'true' or 'false'")
 )
 
-// LoadConfig loads the application configuration file
-func (*App) LoadConfig(configFile string) error {
-	err := godotenv.Load(configFile)
+// LoadConfig loads the application configuration file, a YAML config file is kept to be
+// validated while config.env is loaded into the environment as it always has been
+func (a *App) LoadConfig(configFile string) error {
+	if IsYAML(configFile) {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			return err
+		}
+		a.file = values
+		return nil
+	}
+
+	values, err := readConfigFile(configFile)
 	if err != nil {
-		return ERR_FAILED_READ_CONFIG
+		return err
+	}
+	return a.loadEnv(values)
+}
+
+// loadEnv sets the config.env values in the environment, as godotenv.Load would, variables
+// already set which Link did not load are kept. Variables Link loaded which are no longer
+// in config.env are unset
+func (a *App) loadEnv(values map[string]string) error {
+	for key := range a.envLoaded {
+		if _, ok := values[key]; !ok {
+			_ = os.Unsetenv(key)
+		}
 	}
+
+	loaded := map[string]bool{}
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set && !a.envLoaded[key] {
+			continue
+		}
+		if err := os.Setenv(key, value); err != nil {
+			return err
+		}
+		loaded[key] = true
+	}
+	a.envLoaded = loaded
 	return nil
 }
 
+// envValues returns the config.env values which are used, those of variables set before Link
+// started are not, and a getenv which ignores the variables Link loaded, so that reloading
+// config.env resolves settings with the same precedence as loading it
+func (a *App) envValues(values map[string]string) (map[string]string, func(string) string) {
+	used := map[string]string{}
+	for key, value := range values {
+		if _, set := os.LookupEnv(key); set && !a.envLoaded[key] {
+			continue
+		}
+		used[key] = value
+	}
+	return used, func(key string) string {
+		if a.envLoaded[key] {
+			return ""
+		}
+		return os.Getenv(key)
+	}
+}
+
 // ValidateConfig checks that we have configuration we can use in the application
 func (a *App) ValidateConfig() error {
-	// host
-	if os.Getenv("MORPHEUS_API_HOST") == "" {
-		return ERR_NO_API_HOST
+	resolved, err := resolveSettings(a.file, os.Getenv)
+	if err != nil {
+		return err
 	}
-	a.Config.MorpheusHost = os.Getenv("MORPHEUS_API_HOST")
+	return a.validateConfig(func(key string) string {
+		return resolved[key]
+	})
+}
 
-	// token
-	if os.Getenv("MORPHEUS_API_BEARER_TOKEN") == "" {
-		return ERR_NO_API_TOKEN
+// validateConfig checks and sets the configuration using getenv to look up each setting
+func (a *App) validateConfig(getenv func(string) string) error {
+	// server
+	a.Config.ListenHost = getenv("SRV_HOST")
+	listenPort := getenv("SRV_PORT")
+	if a.Listen != "" {
+		host, p, err := net.SplitHostPort(a.Listen)
+		if err != nil {
+			return ERR_BAD_LISTEN_ADDRESS
+		}
+		a.Config.ListenHost, listenPort = host, p
+	}
+	if listenPort == "" {
+		listenPort = SRV_PORT
 	}
-	a.Config.MorpheusToken = os.Getenv("MORPHEUS_API_BEARER_TOKEN")
+	port, err := strconv.Atoi(listenPort)
+	if err != nil || port < 1 || port > 65535 {
+		return ERR_BAD_LISTEN_PORT
+	}
+	a.Config.ListenPort = port
 
-	// poll interval
-	pollInt, err := strconv.Atoi(os.Getenv("POLL_INTERVAL"))
+	a.Config.ServerURL = strings.TrimRight(getenv("SRV_URL"), "/")
+	if a.Config.ServerURL == "" {
+		host := a.Config.ListenHost
+		if host == "" {
+			host, _ = os.Hostname()
+		}
+		a.Config.ServerURL = "https://" + net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	if u, err := url.Parse(a.Config.ServerURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return ERR_BAD_SERVER_URL
+	}
+
+	// paths
+	a.Config.TemplateFolder = a.path(valueOr(getenv("TEMPLATE_FOLDER"), TEMPLATE_FOLDER))
+	a.Config.TLSFolder = a.path(valueOr(getenv("TLS_FOLDER"), TLS_FOLDER))
+	a.Config.AuditFile = a.path(valueOr(getenv("AUDIT_FILE"), AUDIT_FILE))
+	a.Config.StateFile = a.path(valueOr(getenv("STATE_FILE"), STATE_FILE))
+	a.Config.ApprovalConfig = a.path(valueOr(getenv("APPROVAL_CONFIG"), APPROVAL_CONFIG))
+
+	// the default appliance is set by the morpheus settings, further appliances by name
+	var appliances []Appliance
+	if getenv("MORPHEUS_API_HOST") != "" {
+		def, err := a.validateAppliance(DEFAULT_APPLIANCE, getenv)
+		if err != nil {
+			return err
+		}
+		a.Config.MorpheusHost = def.Host
+		a.Config.MorpheusToken = def.Token
+		a.Config.MorpheusUser = def.User
+		a.Config.MorpheusPassword = def.Password
+		a.Config.MorpheusClientId = def.ClientId
+		a.Config.MorpheusCABundle = def.CABundle
+		a.Config.MorpheusTLSVerify = def.TLSVerify
+		a.Config.MorpheusTimeout = def.Timeout
+		a.Config.MorpheusMaxRetries = def.MaxRetries
+		a.Config.MorpheusLogRequests = def.LogRequests
+		a.Config.MorpheusRateLimit = def.RateLimit
+		a.Config.MorpheusRateBurst = def.RateBurst
+		appliances = append(appliances, def)
+	}
+	for _, name := range strings.Split(getenv(APPLIANCES_ENV), ",") {
+		if name == "" {
+			continue
+		}
+		if name == DEFAULT_APPLIANCE {
+			return fmt.Errorf("%w, '%s' is the appliance set by the morpheus settings", ERR_BAD_APPLIANCE, name)
+		}
+		named, err := a.validateAppliance(name, func(env string) string {
+			if v := getenv(applianceEnv(name, env)); v != "" {
+				return v
+			}
+			if inheritedEnv[env] {
+				return getenv(env)
+			}
+			return ""
+		})
+		if err != nil {
+			return fmt.Errorf("Appliance '%s': %w", name, err)
+		}
+		appliances = append(appliances, named)
+	}
+	if len(appliances) == 0 {
+		return ERR_NO_API_HOST
+	}
+	a.Config.Appliances = appliances
+
+	// poll interval, the default is used if not set
+	pollInt, err := strconv.Atoi(valueOr(getenv("POLL_INTERVAL"), "0"))
 	if err != nil {
 		return ERR_POLL_INTERVAL_NOT_INT
 	}
@@ -94,13 +391,13 @@ func (a *App) ValidateConfig() error {
 	a.Config.PollInterval = pollInt
 
 	// smtp server
-	if os.Getenv("SMTP_SERVER") == "" {
+	if getenv("SMTP_SERVER") == "" {
 		return ERR_NO_SMTP_SERVER
 	}
-	a.Config.SmtpServer = os.Getenv("SMTP_SERVER")
+	a.Config.SmtpServer = getenv("SMTP_SERVER")
 
 	// smtp port
-	port, err := strconv.Atoi(os.Getenv("SMTP_PORT"))
+	port, err = strconv.Atoi(getenv("SMTP_PORT"))
 	if err != nil {
 		return ERR_NO_SMTP_PORT
 	}
@@ -110,16 +407,249 @@ func (a *App) ValidateConfig() error {
 	a.Config.SmtpPort = port
 
 	// smtp user
-	if os.Getenv("SMTP_USER") == "" {
+	if getenv("SMTP_USER") == "" {
 		return ERR_NO_SMTP_USER
 	}
-	a.Config.SmtpUser = os.Getenv("SMTP_USER")
+	a.Config.SmtpUser = getenv("SMTP_USER")
 
 	// smtp password
-	if os.Getenv("SMTP_PASSWORD") == "" {
+	if getenv("SMTP_PASSWORD") == "" {
 		return ERR_NO_SMTP_PASSWORD
 	}
-	a.Config.SmtpPassword = os.Getenv("SMTP_PASSWORD")
+	a.Config.SmtpPassword = getenv("SMTP_PASSWORD")
+
+	// smtp from address is optional, the smtp user is used if not set
+	a.Config.SmtpFrom = getenv("SMTP_FROM")
+	if a.Config.SmtpFrom == "" {
+		a.Config.SmtpFrom = a.Config.SmtpUser
+	}
+
+	// audit export token is optional, the export endpoint is disabled without it
+	a.Config.AuditToken = getenv("AUDIT_EXPORT_TOKEN")
+
+	// webhook token is optional, the webhook endpoint is disabled without it
+	a.Config.WebhookToken = getenv("WEBHOOK_TOKEN")
+
+	// match mode
+	switch mode := strings.ToLower(getenv("MATCH_MODE")); mode {
+	case "":
+		a.Config.MatchMode = MATCH_MODE_ALL
+	case MATCH_MODE_ALL, MATCH_MODE_FIRST, MATCH_MODE_COMBINED:
+		a.Config.MatchMode = mode
+	default:
+		return ERR_BAD_MATCH_MODE
+	}
+
+	// dry run, approvals are routed and logged but no notifications sent
+	a.Config.DryRun = false
+	if dryRun := getenv("DRY_RUN"); dryRun != "" {
+		if a.Config.DryRun, err = strconv.ParseBool(dryRun); err != nil {
+			return ERR_DRY_RUN_NOT_BOOL
+		}
+	}
+
+	// secrets refresh interval
+	refresh := getenv("SECRETS_REFRESH_INTERVAL")
+	if refresh == "" {
+		a.Config.SecretRefresh = SECRETS_REFRESH_INTERVAL
+	} else if a.Config.SecretRefresh, err = strconv.Atoi(refresh); err != nil || a.Config.SecretRefresh < 0 {
+		return ERR_REFRESH_NOT_INT
+	}
+
+	// logging
+	a.Config.LogLevel = strings.ToLower(valueOr(getenv("LOG_LEVEL"), LOG_LEVEL))
+	if _, err := logging.ParseLevel(a.Config.LogLevel); err != nil {
+		return ERR_BAD_LOG_LEVEL
+	}
+	a.Config.LogFormat = strings.ToLower(valueOr(getenv("LOG_FORMAT"), LOG_FORMAT))
+	if !logging.ValidFormat(a.Config.LogFormat) {
+		return ERR_BAD_LOG_FORMAT
+	}
+	switch output := valueOr(getenv("LOG_OUTPUT"), LOG_OUTPUT); output {
+	case logging.OUTPUT_STDOUT, logging.OUTPUT_STDERR:
+		a.Config.LogOutput = output
+	default:
+		a.Config.LogOutput = a.path(output)
+	}
 
 	return nil
 }
+
+// inheritedEnv are the morpheus settings which appliances take from the default appliance
+// settings when they are not set for the appliance
+var inheritedEnv = map[string]bool{
+	"MORPHEUS_API_CLIENT_ID": true,
+	"MORPHEUS_CA_BUNDLE":     true,
+	"MORPHEUS_TLS_VERIFY":    true,
+	"MORPHEUS_TIMEOUT":       true,
+	"MORPHEUS_MAX_RETRIES":   true,
+	"MORPHEUS_LOG_REQUESTS":  true,
+	"MORPHEUS_RATE_LIMIT":    true,
+	"MORPHEUS_RATE_BURST":    true,
+}
+
+// validateAppliance checks and returns the settings of the named appliance using getenv to look
+// up each morpheus setting
+func (a *App) validateAppliance(name string, getenv func(string) string) (Appliance, error) {
+	ap := Appliance{Name: name}
+
+	// host
+	if getenv("MORPHEUS_API_HOST") == "" {
+		return ap, ERR_NO_API_HOST
+	}
+	ap.Host = getenv("MORPHEUS_API_HOST")
+
+	// token, or the service account credentials used to obtain expiring tokens with the
+	// OAuth password grant
+	ap.Token = getenv("MORPHEUS_API_BEARER_TOKEN")
+	ap.User = getenv("MORPHEUS_API_USERNAME")
+	ap.Password = getenv("MORPHEUS_API_PASSWORD")
+	ap.ClientId = valueOr(getenv("MORPHEUS_API_CLIENT_ID"), MORPHEUS_CLIENT_ID)
+	if ap.User != "" && ap.Password == "" {
+		return ap, ERR_NO_API_PASSWORD
+	}
+	if ap.Token == "" && ap.User == "" {
+		return ap, ERR_NO_API_TOKEN
+	}
+
+	// api client, the CA bundle implies the certificate is verified
+	ap.CABundle = getenv("MORPHEUS_CA_BUNDLE")
+	if ap.CABundle != "" {
+		ap.CABundle = a.path(ap.CABundle)
+	}
+	var errs [6]error
+	ap.TLSVerify, errs[0] = strconv.ParseBool(valueOr(getenv("MORPHEUS_TLS_VERIFY"), strconv.FormatBool(ap.CABundle != "")))
+	ap.Timeout, errs[1] = strconv.Atoi(valueOr(getenv("MORPHEUS_TIMEOUT"), strconv.Itoa(MORPHEUS_TIMEOUT)))
+	ap.MaxRetries, errs[2] = strconv.Atoi(valueOr(getenv("MORPHEUS_MAX_RETRIES"), strconv.Itoa(MORPHEUS_MAX_RETRIES)))
+	ap.LogRequests, errs[3] = strconv.ParseBool(valueOr(getenv("MORPHEUS_LOG_REQUESTS"), "false"))
+	ap.RateLimit, errs[4] = strconv.ParseFloat(valueOr(getenv("MORPHEUS_RATE_LIMIT"), strconv.Itoa(MORPHEUS_RATE_LIMIT)), 64)
+	ap.RateBurst, errs[5] = strconv.Atoi(valueOr(getenv("MORPHEUS_RATE_BURST"), strconv.Itoa(MORPHEUS_RATE_BURST)))
+	for _, err := range errs {
+		if err != nil {
+			return ap, ERR_BAD_MORPHEUS_CLIENT
+		}
+	}
+	if ap.Timeout <= 0 || ap.MaxRetries < 0 || ap.RateLimit < 0 || ap.RateBurst < 0 {
+		return ap, ERR_BAD_MORPHEUS_CLIENT
+	}
+
+	// each appliance keeps its own state, the default appliance uses the state file as is
+	ap.StateFile = a.Config.StateFile
+	if name != DEFAULT_APPLIANCE {
+		ext := filepath.Ext(ap.StateFile)
+		ap.StateFile = strings.TrimSuffix(ap.StateFile, ext) + "-" + name + ext
+	}
+
+	return ap, nil
+}
+
+// Appliance returns the configured appliance with the name, or the first appliance if the name
+// is empty
+func (c Config) Appliance(name string) (Appliance, bool) {
+	for _, ap := range c.Appliances {
+		if name == "" || ap.Name == name {
+			return ap, true
+		}
+	}
+	return Appliance{}, false
+}
+
+// Settings returns a copy of the application configuration which is safe to use from
+// any goroutine
+func (a *App) Settings() Config {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.Config
+}
+
+// ReloadConfig re-reads the application configuration file with the precedence used when it was
+// loaded, LINK_ variables take precedence over values in the file, and variables set before Link
+// started take precedence over config.env. The configuration in use is replaced only if the new
+// configuration is valid, and a description of the settings changed is returned
+func (a *App) ReloadConfig(configFile string) ([]string, error) {
+	values, err := readConfigFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	getenv := os.Getenv
+	if !IsYAML(configFile) {
+		values, getenv = a.envValues(values)
+	}
+	resolved, err := resolveSettings(values, getenv)
+	if err != nil {
+		return nil, err
+	}
+
+	next := &App{DataDir: a.DataDir, Listen: a.Listen}
+	if err := next.validateConfig(func(key string) string {
+		return resolved[key]
+	}); err != nil {
+		return nil, err
+	}
+
+	changes := diffSettings(a.Config, next.Config)
+	if len(changes) == 0 {
+		return changes, nil
+	}
+
+	a.mu.Lock()
+	a.Config = next.Config
+	a.mu.Unlock()
+
+	if IsYAML(configFile) {
+		a.file = values
+		return changes, nil
+	}
+	return changes, a.loadEnv(values)
+}
+
+// path returns the path relative to the data directory, unless it is absolute
+func (a *App) path(p string) string {
+	if a.DataDir == "" || filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(a.DataDir, p)
+}
+
+// valueOr returns the value, or the default if it is empty
+func valueOr(value, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// diffSettings describes the settings which differ, values of secrets are not included
+func diffSettings(before, after Config) []string {
+	var changes []string
+
+	b := reflect.ValueOf(before)
+	n := reflect.ValueOf(after)
+	for i := 0; i < b.NumField(); i++ {
+		name := b.Type().Field(i).Name
+		oldVal, newVal := b.Field(i).Interface(), n.Field(i).Interface()
+		if reflect.DeepEqual(oldVal, newVal) {
+			continue
+		}
+
+		// appliances hold secrets
+		if isSecret(name) || b.Field(i).Kind() == reflect.Slice {
+			changes = append(changes, fmt.Sprintf("%s changed", name))
+			continue
+		}
+		changes = append(changes, fmt.Sprintf("%s changed from '%v' to '%v'", name, oldVal, newVal))
+	}
+
+	return changes
+}
+
+// isSecret reports whether the named setting holds a credential
+func isSecret(name string) bool {
+	for _, s := range []string{"Token", "Password"} {
+		if strings.HasSuffix(name, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,7 @@ package internal_test
 
 import (
 	"os"
+	"reflect"
 	"testing"
 
 	"github.com/spoonboy-io/link/internal"
@@ -173,6 +174,83 @@ SMTP_PASSWORD=
 `),
 			wantErr: internal.ERR_NO_SMTP_PASSWORD,
 		},
+
+		{
+			name:     "bad match mode, should fail",
+			filename: "test9.env",
+			config: []byte(`## Morpheus
+MORPHEUS_API_HOST=https://testhost
+MORPHEUS_API_BEARER_TOKEN=xxx-testtoken-xxx
+POLL_INTERVAL=30
+
+## SMTP
+SMTP_SERVER=testmailserver.net
+SMTP_PORT=587
+SMTP_USER=testuser
+SMTP_PASSWORD=testpassword
+
+## Routing
+MATCH_MODE=some
+`),
+			wantErr: internal.ERR_BAD_MATCH_MODE,
+		},
+
+		{
+			name:     "bad dry run, should fail",
+			filename: "test10.env",
+			config: []byte(`## Morpheus
+MORPHEUS_API_HOST=https://testhost
+MORPHEUS_API_BEARER_TOKEN=xxx-testtoken-xxx
+POLL_INTERVAL=30
+
+## SMTP
+SMTP_SERVER=testmailserver.net
+SMTP_PORT=587
+SMTP_USER=testuser
+SMTP_PASSWORD=testpassword
+
+DRY_RUN=maybe
+`),
+			wantErr: internal.ERR_DRY_RUN_NOT_BOOL,
+		},
+
+		{
+			name:     "bad log level, should fail",
+			filename: "test11.env",
+			config: []byte(`## Morpheus
+MORPHEUS_API_HOST=https://testhost
+MORPHEUS_API_BEARER_TOKEN=xxx-testtoken-xxx
+POLL_INTERVAL=30
+
+## SMTP
+SMTP_SERVER=testmailserver.net
+SMTP_PORT=587
+SMTP_USER=testuser
+SMTP_PASSWORD=testpassword
+
+LOG_LEVEL=verbose
+`),
+			wantErr: internal.ERR_BAD_LOG_LEVEL,
+		},
+
+		{
+			name:     "bad log format, should fail",
+			filename: "test12.env",
+			config: []byte(`## Morpheus
+MORPHEUS_API_HOST=https://testhost
+MORPHEUS_API_BEARER_TOKEN=xxx-testtoken-xxx
+POLL_INTERVAL=30
+
+## SMTP
+SMTP_SERVER=testmailserver.net
+SMTP_PORT=587
+SMTP_USER=testuser
+SMTP_PASSWORD=testpassword
+
+LOG_FORMAT=xml
+`),
+			wantErr: internal.ERR_BAD_LOG_FORMAT,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -193,3 +271,61 @@ SMTP_PASSWORD=
 
 	}
 }
+
+func TestApp_ReloadConfig(t *testing.T) {
+	filename := "test_reload.env"
+	base := `MORPHEUS_API_HOST=https://testhost
+MORPHEUS_API_BEARER_TOKEN=xxx-testtoken-xxx
+SMTP_SERVER=testmailserver.net
+SMTP_PORT=587
+SMTP_USER=testuser
+SMTP_PASSWORD=testpassword
+`
+	createTestConfigFile(filename, []byte(base+"POLL_INTERVAL=30\nSMTP_FROM=link@test.io\n"), t)
+	defer removeTestConfigFileAndResetEnv(filename, t)
+
+	// set before Link started, so config.env does not override it
+	os.Setenv("SMTP_SERVER", "frommainenv.net")
+
+	app := &internal.App{}
+	if err := app.LoadConfig(filename); err != nil {
+		t.Fatalf("could not load config %v", err)
+	}
+	if err := app.ValidateConfig(); err != nil {
+		t.Fatalf("could not validate config %v", err)
+	}
+
+	// valid change, should be applied and reported
+	if app.Settings().SmtpServer != "frommainenv.net" {
+		t.Fatalf("wanted the environment to take precedence got %s", app.Settings().SmtpServer)
+	}
+	createTestConfigFile(filename, []byte(base+"POLL_INTERVAL=60\nSMTP_PASSWORD=newpassword\n"), t)
+	changes, err := app.ReloadConfig(filename)
+	if err != nil {
+		t.Fatalf("wanted no error got %v", err)
+	}
+	wantChanges := []string{"PollInterval changed from '30' to '60'", "SmtpPassword changed", "SmtpFrom changed from 'link@test.io' to 'testuser'"}
+	if !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("wanted %v got %v", wantChanges, changes)
+	}
+	if app.Settings().PollInterval != 60 {
+		t.Errorf("wanted poll interval 60 got %d", app.Settings().PollInterval)
+	}
+
+	// the precedence is the same as when loaded, and settings removed from config.env are unset
+	if app.Settings().SmtpServer != "frommainenv.net" || os.Getenv("SMTP_SERVER") != "frommainenv.net" {
+		t.Errorf("wanted the environment to keep precedence got %s", app.Settings().SmtpServer)
+	}
+	if _, set := os.LookupEnv("SMTP_FROM"); set {
+		t.Error("wanted SMTP_FROM removed from config.env unset")
+	}
+
+	// invalid change, should be rejected and the config in use kept
+	createTestConfigFile(filename, []byte(base+"POLL_INTERVAL=soon\n"), t)
+	if _, err := app.ReloadConfig(filename); err != internal.ERR_POLL_INTERVAL_NOT_INT {
+		t.Errorf("wanted %v got %v", internal.ERR_POLL_INTERVAL_NOT_INT, err)
+	}
+	if app.Settings().PollInterval != 60 {
+		t.Errorf("wanted poll interval 60 got %d", app.Settings().PollInterval)
+	}
+}
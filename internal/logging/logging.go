@@ -0,0 +1,383 @@
+// Package logging provides leveled logging as text or JSON lines. A logger carries fields, such as
+// the id of the approval being processed, which are written on every line it logs so one approval
+// or request can be traced through the log. Loggers derived with With share the configuration and
+// output of the logger they were derived from
+package logging
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line, lines below the configured level are not written
+type Level int
+
+const (
+	LEVEL_DEBUG Level = iota
+	LEVEL_INFO
+	LEVEL_WARN
+	LEVEL_ERROR
+	LEVEL_FATAL
+)
+
+var levelNames = map[Level]string{
+	LEVEL_DEBUG: "debug",
+	LEVEL_INFO:  "info",
+	LEVEL_WARN:  "warn",
+	LEVEL_ERROR: "error",
+	LEVEL_FATAL: "fatal",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+const (
+	FORMAT_TEXT = "text"
+	FORMAT_JSON = "json"
+
+	OUTPUT_STDOUT = "stdout"
+	OUTPUT_STDERR = "stderr"
+
+	// correlation ids, and the appliance, written as fields
+	KEY_REQUEST_ID  = "requestId"
+	KEY_POLL_ID     = "pollId"
+	KEY_APPLIANCE   = "appliance"
+	KEY_APPROVAL_ID = "approvalId"
+	KEY_WORKFLOW_ID = "workflowId"
+
+	// text lines are coloured on the console as they were by koan
+	colorGray   = "\033[37m"
+	colorYellow = "\033[33m"
+	colorRed    = "\033[31m"
+	colorReset  = "\033[0m"
+)
+
+var (
+	ERR_BAD_LEVEL  = errors.New("Log level must be 'debug', 'info', 'warn' or 'error'")
+	ERR_BAD_FORMAT = errors.New("Log format must be 'text' or 'json'")
+)
+
+// Field is a key and value written with a log line
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// RequestId returns the field identifying an HTTP request
+func RequestId(id string) Field { return F(KEY_REQUEST_ID, id) }
+
+// PollId returns the field identifying a poll for new approvals
+func PollId(id string) Field { return F(KEY_POLL_ID, id) }
+
+// Appliance returns the field naming the Morpheus appliance
+func Appliance(name string) Field { return F(KEY_APPLIANCE, name) }
+
+// ApprovalId returns the field identifying a Morpheus approval
+func ApprovalId(id int) Field { return F(KEY_APPROVAL_ID, id) }
+
+// WorkflowId returns the field identifying the approval workflow Link runs for an approval
+func WorkflowId(id string) Field { return F(KEY_WORKFLOW_ID, id) }
+
+// ParseLevel parses a level name, an empty name is the info level
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LEVEL_DEBUG, nil
+	case "", "info":
+		return LEVEL_INFO, nil
+	case "warn", "warning":
+		return LEVEL_WARN, nil
+	case "error":
+		return LEVEL_ERROR, nil
+	}
+	return LEVEL_INFO, ERR_BAD_LEVEL
+}
+
+// ValidFormat reports whether the format is known, an empty format is text
+func ValidFormat(format string) bool {
+	switch strings.ToLower(format) {
+	case "", FORMAT_TEXT, FORMAT_JSON:
+		return true
+	}
+	return false
+}
+
+// NewId returns a random id for correlating the lines logged for a request or poll
+func NewId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// sink is the configuration and output shared by a logger and the loggers derived from it
+type sink struct {
+	mu     sync.Mutex
+	level  Level
+	format string
+	output string
+	w      io.Writer
+	closer io.Closer
+	color  bool
+	now    func() time.Time
+	exit   func(code int)
+}
+
+// Logger writes log lines with its fields, it is safe for use by multiple goroutines
+type Logger struct {
+	sink   *sink
+	fields []Field
+}
+
+// New returns a logger writing text lines at the info level to stderr
+func New() *Logger {
+	return &Logger{sink: &sink{
+		level:  LEVEL_INFO,
+		format: FORMAT_TEXT,
+		output: OUTPUT_STDERR,
+		w:      os.Stderr,
+		color:  true,
+		now:    time.Now,
+		exit:   os.Exit,
+	}}
+}
+
+// NewWriter returns a logger writing to w at the level and in the format given, without colour
+func NewWriter(w io.Writer, level Level, format string) *Logger {
+	l := New()
+	l.sink.level, l.sink.format, l.sink.output, l.sink.w, l.sink.color = level, strings.ToLower(format), "", w, false
+	return l
+}
+
+// Configure sets the level, format and output of the logger and the loggers derived from it. The
+// output is stdout, stderr or a file appended to. A file previously logged to is closed
+func (l *Logger) Configure(level, format, output string) error {
+	lvl, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	if !ValidFormat(format) {
+		return ERR_BAD_FORMAT
+	}
+	format = strings.ToLower(format)
+	if format == "" {
+		format = FORMAT_TEXT
+	}
+	if output == "" {
+		output = OUTPUT_STDERR
+	}
+
+	s := l.sink
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if output != s.output {
+		var w io.Writer
+		var closer io.Closer
+		switch output {
+		case OUTPUT_STDOUT:
+			w = os.Stdout
+		case OUTPUT_STDERR:
+			w = os.Stderr
+		default:
+			f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				return fmt.Errorf("Could not open log file: %v", err)
+			}
+			w, closer = f, f
+		}
+		if s.closer != nil {
+			_ = s.closer.Close()
+		}
+		s.w, s.closer, s.output = w, closer, output
+		s.color = closer == nil
+	}
+	s.level, s.format = lvl, format
+	return nil
+}
+
+// With returns a logger which adds the fields to every line, replacing any fields of the
+// logger with the same keys
+func (l *Logger) With(fields ...Field) *Logger {
+	merged := make([]Field, 0, len(l.fields)+len(fields))
+	for _, f := range l.fields {
+		if !hasKey(fields, f.Key) {
+			merged = append(merged, f)
+		}
+	}
+	return &Logger{sink: l.sink, fields: append(merged, fields...)}
+}
+
+func hasKey(fields []Field, key string) bool {
+	for _, f := range fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// Enabled reports whether lines at the level are written
+func (l *Logger) Enabled(level Level) bool {
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+	return level >= l.sink.level
+}
+
+// Debug logs detail useful when diagnosing a problem
+func (l *Logger) Debug(msg string, fields ...Field) {
+	l.log(LEVEL_DEBUG, msg, nil, fields)
+}
+
+// Info logs normal operation
+func (l *Logger) Info(msg string, fields ...Field) {
+	l.log(LEVEL_INFO, msg, nil, fields)
+}
+
+// Warn logs a problem which Link works around
+func (l *Logger) Warn(msg string, fields ...Field) {
+	l.log(LEVEL_WARN, msg, nil, fields)
+}
+
+// Error logs a failure with its error
+func (l *Logger) Error(msg string, err error, fields ...Field) {
+	l.log(LEVEL_ERROR, msg, err, fields)
+}
+
+// FatalError logs a failure with its error and exits
+func (l *Logger) FatalError(msg string, err error, fields ...Field) {
+	l.log(LEVEL_FATAL, msg, err, fields)
+	l.sink.exit(1)
+}
+
+func (l *Logger) log(level Level, msg string, err error, fields []Field) {
+	s := l.sink
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if level < s.level {
+		return
+	}
+
+	if len(fields) > 0 {
+		fields = l.With(fields...).fields
+	} else {
+		fields = l.fields
+	}
+
+	var line []byte
+	if s.format == FORMAT_JSON {
+		line = jsonLine(s.now(), level, msg, err, fields)
+	} else {
+		line = textLine(s.now(), level, msg, err, fields, s.color)
+	}
+	_, _ = s.w.Write(line)
+}
+
+// jsonLine formats a line as a JSON object, with the time, level, message and error before the fields
+func jsonLine(now time.Time, level Level, msg string, err error, fields []Field) []byte {
+	var b bytes.Buffer
+	b.WriteString(`{"time":`)
+	writeJSON(&b, now.UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSON(&b, level.String())
+	b.WriteString(`,"msg":`)
+	writeJSON(&b, msg)
+	if err != nil {
+		b.WriteString(`,"error":`)
+		writeJSON(&b, err.Error())
+	}
+	for _, f := range fields {
+		b.WriteByte(',')
+		writeJSON(&b, f.Key)
+		b.WriteByte(':')
+		writeJSON(&b, f.Value)
+	}
+	b.WriteString("}\n")
+	return b.Bytes()
+}
+
+// writeJSON writes the value without HTML escaping, so URLs in messages stay readable
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	var enc bytes.Buffer
+	e := json.NewEncoder(&enc)
+	e.SetEscapeHTML(false)
+	if err := e.Encode(v); err != nil {
+		enc.Reset()
+		_ = e.Encode(fmt.Sprint(v))
+	}
+	b.Write(bytes.TrimSuffix(enc.Bytes(), []byte("\n")))
+}
+
+// textLine formats a line as koan did, 'LEVEL: message (error)', followed by the fields
+func textLine(now time.Time, level Level, msg string, err error, fields []Field, color bool) []byte {
+	var b bytes.Buffer
+	b.WriteString(now.Format("2006/01/02 15:04:05 "))
+
+	c := colorGray
+	switch {
+	case level == LEVEL_WARN:
+		c = colorYellow
+	case level >= LEVEL_ERROR:
+		c = colorRed
+	}
+	if color {
+		b.WriteString(c)
+	}
+
+	prefix := strings.ToUpper(level.String())
+	if level == LEVEL_FATAL {
+		prefix = "FATAL ERROR"
+	}
+	fmt.Fprintf(&b, "%s: %s", prefix, msg)
+	if err != nil {
+		fmt.Fprintf(&b, " (%v)", err)
+	}
+	for _, f := range fields {
+		value := fmt.Sprint(f.Value)
+		if strings.ContainsAny(value, " \"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+		fmt.Fprintf(&b, " %s=%s", f.Key, value)
+	}
+
+	if color {
+		b.WriteString(colorReset)
+	}
+	b.WriteByte('\n')
+	return b.Bytes()
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger, so code called with the context logs the
+// fields of the caller, such as the id of the approval being processed
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger carried by the context, or the fallback if there is none
+func FromContext(ctx context.Context, fallback *Logger) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+			return l
+		}
+	}
+	return fallback
+}
@@ -0,0 +1,99 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLogger(buf *bytes.Buffer, level Level, format string) *Logger {
+	l := NewWriter(buf, level, format)
+	l.sink.now = func() time.Time { return time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC) }
+	return l
+}
+
+func TestLogger_JSON(t *testing.T) {
+	var buf bytes.Buffer
+	poll := testLogger(&buf, LEVEL_INFO, FORMAT_JSON).With(PollId("p1"), Appliance("default"))
+	log := poll.With(ApprovalId(7), WorkflowId("w1"))
+
+	log.Debug("not written")
+	log.Info("Sent 'APPROVAL-7'")
+	log.Error("Could not send", errors.New("connection refused"), Appliance("emea"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("wanted 2 lines got %q", buf.String())
+	}
+	want := `{"time":"2022-03-01T10:00:00Z","level":"info","msg":"Sent 'APPROVAL-7'","pollId":"p1","appliance":"default","approvalId":7,"workflowId":"w1"}`
+	if lines[0] != want {
+		t.Errorf("wanted\n%s\ngot\n%s", want, lines[0])
+	}
+
+	// fields given with a line replace those of the logger
+	var got map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+		t.Fatalf("line is not JSON %v", err)
+	}
+	if got["level"] != "error" || got["error"] != "connection refused" || got["appliance"] != "emea" || got["approvalId"] != float64(7) {
+		t.Errorf("unexpected line %v", got)
+	}
+}
+
+func TestLogger_Text(t *testing.T) {
+	var buf bytes.Buffer
+	log := testLogger(&buf, LEVEL_DEBUG, FORMAT_TEXT).With(RequestId("r1"), F("path", "/audit export"))
+	log.Warn("Refused request")
+
+	want := "2022/03/01 10:00:00 WARN: Refused request requestId=r1 path=\"/audit export\"\n"
+	if got := buf.String(); got != want {
+		t.Errorf("wanted %q got %q", want, got)
+	}
+}
+
+func TestLogger_Configure(t *testing.T) {
+	l := New()
+	log := l.With(PollId("p1"))
+	file := filepath.Join(t.TempDir(), "link.log")
+
+	if err := l.Configure("warn", "json", file); err != nil {
+		t.Fatalf("could not configure %v", err)
+	}
+	log.Info("not written")
+	log.Warn("written")
+	if err := l.Configure("", "", OUTPUT_STDERR); err != nil {
+		t.Fatalf("could not configure %v", err)
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("could not read log %v", err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 1 || !strings.Contains(lines[0], `"msg":"written","pollId":"p1"`) {
+		t.Errorf("wanted the derived logger to write one line to the file got %q", data)
+	}
+
+	if err := l.Configure("verbose", "", ""); !errors.Is(err, ERR_BAD_LEVEL) {
+		t.Errorf("wanted %v got %v", ERR_BAD_LEVEL, err)
+	}
+	if err := l.Configure("", "xml", ""); !errors.Is(err, ERR_BAD_FORMAT) {
+		t.Errorf("wanted %v got %v", ERR_BAD_FORMAT, err)
+	}
+}
+
+func TestFromContext(t *testing.T) {
+	fallback := New()
+	if FromContext(context.Background(), fallback) != fallback {
+		t.Error("wanted the fallback without a logger in the context")
+	}
+	log := fallback.With(RequestId("r1"))
+	if FromContext(NewContext(context.Background(), log), fallback) != log {
+		t.Error("wanted the logger of the context")
+	}
+}
@@ -0,0 +1,50 @@
+package metrics
+
+// channels notifications are sent through
+const CHANNEL_EMAIL = "email"
+
+// The metrics Link exposes, labelled by appliance where they are counted per appliance
+var (
+	Polls = NewCounter("link_polls_total",
+		"Polls of a Morpheus appliance for new approvals.", "appliance")
+	PollErrors = NewCounter("link_poll_errors_total",
+		"Polls which could not list the new approvals of a Morpheus appliance.", "appliance")
+
+	APILatency = NewHistogram("link_morpheus_request_duration_seconds",
+		"Duration of Morpheus API requests by endpoint, ids in the path are replaced with ':id'.",
+		LATENCY_BUCKETS, "appliance", "method", "endpoint")
+	APIErrors = NewCounter("link_morpheus_request_errors_total",
+		"Morpheus API requests which failed or had an unsuccessful response, by endpoint.",
+		"appliance", "method", "endpoint")
+
+	ApprovalsDetected = NewCounter("link_approvals_detected_total",
+		"New requested approvals found by polling.", "appliance")
+	ApprovalsMatched = NewCounter("link_approvals_matched_total",
+		"New approvals routed by at least one approval configuration.", "appliance")
+	ApprovalsUnmatched = NewCounter("link_approvals_unmatched_total",
+		"New approvals matched by no approval configuration.", "appliance")
+
+	NotificationsSent = NewCounter("link_notifications_sent_total",
+		"Notifications sent, by channel.", "channel")
+	NotificationsFailed = NewCounter("link_notifications_failed_total",
+		"Notifications which could not be composed or sent, by channel.", "channel")
+
+	Votes = NewCounter("link_votes_total",
+		"Votes cast by recipients of approval notifications, by decision.", "decision")
+
+	TimeToDecision = NewHistogram("link_approval_decision_seconds",
+		"Time from an approval being requested to it being decided, by approval configuration and outcome.",
+		DECISION_BUCKETS, "config", "outcome")
+
+	WorkflowsInFlight = NewGauge("link_workflows_in_flight",
+		"Approval workflows awaiting a decision.", "appliance")
+	ApprovalsQueued = NewGauge("link_approvals_queued",
+		"Approvals queued for retry after a failed lookup.", "appliance")
+)
+
+// ForgetAppliance removes the series of an appliance which is no longer polled, so its gauges
+// are not reported with stale values
+func ForgetAppliance(name string) {
+	WorkflowsInFlight.Delete(name)
+	ApprovalsQueued.Delete(name)
+}
@@ -0,0 +1,239 @@
+// Package metrics provides the counters, gauges and histograms Link exposes at /metrics in the
+// Prometheus text format. Metrics are registered when declared and are safe for use by multiple
+// goroutines
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TYPE_COUNTER   = "counter"
+	TYPE_GAUGE     = "gauge"
+	TYPE_HISTOGRAM = "histogram"
+
+	// CONTENT_TYPE is the content type of the Prometheus text format
+	CONTENT_TYPE = "text/plain; version=0.0.4; charset=utf-8"
+
+	// label values joined to key a series
+	labelSep = "\xff"
+)
+
+var (
+	// LATENCY_BUCKETS are the upper bounds, in seconds, of request durations
+	LATENCY_BUCKETS = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+	// DECISION_BUCKETS are the upper bounds, in seconds, of the time taken to decide an approval,
+	// from 5 minutes to a week
+	DECISION_BUCKETS = []float64{300, 900, 3600, 4 * 3600, 12 * 3600, 24 * 3600, 3 * 24 * 3600, 7 * 24 * 3600}
+)
+
+// metric is a family of series sharing a name and label names
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series holds the value of a metric for one set of label values, histograms also count
+// observations in each bucket
+type series struct {
+	values []string
+	value  float64
+	counts []uint64
+	count  uint64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []*metric
+)
+
+func register(name, help, kind string, buckets []float64, labels []string) *metric {
+	m := &metric{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: map[string]*series{}}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, m)
+	return m
+}
+
+// with returns the series for the label values, creating it if need be. The caller holds the lock
+func (m *metric) with(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s has labels %v, got values %v", m.name, m.labels, values))
+	}
+	key := strings.Join(values, labelSep)
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if m.kind == TYPE_HISTOGRAM {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Delete removes the series for the label values, such as for an appliance no longer polled
+func (m *metric) Delete(values ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.series, strings.Join(values, labelSep))
+}
+
+// Counter is a count which only goes up, such as of requests made
+type Counter struct{ *metric }
+
+// NewCounter registers a counter with the label names given
+func NewCounter(name, help string, labels ...string) Counter {
+	return Counter{register(name, help, TYPE_COUNTER, nil, labels)}
+}
+
+// Inc adds one to the series for the label values
+func (c Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds n, which must not be negative, to the series for the label values
+func (c Counter) Add(n float64, values ...string) {
+	if n < 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.with(values).value += n
+}
+
+// Gauge is a value which goes up and down, such as the workflows in flight
+type Gauge struct{ *metric }
+
+// NewGauge registers a gauge with the label names given
+func NewGauge(name, help string, labels ...string) Gauge {
+	return Gauge{register(name, help, TYPE_GAUGE, nil, labels)}
+}
+
+// Set sets the series for the label values
+func (g Gauge) Set(v float64, values ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.with(values).value = v
+}
+
+// Histogram counts observations, such as durations, in buckets with the upper bounds given
+type Histogram struct{ *metric }
+
+// NewHistogram registers a histogram with the bucket upper bounds and label names given
+func NewHistogram(name, help string, buckets []float64, labels ...string) Histogram {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return Histogram{register(name, help, TYPE_HISTOGRAM, sorted, labels)}
+}
+
+// Observe adds the value to the series for the label values
+func (h Histogram) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s := h.with(values)
+	for i, upper := range h.buckets {
+		if v <= upper {
+			s.counts[i]++
+		}
+	}
+	s.count++
+	s.value += v
+}
+
+// ObserveDuration adds the duration in seconds
+func (h Histogram) ObserveDuration(d time.Duration, values ...string) {
+	h.Observe(d.Seconds(), values...)
+}
+
+// Write writes every registered metric in the Prometheus text format, series are ordered by
+// their label values so the output is stable
+func Write(w io.Writer) error {
+	registryMu.Lock()
+	metrics := append([]*metric(nil), registry...)
+	registryMu.Unlock()
+
+	var b strings.Builder
+	for _, m := range metrics {
+		m.write(&b)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func (m *metric) write(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(b, "# HELP %s %s\n", m.name, escape(m.help, false))
+	fmt.Fprintf(b, "# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != TYPE_HISTOGRAM {
+			fmt.Fprintf(b, "%s%s %s\n", m.name, labelPairs(m.labels, s.values, "", ""), formatFloat(s.value))
+			continue
+		}
+		for i, upper := range m.buckets {
+			fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, labelPairs(m.labels, s.values, "le", formatFloat(upper)), s.counts[i])
+		}
+		fmt.Fprintf(b, "%s_bucket%s %d\n", m.name, labelPairs(m.labels, s.values, "le", "+Inf"), s.count)
+		fmt.Fprintf(b, "%s_sum%s %s\n", m.name, labelPairs(m.labels, s.values, "", ""), formatFloat(s.value))
+		fmt.Fprintf(b, "%s_count%s %d\n", m.name, labelPairs(m.labels, s.values, "", ""), s.count)
+	}
+}
+
+// labelPairs formats the labels of a series, with an extra label such as a bucket's 'le'
+func labelPairs(names, values []string, extraName, extraValue string) string {
+	pairs := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escape(values[i], true)))
+	}
+	if extraName != "" {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", extraName, extraValue))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// escape escapes backslashes and newlines, and double quotes in label values
+func escape(s string, quotes bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quotes {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	registryMu.Lock()
+	saved := registry
+	registry = nil
+	registryMu.Unlock()
+	defer func() {
+		registryMu.Lock()
+		registry = saved
+		registryMu.Unlock()
+	}()
+
+	polls := NewCounter("test_polls_total", "Polls.", "appliance")
+	inFlight := NewGauge("test_in_flight", "In flight.", "appliance")
+	latency := NewHistogram("test_duration_seconds", "Duration.", []float64{1, 0.1}, "endpoint")
+
+	polls.Inc("b")
+	polls.Inc("a")
+	polls.Add(2, "a")
+	polls.Add(-1, "a")
+	inFlight.Set(3, "a")
+	inFlight.Set(1, "gone")
+	inFlight.Delete("gone")
+	latency.Observe(0.05, `/api/"quoted"`)
+	latency.ObserveDuration(500*time.Millisecond, `/api/"quoted"`)
+	latency.Observe(2, `/api/"quoted"`)
+
+	var b strings.Builder
+	if err := Write(&b); err != nil {
+		t.Fatalf("could not write metrics %v", err)
+	}
+
+	want := `# HELP test_polls_total Polls.
+# TYPE test_polls_total counter
+test_polls_total{appliance="a"} 3
+test_polls_total{appliance="b"} 1
+# HELP test_in_flight In flight.
+# TYPE test_in_flight gauge
+test_in_flight{appliance="a"} 3
+# HELP test_duration_seconds Duration.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{endpoint="/api/\"quoted\"",le="0.1"} 1
+test_duration_seconds_bucket{endpoint="/api/\"quoted\"",le="1"} 2
+test_duration_seconds_bucket{endpoint="/api/\"quoted\"",le="+Inf"} 3
+test_duration_seconds_sum{endpoint="/api/\"quoted\""} 2.55
+test_duration_seconds_count{endpoint="/api/\"quoted\""} 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("wanted\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestCounter_WrongLabels(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("wanted a panic for the wrong number of label values")
+		}
+	}()
+	c := Counter{&metric{name: "test_total", labels: []string{"appliance"}, series: map[string]*series{}}}
+	c.Inc()
+}
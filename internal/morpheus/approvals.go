@@ -0,0 +1,224 @@
+package morpheus
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+// ApprovalsResponse stores the limited data we need to hold about the approvals at this stage
+// the remainder we will find by making further requests for each approval id
+type ApprovalsResponse struct {
+	Approvals []approval.Approval `json:"approvals"`
+}
+
+type ApprovalResponse struct {
+	Approval approval.Approval `json:"approval"`
+}
+
+const (
+	// approval item actions
+	ITEM_APPROVE = "approve"
+	ITEM_DENY    = "deny"
+	ITEM_CANCEL  = "cancel"
+
+	// approvals are listed in pages of this size, newest first
+	APPROVALS_PAGE_SIZE = 100
+)
+
+var ERR_BAD_ITEM_ACTION = errors.New("Approval item action must be 'approve', 'deny' or 'cancel'")
+
+// CheckNewApprovals queues the approvals created since last checked which are awaiting a
+// decision and processes the queue, returning the approvals with their details for routing.
+// Approvals listed already resolved are not queued, LastPollId is advanced past them. An
+// approval stays queued until it has been fetched and found resolved, or the caller marks it
+// Done after routing it, so approvals which fail to be fetched are retried on a later poll
+// rather than lost. An error listing the approvals does not stop the queue being processed
+func (c *Client) CheckNewApprovals(ctx context.Context, st *state.State) ([]approval.Approval, error) {
+	newApprovals, listErr := c.ListApprovalsSince(ctx, st.LastPollId)
+	for _, a := range newApprovals {
+		if a.State() != approval.STATUS_REQUESTED {
+			st.Advance(a.Id)
+			continue
+		}
+		st.Enqueue(a.Id)
+	}
+
+	// approvalsRequested contains the approvals which we need to further inspect and match
+	// against approval policy logic
+	var approvalsRequested []approval.Approval
+	for _, id := range st.Due(c.now()) {
+		if ctx.Err() != nil {
+			break
+		}
+
+		// a workflow is already open if the approval was routed but the queue was not saved
+		if st.Managing(id) {
+			st.Done(id)
+			continue
+		}
+
+		// requests made for the approval are logged with its id
+		log := c.log(ctx).With(logging.ApprovalId(id))
+		actx := logging.NewContext(ctx, log)
+
+		a, err := c.GetApproval(actx, id)
+		if errors.Is(err, ERR_NOT_FOUND) {
+			log.Warn(fmt.Sprintf("Approval %d no longer exists in Morpheus, removed from the queue", id))
+			st.Done(id)
+			continue
+		}
+		if err == nil && a.State() == approval.STATUS_REQUESTED {
+			// the approval does not tell us much about the scope, nor which approval policy generated it
+			// so we interrogate the instances or apps which are subject to the approval for enough data
+			// to match on the approval routing configuration
+			err = c.GetDetails(actx, &a)
+		}
+		if err != nil {
+			q := st.Retry(id, err, c.now())
+			log.Warn(fmt.Sprintf("Could not process approval %d (attempt %d), retrying at %s: %v",
+				id, q.Attempts, q.NextAttempt.Format(time.RFC3339), err))
+			continue
+		}
+
+		// approvals no longer requested need no routing
+		if a.State() != approval.STATUS_REQUESTED {
+			st.Done(id)
+			continue
+		}
+
+		approvalsRequested = append(approvalsRequested, a)
+	}
+
+	return approvalsRequested, listErr
+}
+
+// Resolution returns the audit outcome of an approval from its status, which counts the items in
+// each state, e.g. '1 approved'. An approval is resolved when no item is still requested
+func Resolution(status string) (string, bool) {
+	s := approval.ParseStatus(status)
+	if !s.Resolved() {
+		return audit.OUTCOME_PENDING, false
+	}
+	switch s {
+	case approval.STATUS_DENIED:
+		return audit.OUTCOME_DENIED, true
+	case approval.STATUS_CANCELLED:
+		return audit.OUTCOME_CANCELLED, true
+	}
+	return audit.OUTCOME_APPROVED, true
+}
+
+// ListApprovals obtains every approval from the Morpheus API, in any state
+func (c *Client) ListApprovals(ctx context.Context) ([]approval.Approval, error) {
+	return c.ListApprovalsSince(ctx, 0)
+}
+
+// ListApprovalsSince obtains the approvals with an id greater than afterId, in any state, ordered
+// by id and marked with the appliance they are from. Pages are requested newest first, stopping at the first page reaching an approval already
+// seen, so only the new approvals are fetched however many approvals the appliance holds
+func (c *Client) ListApprovalsSince(ctx context.Context, afterId int) ([]approval.Approval, error) {
+	var approvals []approval.Approval
+
+	for offset := 0; ; offset += APPROVALS_PAGE_SIZE {
+		approvalsRes := ApprovalsResponse{}
+		path := fmt.Sprintf("/api/approvals?max=%d&offset=%d&sort=id&direction=desc", APPROVALS_PAGE_SIZE, offset)
+		if err := c.Get(ctx, path, &approvalsRes); err != nil {
+			return nil, err
+		}
+
+		seen := false
+		for _, a := range approvalsRes.Approvals {
+			if a.Id <= afterId {
+				seen = true
+				continue
+			}
+			a.Appliance = c.Appliance
+			approvals = append(approvals, a)
+		}
+
+		if seen || len(approvalsRes.Approvals) < APPROVALS_PAGE_SIZE {
+			break
+		}
+	}
+
+	// approvals created while paging shift the pages, so an approval may be listed twice
+	sort.SliceStable(approvals, func(i, j int) bool {
+		return approvals[i].Id < approvals[j].Id
+	})
+	deduped := approvals[:0]
+	for i, a := range approvals {
+		if i > 0 && a.Id == approvals[i-1].Id {
+			continue
+		}
+		deduped = append(deduped, a)
+	}
+
+	return deduped, nil
+}
+
+// GetApproval obtains information from the Morpheus API about the approval, marked with the
+// appliance it is from
+func (c *Client) GetApproval(ctx context.Context, id int) (approval.Approval, error) {
+	approvalRes := ApprovalResponse{}
+	if err := c.Get(ctx, fmt.Sprintf("/api/approvals/%d", id), &approvalRes); err != nil {
+		return approvalRes.Approval, err
+	}
+
+	approvalRes.Approval.Appliance = c.Appliance
+	return approvalRes.Approval, nil
+}
+
+// ActOnItem approves, denies or cancels a single approval item
+func (c *Client) ActOnItem(ctx context.Context, itemId int, action string) error {
+	switch action {
+	case ITEM_APPROVE, ITEM_DENY, ITEM_CANCEL:
+	default:
+		return fmt.Errorf("%w '%s'", ERR_BAD_ITEM_ACTION, action)
+	}
+
+	res, err := c.Do(ctx, http.MethodPut, fmt.Sprintf("/api/approval-items/%d/%s", itemId, action), nil)
+	if err != nil {
+		return err
+	}
+	return res.Body.Close()
+}
+
+// Decide approves, denies or cancels each item of the approval which is still requested
+func (c *Client) Decide(ctx context.Context, a approval.Approval, action string) error {
+	for _, item := range a.Items {
+		if item.Status != "" && approval.ParseItemStatus(string(item.Status)) != approval.ITEM_REQUESTED {
+			continue
+		}
+		if err := c.ActOnItem(ctx, item.Id, action); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WhoAmIResponse holds the user the API token belongs to
+type WhoAmIResponse struct {
+	User struct {
+		Id       int    `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+}
+
+// CheckConnection makes an authenticated request to the Morpheus API, returning the
+// username of the user the token belongs to
+func (c *Client) CheckConnection(ctx context.Context) (string, error) {
+	whoAmIRes := WhoAmIResponse{}
+	if err := c.Get(ctx, "/api/whoami", &whoAmIRes); err != nil {
+		return "", err
+	}
+	return whoAmIRes.User.Username, nil
+}
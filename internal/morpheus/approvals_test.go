@@ -0,0 +1,270 @@
+package morpheus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+// fakeApprovals serves approvals 1 to total from /api/approvals newest first, paged by
+// max and offset, counting the pages requested
+type fakeApprovals struct {
+	total int
+	pages int
+}
+
+func (f *fakeApprovals) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	max, _ := strconv.Atoi(query.Get("max"))
+	offset, _ := strconv.Atoi(query.Get("offset"))
+	if query.Get("sort") != "id" || query.Get("direction") != "desc" || max == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.pages++
+
+	res := ApprovalsResponse{Approvals: []approval.Approval{}}
+	for id := f.total - offset; id > 0 && id > f.total-offset-max; id-- {
+		res.Approvals = append(res.Approvals, approval.Approval{Id: id, Status: "1 requested"})
+	}
+	_ = json.NewEncoder(w).Encode(res)
+}
+
+func TestClient_ListApprovalsSince(t *testing.T) {
+	testCases := []struct {
+		name      string
+		total     int
+		afterId   int
+		wantFirst int
+		wantCount int
+		wantPages int
+	}{
+		{name: "new approvals on first page", total: 25000, afterId: 24990, wantFirst: 24991, wantCount: 10, wantPages: 1},
+		{name: "new approvals span pages", total: 25000, afterId: 24850, wantFirst: 24851, wantCount: 150, wantPages: 2},
+		{name: "no new approvals", total: 25000, afterId: 25000, wantCount: 0, wantPages: 1},
+		{name: "everything", total: 250, afterId: 0, wantFirst: 1, wantCount: 250, wantPages: 3},
+		{name: "everything, exact pages", total: 200, afterId: 0, wantFirst: 1, wantCount: 200, wantPages: 3},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			fake := &fakeApprovals{total: tc.total}
+			srv := httptest.NewServer(fake)
+			defer srv.Close()
+
+			client, err := NewClient(internal.Appliance{Host: srv.URL, Timeout: 5}, logging.New())
+			if err != nil {
+				t.Fatalf("could not create client %v", err)
+			}
+
+			approvals, err := client.ListApprovalsSince(context.Background(), tc.afterId)
+			if err != nil {
+				t.Fatalf("wanted no error got %v", err)
+			}
+			if len(approvals) != tc.wantCount || fake.pages != tc.wantPages {
+				t.Fatalf("wanted %d approvals in %d pages got %d in %d", tc.wantCount, tc.wantPages, len(approvals), fake.pages)
+			}
+			for i, a := range approvals {
+				if a.Id != tc.wantFirst+i {
+					t.Fatalf("wanted approvals in id order from %d, got %d at %d", tc.wantFirst, a.Id, i)
+				}
+			}
+		})
+	}
+}
+
+// fakeQueue lists the approvals with the statuses given, serving each from /api/approvals/{id}
+// unless it is set to fail, or has been deleted. The requests for each approval are counted
+type fakeQueue struct {
+	statuses map[int]string
+	failing  map[int]bool
+	listDown bool
+	fetched  map[int]int
+}
+
+func (f *fakeQueue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/api/approvals" {
+		if f.listDown {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		res := ApprovalsResponse{Approvals: []approval.Approval{}}
+		for id, status := range f.statuses {
+			res.Approvals = append(res.Approvals, approval.Approval{Id: id, Status: status})
+		}
+		sort.Slice(res.Approvals, func(i, j int) bool {
+			return res.Approvals[i].Id > res.Approvals[j].Id
+		})
+		_ = json.NewEncoder(w).Encode(res)
+		return
+	}
+
+	id, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/approvals/"))
+	if f.fetched == nil {
+		f.fetched = map[int]int{}
+	}
+	f.fetched[id]++
+	status, ok := f.statuses[id]
+	switch {
+	case f.failing[id]:
+		w.WriteHeader(http.StatusInternalServerError)
+	case !ok:
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		_ = json.NewEncoder(w).Encode(ApprovalResponse{Approval: approval.Approval{Id: id, Name: "APPROVAL-" + strconv.Itoa(id), Status: status}})
+	}
+}
+
+func TestClient_CheckNewApprovals(t *testing.T) {
+	fake := &fakeQueue{
+		statuses: map[int]string{1: "1 requested", 2: "1 requested", 3: "1 approved", 4: "1 requested"},
+		failing:  map[int]bool{2: true},
+	}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	client, err := NewClient(internal.Appliance{Host: srv.URL, Timeout: 5}, logging.New())
+	if err != nil {
+		t.Fatalf("could not create client %v", err)
+	}
+	now := time.Now()
+	client.now = func() time.Time { return now }
+
+	ids := func(approvals []approval.Approval) []int {
+		var ids []int
+		for _, a := range approvals {
+			ids = append(ids, a.Id)
+		}
+		return ids
+	}
+	queued := func(st *state.State) []int {
+		var ids []int
+		for _, q := range st.Pending() {
+			ids = append(ids, q.ApprovalId)
+		}
+		return ids
+	}
+
+	ctx := context.Background()
+	st := &state.State{}
+
+	// a failed fetch does not stop the others, and the resolved approval needs no routing
+	approvals, err := client.CheckNewApprovals(ctx, st)
+	if err != nil || st.LastPollId != 4 {
+		t.Fatalf("wanted no error and last poll id 4 got %d %v", st.LastPollId, err)
+	}
+	if got := ids(approvals); len(got) != 2 || got[0] != 1 || got[1] != 4 {
+		t.Fatalf("wanted approvals 1 and 4 got %v", got)
+	}
+	if got := queued(st); len(got) != 3 {
+		t.Fatalf("wanted 1, 2 and 4 queued until done got %v", got)
+	}
+
+	// an approval listed already resolved is neither queued nor fetched
+	if fake.fetched[3] != 0 {
+		t.Errorf("wanted approval 3 not fetched got %d requests", fake.fetched[3])
+	}
+
+	// an approval not marked done is processed again
+	st.Done(1)
+	fake.failing = nil
+	approvals, _ = client.CheckNewApprovals(ctx, st)
+	if got := ids(approvals); len(got) != 1 || got[0] != 4 {
+		t.Fatalf("wanted approval 4 again got %v", got)
+	}
+	st.Done(4)
+
+	// the failed approval is retried once its delay has passed, even if listing fails
+	fake.listDown = true
+	if approvals, _ := client.CheckNewApprovals(ctx, st); len(approvals) != 0 {
+		t.Fatalf("wanted no approvals before the retry delay got %v", ids(approvals))
+	}
+	now = now.Add(state.RETRY_BASE)
+	approvals, err = client.CheckNewApprovals(ctx, st)
+	if err == nil {
+		t.Error("wanted the listing error")
+	}
+	if got := ids(approvals); len(got) != 1 || got[0] != 2 {
+		t.Fatalf("wanted approval 2 retried got %v", got)
+	}
+	st.Done(2)
+
+	// a deleted approval is removed from the queue
+	fake.listDown = false
+	fake.statuses[5] = "1 requested"
+	fake.failing = map[int]bool{5: true}
+	_, _ = client.CheckNewApprovals(ctx, st)
+	delete(fake.statuses, 5)
+	fake.failing = nil
+	now = now.Add(state.RETRY_MAX)
+	if approvals, _ := client.CheckNewApprovals(ctx, st); len(approvals) != 0 || len(queued(st)) != 0 {
+		t.Errorf("wanted an empty queue got %v", queued(st))
+	}
+}
+
+func TestResolution(t *testing.T) {
+	testCases := map[string]struct {
+		outcome  string
+		resolved bool
+	}{
+		"1 requested":             {audit.OUTCOME_PENDING, false},
+		"1 approved, 1 requested": {audit.OUTCOME_PENDING, false},
+		"1 approved":              {audit.OUTCOME_APPROVED, true},
+		"2 denied":                {audit.OUTCOME_DENIED, true},
+		"1 cancelled":             {audit.OUTCOME_CANCELLED, true},
+	}
+	for status, want := range testCases {
+		outcome, resolved := Resolution(status)
+		if outcome != want.outcome || resolved != want.resolved {
+			t.Errorf("%s: wanted %s %v got %s %v", status, want.outcome, want.resolved, outcome, resolved)
+		}
+	}
+}
+
+func TestClient_CheckConnection(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/whoami" || r.Header.Get("Authorization") != "BEARER test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_, _ = w.Write([]byte(`{"user":{"id":1,"username":"svc-link"}}`))
+	}))
+	defer srv.Close()
+
+	testCases := []struct {
+		name     string
+		token    string
+		wantUser string
+		wantErr  bool
+	}{
+		{"connected", "test-token", "svc-link", false},
+		{"bad token", "guess", "", true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client, err := NewClient(internal.Appliance{Host: srv.URL, Token: tc.token, Timeout: 5}, logging.New())
+			if err != nil {
+				t.Fatalf("could not create client %v", err)
+			}
+			username, err := client.CheckConnection(context.Background())
+			var apiErr *APIError
+			if username != tc.wantUser || (err != nil) != tc.wantErr || (tc.wantErr && (!errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized)) {
+				t.Errorf("wanted user '%s' and error %v got '%s' %v", tc.wantUser, tc.wantErr, username, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package morpheus
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	GRANT_PASSWORD      = "password"
+	GRANT_REFRESH_TOKEN = "refresh_token"
+
+	// tokens are refreshed this long before they expire so a request is not made with a
+	// token which expires in flight
+	TOKEN_EXPIRY_MARGIN = time.Minute
+)
+
+var ERR_TOKEN_REQUEST = errors.New("Could not obtain a Morpheus API access token")
+
+// TokenResponse is the response of the Morpheus /oauth/token endpoint
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	TokenType    string `json:"token_type"`
+}
+
+// TokenSource obtains access tokens for a service account with the OAuth password grant, caching
+// the token until shortly before it expires, when it is refreshed with the refresh token
+type TokenSource struct {
+	Host     string
+	ClientId string
+	Username string
+	Password string
+
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+	expiry       time.Time
+	now          func() time.Time
+}
+
+// Token returns a valid access token, obtaining or refreshing one if needed. A failed refresh
+// falls back to the password grant
+func (ts *TokenSource) Token(ctx context.Context, client *http.Client) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if ts.now == nil {
+		ts.now = time.Now
+	}
+	if ts.accessToken != "" && ts.now().Add(TOKEN_EXPIRY_MARGIN).Before(ts.expiry) {
+		return ts.accessToken, nil
+	}
+
+	var tokenRes TokenResponse
+	var err error
+	if ts.refreshToken != "" {
+		tokenRes, err = ts.request(ctx, client, GRANT_REFRESH_TOKEN, url.Values{"refresh_token": {ts.refreshToken}})
+	}
+	if ts.refreshToken == "" || err != nil {
+		tokenRes, err = ts.request(ctx, client, GRANT_PASSWORD, url.Values{"username": {ts.Username}, "password": {ts.Password}})
+	}
+	if err != nil {
+		ts.accessToken, ts.refreshToken = "", ""
+		return "", err
+	}
+
+	ts.accessToken = tokenRes.AccessToken
+	ts.refreshToken = tokenRes.RefreshToken
+	ts.expiry = ts.now().Add(time.Duration(tokenRes.ExpiresIn) * time.Second)
+	return ts.accessToken, nil
+}
+
+// Invalidate discards the access token, after it has been rejected by the API, so the next
+// call to Token obtains a new one
+func (ts *TokenSource) Invalidate() {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.accessToken = ""
+}
+
+// request makes a token request with the grant type to the Morpheus /oauth/token endpoint
+func (ts *TokenSource) request(ctx context.Context, client *http.Client, grant string, form url.Values) (TokenResponse, error) {
+	tokenRes := TokenResponse{}
+
+	query := url.Values{"grant_type": {grant}, "scope": {"write"}, "client_id": {ts.ClientId}}
+	requestURI := fmt.Sprintf("%s/oauth/token?%s", strings.TrimRight(ts.Host, "/"), query.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, requestURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return tokenRes, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return tokenRes, fmt.Errorf("%w: %v", ERR_TOKEN_REQUEST, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return tokenRes, fmt.Errorf("%w: %s grant returned %d", ERR_TOKEN_REQUEST, grant, res.StatusCode)
+	}
+	if err := json.NewDecoder(res.Body).Decode(&tokenRes); err != nil {
+		return tokenRes, fmt.Errorf("%w: %v", ERR_TOKEN_REQUEST, err)
+	}
+	if tokenRes.AccessToken == "" {
+		return tokenRes, fmt.Errorf("%w: no access token in response", ERR_TOKEN_REQUEST)
+	}
+
+	return tokenRes, nil
+}
@@ -0,0 +1,104 @@
+package morpheus
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/logging"
+)
+
+// fakeOAuth issues tokens with the password and refresh grants, recording the grants used,
+// and serves /api/whoami to the token last issued only
+type fakeOAuth struct {
+	issued   int32
+	grants   []string
+	current  string
+	expireIn int
+}
+
+func (f *fakeOAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/oauth/token":
+		grant := r.URL.Query().Get("grant_type")
+		if err := r.ParseForm(); err != nil || r.URL.Query().Get("client_id") != "morph-api" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if grant == GRANT_PASSWORD && (r.PostForm.Get("username") != "svc-link" || r.PostForm.Get("password") != "secret") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		f.grants = append(f.grants, grant)
+		n := atomic.AddInt32(&f.issued, 1)
+		f.current = fmt.Sprintf("token-%d", n)
+		fmt.Fprintf(w, `{"access_token":"%s","refresh_token":"refresh-%d","expires_in":%d,"token_type":"bearer"}`, f.current, n, f.expireIn)
+	case "/api/whoami":
+		if r.Header.Get("Authorization") != "BEARER "+f.current {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"user":{"id":1,"username":"svc-link"}}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestTokenSource(t *testing.T) {
+	fake := &fakeOAuth{expireIn: 3600}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	cfg := internal.Appliance{
+		Host:     srv.URL,
+		User:     "svc-link",
+		Password: "secret",
+		ClientId: internal.MORPHEUS_CLIENT_ID,
+		Timeout:  5,
+	}
+	client, err := NewClient(cfg, logging.New())
+	if err != nil {
+		t.Fatalf("could not create client %v", err)
+	}
+
+	ctx := context.Background()
+	check := func(wantGrants int) {
+		t.Helper()
+		username, err := client.CheckConnection(ctx)
+		if err != nil || username != "svc-link" {
+			t.Fatalf("wanted 'svc-link' got '%s' %v", username, err)
+		}
+		if len(fake.grants) != wantGrants {
+			t.Fatalf("wanted %d token requests got %v", wantGrants, fake.grants)
+		}
+	}
+
+	// the token is obtained with the password grant and cached
+	check(1)
+	check(1)
+
+	// a token close to expiry is refreshed
+	ts := client.tokens
+	ts.now = func() time.Time { return time.Now().Add(time.Hour) }
+	check(2)
+	if fake.grants[1] != GRANT_REFRESH_TOKEN {
+		t.Errorf("wanted a refresh grant got %s", fake.grants[1])
+	}
+	ts.now = time.Now
+
+	// a token rejected by the API is replaced and the request retried once
+	fake.current = "revoked"
+	check(3)
+
+	// bad credentials are an error
+	cfg.Password = "wrong"
+	client, _ = NewClient(cfg, logging.New())
+	if _, err := client.CheckConnection(ctx); err == nil {
+		t.Error("wanted an error for bad credentials")
+	}
+}
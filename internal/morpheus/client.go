@@ -3,171 +3,315 @@ package morpheus
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math/rand"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spoonboy-io/link/internal"
-	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/metrics"
 )
 
-// ApprovalsResponse stores the limited data we need to hold about the approvals at this stage
-// the remainder we will find by making further requests for each approval id
-type ApprovalsResponse struct {
-	Approvals []approval.Approval `json:"approvals"`
-}
+const (
+	// retries of failed requests back off exponentially from the base delay, up to the maximum
+	BACKOFF_BASE = 500 * time.Millisecond
+	BACKOFF_MAX  = 30 * time.Second
+)
+
+var (
+	ERR_UNAUTHORIZED = errors.New("Morpheus API request is unauthorized, check the token or credentials")
+	ERR_FORBIDDEN    = errors.New("Morpheus API request is forbidden, check the permissions of the user")
+	ERR_NOT_FOUND    = errors.New("Morpheus API resource not found")
+	ERR_BAD_CA       = errors.New("Morpheus CA bundle contains no certificates")
+)
 
-type ApprovalResponse struct {
-	Approval approval.Approval `json:"approval"`
+// APIError is an unsuccessful response from the Morpheus API, errors.Is can be used to check for
+// ERR_UNAUTHORIZED, ERR_FORBIDDEN and ERR_NOT_FOUND
+type APIError struct {
+	Method     string
+	Path       string
+	StatusCode int
 }
 
-const (
-	STATUS_REQUESTED = "1 requested"
-)
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("Bad response received from API (%d) for %s %s", e.StatusCode, e.Method, e.Path)
+	if err := e.Unwrap(); err != nil {
+		msg = fmt.Sprintf("%s (%d) for %s %s", err, e.StatusCode, e.Method, e.Path)
+	}
+	return msg
+}
 
-// CheckNewApprovals obtains a list of approvals from the Morpheus API, we use 'offset' to
-// get the new approvals since last checked
-func CheckNewApprovals(ctx context.Context, app *internal.App) ([]approval.Approval, error) {
+// Unwrap returns the sentinel error for the status code, if there is one
+func (e *APIError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ERR_UNAUTHORIZED
+	case http.StatusForbidden:
+		return ERR_FORBIDDEN
+	case http.StatusNotFound:
+		return ERR_NOT_FOUND
+	}
+	return nil
+}
 
-	// approvalsRequested contains only the approvals request since last call which
-	// we need to further inspect and match against approval policy logic
-	var approvalsRequested []approval.Approval
+// Client makes requests to the Morpheus API, it holds a single pooled transport and is safe
+// for use by multiple goroutines. A new client should be created if the configuration changes
+type Client struct {
+	// Appliance is the name of the appliance the client makes requests to
+	Appliance  string
+	Host       string
+	Token      string
+	MaxRetries int
+	// LogRequests logs every request at the info level rather than debug, retries and failures
+	// are always logged
+	LogRequests bool
+
+	tokens  *TokenSource
+	http    *http.Client
+	limiter *limiter
+	logger  *logging.Logger
+	sleep   func(ctx context.Context, d time.Duration) error
+	now     func() time.Time
+}
 
-	// form the request
-	// the api does not support an index in this call, so we have to set a high max to get everything
-	// and then process it
-	requestURI := fmt.Sprintf("%s/api/approvals?max=10000", app.Config.MorpheusHost)
-	req, err := http.NewRequest("GET", requestURI, http.NoBody)
-	if err != nil {
-		return approvalsRequested, err
+// NewClient creates a client for the Morpheus API of the appliance, with a service account
+// configured tokens are obtained with the OAuth password grant
+func NewClient(ap internal.Appliance, logger *logging.Logger) (*Client, error) {
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: !ap.TLSVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if ap.CABundle != "" {
+		pem, err := os.ReadFile(ap.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read Morpheus CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, ERR_BAD_CA
+		}
+		tlsConf.RootCAs = pool
 	}
-	req = req.WithContext(ctx)
 
-	// add the bearer token
-	bearerToken := fmt.Sprintf("BEARER %s", app.Config.MorpheusToken)
-	req.Header.Add("Authorization", bearerToken)
-
-	// going to ignore TLS errors, which we may get from a morpheus appliance running
-	// self-signed certificates
-	tConf := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-			MinVersion:         tls.VersionTLS12,
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConf
+
+	c := &Client{
+		Appliance:   ap.Name,
+		Host:        strings.TrimRight(ap.Host, "/"),
+		Token:       ap.Token,
+		MaxRetries:  ap.MaxRetries,
+		LogRequests: ap.LogRequests,
+		http: &http.Client{
+			Transport: transport,
+			Timeout:   time.Duration(ap.Timeout) * time.Second,
 		},
+		limiter: newLimiter(ap.RateLimit, ap.RateBurst),
+		logger:  logger,
+		sleep:   sleep,
+		now:     time.Now,
 	}
-	client := &http.Client{
-		Transport: tConf,
+	if ap.User != "" {
+		c.tokens = &TokenSource{
+			Host:     c.Host,
+			ClientId: ap.ClientId,
+			Username: ap.User,
+			Password: ap.Password,
+		}
 	}
 
-	// make the API call
-	res, err := client.Do(req)
+	return c, nil
+}
+
+// log returns the logger of the context, so requests are logged with the ids of the poll or
+// approval they are made for, otherwise the logger of the client
+func (c *Client) log(ctx context.Context) *logging.Logger {
+	return logging.FromContext(ctx, c.logger)
+}
+
+// Get makes a GET request to the Morpheus API and unmarshals the response into v
+func (c *Client) Get(ctx context.Context, path string, v interface{}) error {
+	res, err := c.Do(ctx, http.MethodGet, path, nil)
 	if err != nil {
-		return approvalsRequested, err
+		return err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return approvalsRequested, fmt.Errorf("Bad response received from API (%d)", res.StatusCode)
-	}
-
 	body, err := io.ReadAll(res.Body)
 	if err != nil {
-		return approvalsRequested, fmt.Errorf("Could not read response body %v", err)
+		return fmt.Errorf("Could not read response body %v", err)
 	}
 
 	// capture to struct
-	approvalsRes := ApprovalsResponse{}
-	if err := json.Unmarshal(body, &approvalsRes); err != nil {
-		return approvalsRequested, fmt.Errorf("Could not unmarshal response body %v", err)
-	}
-
-	// TODO IN-PROGRESS
-	// issue in that the approvals list, nor the approval tells us much about the scope
-	// nor which approval policy generated the approval - if we had that we could inspect the policy for the above
-	// so we will have interogate the instances or app which are subject to the approval
-	// inspect, acquire further data, (enough to match on the approval routing policies
-
-	for i := range approvalsRes.Approvals {
-		// only process if > last poll id
-		if approvalsRes.Approvals[i].Id > app.State.LastPollId {
-			app.State.LastPollId = approvalsRes.Approvals[i].Id
-
-			// only retrieve & process if in "requested" state
-			if approvalsRes.Approvals[i].Status == STATUS_REQUESTED {
-				//make a request for the approval/id endpoint
-				approval, err := GetApproval(ctx, &approvalsRes.Approvals[i], app)
-				if err != nil {
-					return approvalsRequested, err
-				}
-
-				// append to slice of data to keep for post-processing
-				approvalsRequested = append(approvalsRequested, approval)
-			}
-		}
-
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("Could not unmarshal response body %v", err)
 	}
 
-	// at this point we need to interogate the instance or app to determine scope
-	for i := range approvalsRequested {
-		//TODO
-		fmt.Printf("%+v", approvalsRequested[i])
+	return nil
+}
 
+// Do makes an authenticated request to the Morpheus API, returning the response if it is
+// successful (2xx) or an *APIError. Requests failing with 5xx or 429 are retried with
+// exponential backoff, and with a service account, a request rejected with 401 is retried
+// once with a new token. Every attempt waits its turn under the rate limit of the appliance.
+// The body is JSON encoded if not nil
+func (c *Client) Do(ctx context.Context, method, path string, body interface{}) (*http.Response, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
 	}
 
-	return approvalsRequested, nil
+	reauthenticated := false
+	for attempt := 0; ; attempt++ {
+		res, err := c.attempt(ctx, method, path, payload)
+		if err != nil {
+			return nil, err
+		}
+
+		status := res.StatusCode
+		if status >= 200 && status < 300 {
+			return res, nil
+		}
+		res.Body.Close()
+		apiErr := &APIError{Method: method, Path: path, StatusCode: status}
+
+		if status == http.StatusUnauthorized && c.tokens != nil && !reauthenticated {
+			reauthenticated = true
+			c.tokens.Invalidate()
+			continue
+		}
+
+		if (status == http.StatusTooManyRequests || status >= 500) && attempt < c.MaxRetries {
+			delay := backoff(attempt, res.Header.Get("Retry-After"))
+			c.log(ctx).Warn(fmt.Sprintf("%s, retrying in %s", apiErr, delay.Round(time.Millisecond)))
+			if err := c.sleep(ctx, delay); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		return nil, apiErr
+	}
 }
 
-// GetApproval obtains information from the Morpheus API about the approval
-// we will update the pointer so we are not returning approval as value
-func GetApproval(ctx context.Context, approval *approval.Approval, app *internal.App) (approval.Approval, error) {
+// attempt makes a single request
+func (c *Client) attempt(ctx context.Context, method, path string, payload []byte) (*http.Response, error) {
+	if err := c.wait(ctx, method, path); err != nil {
+		return nil, err
+	}
 
-	approvalRes := ApprovalResponse{}
+	var body io.Reader = http.NoBody
+	if payload != nil {
+		body = strings.NewReader(string(payload))
+	}
 
-	// form the request
-	requestURI := fmt.Sprintf("%s/api/approvals/%d", app.Config.MorpheusHost, approval.Id)
-	req, err := http.NewRequest("GET", requestURI, http.NoBody)
+	req, err := http.NewRequestWithContext(ctx, method, c.Host+path, body)
 	if err != nil {
-		return approvalRes.Approval, err
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
 	}
-	req = req.WithContext(ctx)
 
 	// add the bearer token
-	bearerToken := fmt.Sprintf("BEARER %s", app.Config.MorpheusToken)
-	req.Header.Add("Authorization", bearerToken)
-
-	// going to ignore TLS errors, which we may get from a morpheus appliance running
-	// self-signed certificates
-	tConf := &http.Transport{
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: true,
-			MinVersion:         tls.VersionTLS12,
-		},
-	}
-	client := &http.Client{
-		Transport: tConf,
+	token := c.Token
+	if c.tokens != nil {
+		if token, err = c.tokens.Token(ctx, c.http); err != nil {
+			return nil, err
+		}
 	}
+	req.Header.Set("Authorization", fmt.Sprintf("BEARER %s", token))
 
-	// make the API call
-	res, err := client.Do(req)
+	start := time.Now()
+	res, err := c.http.Do(req)
+	ep := endpoint(path)
+	metrics.APILatency.ObserveDuration(time.Since(start), c.Appliance, method, ep)
 	if err != nil {
-		return approvalRes.Approval, err
+		metrics.APIErrors.Inc(c.Appliance, method, ep)
+		c.log(ctx).Error(fmt.Sprintf("Morpheus API request %s %s failed", method, path), err)
+		return nil, err
 	}
-	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		metrics.APIErrors.Inc(c.Appliance, method, ep)
+	}
+	msg := fmt.Sprintf("Morpheus API %s %s %d (%s)", method, path, res.StatusCode, time.Since(start).Round(time.Millisecond))
+	if c.LogRequests {
+		c.log(ctx).Info(msg)
+	} else {
+		c.log(ctx).Debug(msg)
+	}
+	return res, nil
+}
 
-	if res.StatusCode != http.StatusOK {
-		return approvalRes.Approval, fmt.Errorf("Bad response received from API (%d)", res.StatusCode)
+// wait waits until the rate limit allows a request to be made
+func (c *Client) wait(ctx context.Context, method, path string) error {
+	if c.limiter == nil {
+		return nil
+	}
+	d := c.limiter.reserve(c.now())
+	if d <= 0 {
+		return nil
 	}
+	c.log(ctx).Debug(fmt.Sprintf("Morpheus API %s %s rate limited, waiting %s", method, path, d.Round(time.Millisecond)))
+	if err := c.sleep(ctx, d); err != nil {
+		c.limiter.cancel()
+		return err
+	}
+	return nil
+}
 
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		return approvalRes.Approval, err
+// endpoint returns the path without its query, with ids replaced by ':id' so requests for
+// different approvals are measured together
+func endpoint(path string) string {
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if _, err := strconv.Atoi(part); err == nil {
+			parts[i] = ":id"
+		}
 	}
+	return strings.Join(parts, "/")
+}
 
-	// capture to struct
-	if err := json.Unmarshal(body, &approvalRes); err != nil {
-		return approvalRes.Approval, err
+// backoff returns the delay before retrying, the Retry-After header (seconds) is used if the
+// API sent one, otherwise the delay doubles each attempt with jitter
+func backoff(attempt int, retryAfter string) time.Duration {
+	if secs, err := strconv.Atoi(retryAfter); err == nil && secs >= 0 {
+		if d := time.Duration(secs) * time.Second; d < BACKOFF_MAX {
+			return d
+		}
+		return BACKOFF_MAX
 	}
 
-	return approvalRes.Approval, nil
+	d := BACKOFF_BASE << uint(attempt)
+	if d <= 0 || d > BACKOFF_MAX {
+		d = BACKOFF_MAX
+	}
+	// jitter in the upper half so retries from several clients spread out
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// sleep waits for the duration, or until the context is done
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
 }
@@ -0,0 +1,214 @@
+package morpheus
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/logging"
+)
+
+func TestClient_Get(t *testing.T) {
+	calls := map[string]int{}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls[r.URL.Path]++
+		if r.Header.Get("Authorization") != "BEARER test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/flaky":
+			// fails twice before succeeding
+			if calls[r.URL.Path] <= 2 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte(`{"user":{"username":"link"}}`))
+		case "/api/limited":
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/api/forbidden":
+			w.WriteHeader(http.StatusForbidden)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	cfg := internal.Appliance{
+		Host:       srv.URL,
+		Token:      "test-token",
+		Timeout:    5,
+		MaxRetries: 2,
+	}
+	client, err := NewClient(cfg, logging.New())
+	if err != nil {
+		t.Fatalf("could not create client %v", err)
+	}
+	var delays []time.Duration
+	client.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	ctx := context.Background()
+
+	// retried until it succeeds
+	whoAmI := WhoAmIResponse{}
+	if err := client.Get(ctx, "/api/flaky", &whoAmI); err != nil || whoAmI.User.Username != "link" {
+		t.Fatalf("wanted 'link' got '%s' %v", whoAmI.User.Username, err)
+	}
+	if calls["/api/flaky"] != 3 || len(delays) != 2 {
+		t.Errorf("wanted 3 calls and 2 delays got %d and %v", calls["/api/flaky"], delays)
+	}
+
+	// retries are limited and honour Retry-After
+	delays = nil
+	err = client.Get(ctx, "/api/limited", &whoAmI)
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("wanted a 429 APIError got %v", err)
+	}
+	if calls["/api/limited"] != 3 || len(delays) != 2 || delays[0] != time.Second {
+		t.Errorf("wanted 3 calls with 1s delays got %d and %v", calls["/api/limited"], delays)
+	}
+
+	// typed errors, not retried
+	if err := client.Get(ctx, "/api/forbidden", &whoAmI); !errors.Is(err, ERR_FORBIDDEN) {
+		t.Errorf("wanted %v got %v", ERR_FORBIDDEN, err)
+	}
+	if err := client.Get(ctx, "/api/missing", &whoAmI); !errors.Is(err, ERR_NOT_FOUND) {
+		t.Errorf("wanted %v got %v", ERR_NOT_FOUND, err)
+	}
+	client.Token = "bad-token"
+	if err := client.Get(ctx, "/api/flaky", &whoAmI); !errors.Is(err, ERR_UNAUTHORIZED) {
+		t.Errorf("wanted %v got %v", ERR_UNAUTHORIZED, err)
+	}
+
+	// certificates are verified against the CA bundle when configured
+	caFile := "test_ca.pem"
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0600); err != nil {
+		t.Fatalf("could not write CA bundle %v", err)
+	}
+	defer os.Remove(caFile)
+
+	cfg.TLSVerify = true
+	verifying, _ := NewClient(cfg, logging.New())
+	if err := verifying.Get(ctx, "/api/flaky", &whoAmI); err == nil {
+		t.Error("wanted a certificate error without the CA bundle")
+	}
+
+	cfg.CABundle = caFile
+	verifying, err = NewClient(cfg, logging.New())
+	if err != nil {
+		t.Fatalf("could not create client %v", err)
+	}
+	if err := verifying.Get(ctx, "/api/flaky", &whoAmI); err != nil {
+		t.Errorf("wanted no error with the CA bundle got %v", err)
+	}
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	calls := 0
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		_, _ = w.Write([]byte(`{"user":{"username":"link"}}`))
+	}))
+	defer srv.Close()
+
+	cfg := internal.Appliance{Host: srv.URL, Token: "test-token", Timeout: 5, RateLimit: 2, RateBurst: 2}
+	client, err := NewClient(cfg, logging.New())
+	if err != nil {
+		t.Fatalf("could not create client %v", err)
+	}
+	now := time.Date(2022, 3, 1, 10, 0, 0, 0, time.UTC)
+	client.now = func() time.Time { return now }
+	var delays []time.Duration
+	client.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+
+	ctx := context.Background()
+	whoAmI := WhoAmIResponse{}
+	get := func() {
+		if err := client.Get(ctx, "/api/whoami", &whoAmI); err != nil {
+			t.Fatalf("wanted no error got %v", err)
+		}
+	}
+
+	// the burst is not delayed, further requests wait for the bucket to refill in turn
+	for i := 0; i < 4; i++ {
+		get()
+	}
+	want := []time.Duration{500 * time.Millisecond, time.Second}
+	if calls != 4 || !reflect.DeepEqual(delays, want) {
+		t.Errorf("wanted 4 calls with delays %v got %d with %v", want, calls, delays)
+	}
+
+	// refilled to the burst after waiting
+	delays = nil
+	now = now.Add(10 * time.Second)
+	get()
+	get()
+	if len(delays) != 0 {
+		t.Errorf("wanted no delays after the bucket refilled got %v", delays)
+	}
+
+	// a request cancelled while waiting is not made and returns its token
+	client.sleep = func(ctx context.Context, _ time.Duration) error {
+		return context.Canceled
+	}
+	if err := client.Get(ctx, "/api/whoami", &whoAmI); !errors.Is(err, context.Canceled) || calls != 6 {
+		t.Errorf("wanted the request cancelled without a call got %v after %d calls", err, calls)
+	}
+	if d := client.limiter.reserve(now); d != 500*time.Millisecond {
+		t.Errorf("wanted the cancelled token returned got a wait of %s", d)
+	}
+
+	// not limited without a rate
+	cfg.RateLimit = 0
+	unlimited, _ := NewClient(cfg, logging.New())
+	if unlimited.limiter != nil {
+		t.Error("wanted no limiter without a rate")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		d := backoff(attempt, "")
+		want := BACKOFF_BASE << uint(attempt)
+		if want > BACKOFF_MAX {
+			want = BACKOFF_MAX
+		}
+		if d < want/2 || d > want {
+			t.Errorf("attempt %d: wanted between %s and %s got %s", attempt, want/2, want, d)
+		}
+	}
+	if d := backoff(0, "120"); d != BACKOFF_MAX {
+		t.Errorf("wanted Retry-After capped at %s got %s", BACKOFF_MAX, d)
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	testCases := map[string]string{
+		"/api/approvals?max=25&offset=0":  "/api/approvals",
+		"/api/approvals/12":               "/api/approvals/:id",
+		"/api/approval-items/101/approve": "/api/approval-items/:id/approve",
+		"/api/whoami":                     "/api/whoami",
+		"/api/instances/7?details=true":   "/api/instances/:id",
+	}
+	for path, want := range testCases {
+		if got := endpoint(path); got != want {
+			t.Errorf("%s: wanted %s got %s", path, want, got)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package morpheus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spoonboy-io/link/internal/approval"
+)
+
+const (
+	REFERENCE_INSTANCE = approval.REFERENCE_INSTANCE
+	REFERENCE_APP      = approval.REFERENCE_APP
+
+	// HOURS_PER_MONTH is used to convert prices to an estimated monthly cost
+	HOURS_PER_MONTH = 730
+
+	BYTES_PER_GB = 1024 * 1024 * 1024
+)
+
+// hoursPerUnit converts the price unit reported by Morpheus to hours
+var hoursPerUnit = map[string]float64{
+	"minute": 1.0 / 60,
+	"hour":   1,
+	"day":    24,
+	"week":   168,
+	"month":  HOURS_PER_MONTH,
+	"year":   HOURS_PER_MONTH * 12,
+}
+
+// Price is the price of an instance as calculated by Morpheus from its plan and price sets
+type Price struct {
+	Price    float64 `json:"price"`
+	Cost     float64 `json:"cost"`
+	Currency string  `json:"currency"`
+	Unit     string  `json:"unit"`
+}
+
+// Tag is a name/value metadata tag on an instance
+type Tag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Instance holds the data we need about an instance which is subject to approval
+type Instance struct {
+	Id           int          `json:"id"`
+	Name         string       `json:"name"`
+	Group        approval.Ref `json:"group"`
+	Cloud        approval.Ref `json:"cloud"`
+	InstanceType approval.Ref `json:"instanceType"`
+	Plan         approval.Ref `json:"plan"`
+	Layout       approval.Ref `json:"layout"`
+	MaxCores     int          `json:"maxCores"`
+	MaxMemory    int64        `json:"maxMemory"`
+	Labels       []string     `json:"labels"`
+	Tags         []Tag        `json:"tags"`
+	Config       struct {
+		CustomOptions map[string]interface{} `json:"customOptions"`
+	} `json:"config"`
+	CreatedBy struct {
+		Username string `json:"username"`
+	} `json:"createdBy"`
+	InstancePrice *Price `json:"instancePrice"`
+}
+
+type InstanceResponse struct {
+	Instance Instance `json:"instance"`
+}
+
+// App holds the data we need about an app which is subject to approval
+type App struct {
+	Id        int            `json:"id"`
+	Name      string         `json:"name"`
+	Group     approval.Ref   `json:"group"`
+	Instances []approval.Ref `json:"instances"`
+}
+
+type AppResponse struct {
+	App App `json:"app"`
+}
+
+// MonthlyCost returns the estimated monthly cost of the price, false is returned
+// if the price unit is not known
+func (p Price) MonthlyCost() (float64, bool) {
+	hours, ok := hoursPerUnit[strings.ToLower(p.Unit)]
+	if !ok {
+		return 0, false
+	}
+	return p.Price * HOURS_PER_MONTH / hours, true
+}
+
+// GetInstance obtains information from the Morpheus API about an instance
+func (c *Client) GetInstance(ctx context.Context, id int) (Instance, error) {
+	instanceRes := InstanceResponse{}
+	if err := c.Get(ctx, fmt.Sprintf("/api/instances/%d", id), &instanceRes); err != nil {
+		return instanceRes.Instance, err
+	}
+	return instanceRes.Instance, nil
+}
+
+// GetApp obtains information from the Morpheus API about an app
+func (c *Client) GetApp(ctx context.Context, id int) (App, error) {
+	appRes := AppResponse{}
+	if err := c.Get(ctx, fmt.Sprintf("/api/apps/%d", id), &appRes); err != nil {
+		return appRes.App, err
+	}
+	return appRes.App, nil
+}
+
+// GetDetails looks up the instances and apps referenced by the approval items and adds
+// what we learn about them (scope, estimated cost) to the approval, the monthly cost is
+// the total of all the instances subject to the approval
+func (c *Client) GetDetails(ctx context.Context, a *approval.Approval) error {
+	var instances []Instance
+
+	for _, item := range a.Items {
+		switch item.Reference.Type {
+		case REFERENCE_INSTANCE:
+			instance, err := c.GetInstance(ctx, item.Reference.Id)
+			if err != nil {
+				return err
+			}
+			instances = append(instances, instance)
+
+		case REFERENCE_APP:
+			mApp, err := c.GetApp(ctx, item.Reference.Id)
+			if err != nil {
+				return err
+			}
+			if a.Scope.Group == "" {
+				a.Scope.Group = mApp.Group.Name
+			}
+			for _, ref := range mApp.Instances {
+				instance, err := c.GetInstance(ctx, ref.Id)
+				if err != nil {
+					return err
+				}
+				instances = append(instances, instance)
+			}
+		}
+	}
+
+	AddInstanceDetails(a, instances)
+	return nil
+}
+
+// AddInstanceDetails sets the approval scope from the first instance, and totals the
+// monthly cost of all instances, the cost is only known if every instance is priced
+// in the same currency
+func AddInstanceDetails(a *approval.Approval, instances []Instance) {
+	if len(instances) == 0 {
+		return
+	}
+
+	first := instances[0]
+	if a.Scope.Group == "" {
+		a.Scope.Group = first.Group.Name
+	}
+	if a.Scope.Cloud == "" {
+		a.Scope.Cloud = first.Cloud.Name
+	}
+	if a.Scope.User == "" {
+		a.Scope.User = first.CreatedBy.Username
+	}
+
+	for _, instance := range instances {
+		a.Details.Instances = append(a.Details.Instances, instanceDetails(instance))
+	}
+
+	var total float64
+	var currency string
+	for _, instance := range instances {
+		if instance.InstancePrice == nil {
+			return
+		}
+		monthly, ok := instance.InstancePrice.MonthlyCost()
+		if !ok {
+			return
+		}
+		if currency == "" {
+			currency = instance.InstancePrice.Currency
+		} else if !strings.EqualFold(currency, instance.InstancePrice.Currency) {
+			return
+		}
+		total += monthly
+	}
+
+	a.Details.Priced = true
+	a.Details.MonthlyCost = total
+	a.Details.Currency = currency
+}
+
+// instanceDetails converts the instance to the details we match approval scope against
+func instanceDetails(instance Instance) approval.InstanceDetails {
+	details := approval.InstanceDetails{
+		Name:          instance.Name,
+		InstanceType:  instance.InstanceType.Name,
+		Plan:          instance.Plan.Name,
+		Layout:        instance.Layout.Name,
+		Cores:         instance.MaxCores,
+		Memory:        float64(instance.MaxMemory) / BYTES_PER_GB,
+		Labels:        instance.Labels,
+		Tags:          map[string]string{},
+		CustomOptions: map[string][]string{},
+	}
+
+	for _, tag := range instance.Tags {
+		details.Tags[tag.Name] = tag.Value
+	}
+
+	for name, value := range instance.Config.CustomOptions {
+		details.CustomOptions[name] = optionValues(value)
+	}
+
+	return details
+}
+
+// optionValues flattens a custom option value, which may be a scalar, a list (multi-select)
+// or an object, to strings
+func optionValues(value interface{}) []string {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{v}
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}
+	case []interface{}:
+		var values []string
+		for _, item := range v {
+			values = append(values, optionValues(item)...)
+		}
+		return values
+	case map[string]interface{}:
+		// typeahead and select options may be objects, use the value if there is one
+		for _, k := range []string{"value", "name"} {
+			if item, ok := v[k]; ok {
+				return optionValues(item)
+			}
+		}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		var values []string
+		for _, k := range keys {
+			values = append(values, optionValues(v[k])...)
+		}
+		return values
+	default:
+		return []string{fmt.Sprint(v)}
+	}
+}
@@ -0,0 +1,110 @@
+package morpheus
+
+import (
+	"math"
+	"testing"
+
+	"github.com/spoonboy-io/link/internal/approval"
+)
+
+func TestPrice_MonthlyCost(t *testing.T) {
+	testCases := []struct {
+		name   string
+		price  Price
+		want   float64
+		wantOk bool
+	}{
+		{"hourly", Price{Price: 0.2, Unit: "hour"}, 146, true},
+		{"daily", Price{Price: 2.4, Unit: "day"}, 73, true},
+		{"weekly", Price{Price: 16.8, Unit: "week"}, 73, true},
+		{"monthly", Price{Price: 50, Unit: "month"}, 50, true},
+		{"yearly", Price{Price: 1200, Unit: "year"}, 100, true},
+		{"per minute", Price{Price: 0.01, Unit: "minute"}, 438, true},
+		{"unit is not case sensitive", Price{Price: 0.2, Unit: "Hour"}, 146, true},
+		{"unknown unit", Price{Price: 10, Unit: "fortnight"}, 0, false},
+		{"no unit", Price{Price: 10}, 0, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := tc.price.MonthlyCost()
+			if ok != tc.wantOk || math.Abs(got-tc.want) > 1e-9 {
+				t.Errorf("wanted %v %v got %v %v", tc.want, tc.wantOk, got, ok)
+			}
+		})
+	}
+}
+
+func TestAddInstanceDetails(t *testing.T) {
+	instance := func(name string, price *Price) Instance {
+		i := Instance{Name: name, Group: approval.Ref{Name: "Dev"}, Cloud: approval.Ref{Name: "AWS"}, InstancePrice: price}
+		i.CreatedBy.Username = "jbloggs"
+		return i
+	}
+
+	testCases := []struct {
+		name         string
+		instances    []Instance
+		wantPriced   bool
+		wantCost     float64
+		wantCurrency string
+	}{
+		{
+			name:      "no instances",
+			instances: nil,
+		},
+		{
+			name:         "single instance",
+			instances:    []Instance{instance("web", &Price{Price: 0.2, Unit: "hour", Currency: "USD"})},
+			wantPriced:   true,
+			wantCost:     146,
+			wantCurrency: "USD",
+		},
+		{
+			name: "units are converted before totalling",
+			instances: []Instance{
+				instance("web", &Price{Price: 0.2, Unit: "hour", Currency: "USD"}),
+				instance("db", &Price{Price: 1200, Unit: "year", Currency: "usd"}),
+			},
+			wantPriced:   true,
+			wantCost:     246,
+			wantCurrency: "USD",
+		},
+		{
+			name: "mixed currencies",
+			instances: []Instance{
+				instance("web", &Price{Price: 0.2, Unit: "hour", Currency: "USD"}),
+				instance("db", &Price{Price: 100, Unit: "month", Currency: "EUR"}),
+			},
+		},
+		{
+			name: "an instance without a price",
+			instances: []Instance{
+				instance("web", &Price{Price: 0.2, Unit: "hour", Currency: "USD"}),
+				instance("db", nil),
+			},
+		},
+		{
+			name:      "an unknown price unit",
+			instances: []Instance{instance("web", &Price{Price: 10, Unit: "fortnight", Currency: "USD"})},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := approval.Approval{}
+			AddInstanceDetails(&a, tc.instances)
+
+			d := a.Details
+			if d.Priced != tc.wantPriced || math.Abs(d.MonthlyCost-tc.wantCost) > 1e-9 || d.Currency != tc.wantCurrency {
+				t.Errorf("wanted priced %v cost %v %s got %+v", tc.wantPriced, tc.wantCost, tc.wantCurrency, d)
+			}
+			if len(d.Instances) != len(tc.instances) {
+				t.Errorf("wanted details of %d instances got %d", len(tc.instances), len(d.Instances))
+			}
+			if len(tc.instances) > 0 && (a.Scope.Group != "Dev" || a.Scope.Cloud != "AWS" || a.Scope.User != "jbloggs") {
+				t.Errorf("wanted the scope of the first instance got %+v", a.Scope)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package morpheus
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter is a token bucket limiting the rate of requests made to an appliance, it holds up
+// to burst tokens and is refilled at rate tokens a second. It is safe for use by multiple
+// goroutines
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns a full bucket, or nil if the rate is not limited
+func newLimiter(rate float64, burst int) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &limiter{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+// reserve takes a token, returning how long to wait before making the request. Tokens not yet
+// refilled are reserved, so concurrent requests wait in turn rather than all at once
+func (l *limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.last.IsZero() && now.After(l.last) {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+	}
+	if now.After(l.last) {
+		l.last = now
+	}
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// cancel returns a token reserved for a request which was not made
+func (l *limiter) cancel() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.tokens++; l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
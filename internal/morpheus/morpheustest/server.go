@@ -0,0 +1,306 @@
+// Package morpheustest provides a fake Morpheus API for tests. Approvals, instances and apps are
+// added as fixtures, approval items are approved, denied or cancelled through the API as they
+// would be in Morpheus, and failures can be scripted for any path
+package morpheustest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/morpheus"
+)
+
+// TOKEN is the bearer token the server accepts unless another is set
+const TOKEN = "morpheustest-token"
+
+// item statuses once an action has been taken
+var actionStatus = map[string]approval.ItemStatus{
+	morpheus.ITEM_APPROVE: approval.ITEM_APPROVED,
+	morpheus.ITEM_DENY:    approval.ITEM_DENIED,
+	morpheus.ITEM_CANCEL:  approval.ITEM_CANCELLED,
+}
+
+// Action is an approval item action received by the server
+type Action struct {
+	ItemId int
+	Action string
+}
+
+// Server is a fake Morpheus API serving /api/whoami, /api/approvals, /api/approvals/:id,
+// /api/instances/:id, /api/apps/:id and /api/approval-items/:id/:action. It is safe for use
+// by multiple goroutines
+type Server struct {
+	*httptest.Server
+
+	// Token is the bearer token requests must have
+	Token string
+
+	mu        sync.Mutex
+	approvals map[int]*approval.Approval
+	instances map[int]morpheus.Instance
+	apps      map[int]morpheus.App
+	failures  map[string][]int
+	actions   []Action
+	requests  map[string]int
+	after     map[string]func()
+}
+
+// NewServer starts a fake Morpheus API with no fixtures, it should be closed when done
+func NewServer() *Server {
+	s := &Server{
+		Token:     TOKEN,
+		approvals: map[int]*approval.Approval{},
+		instances: map[int]morpheus.Instance{},
+		apps:      map[int]morpheus.App{},
+		failures:  map[string][]int{},
+		requests:  map[string]int{},
+		after:     map[string]func(){},
+	}
+	s.Server = httptest.NewServer(s)
+	return s
+}
+
+// Appliance returns an appliance configuration for a client of the server
+func (s *Server) Appliance(name string) internal.Appliance {
+	return internal.Appliance{
+		Name:     name,
+		Host:     s.URL,
+		Token:    s.Token,
+		ClientId: internal.MORPHEUS_CLIENT_ID,
+		Timeout:  5,
+	}
+}
+
+// AddApproval adds or replaces an approval, items without a status are requested and the
+// status of the approval is counted from its items
+func (s *Server) AddApproval(a approval.Approval) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range a.Items {
+		if a.Items[i].Status == "" {
+			a.Items[i].Status = approval.ITEM_REQUESTED
+		}
+	}
+	a.Status = status(a.Items)
+	s.approvals[a.Id] = &a
+}
+
+// RemoveApproval deletes an approval, as when what it was for is deleted
+func (s *Server) RemoveApproval(id int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.approvals, id)
+}
+
+// AddInstance adds or replaces an instance
+func (s *Server) AddInstance(instance morpheus.Instance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.instances[instance.Id] = instance
+}
+
+// AddApp adds or replaces an app
+func (s *Server) AddApp(app morpheus.App) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.apps[app.Id] = app
+}
+
+// Fail makes the next requests to the path fail with the statuses given, in order
+func (s *Server) Fail(path string, statuses ...int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[path] = append(s.failures[path], statuses...)
+}
+
+// Approval returns the approval as it is now
+func (s *Server) Approval(id int) (approval.Approval, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	a, ok := s.approvals[id]
+	if !ok {
+		return approval.Approval{}, false
+	}
+	return *a, true
+}
+
+// Actions returns the approval item actions received, in order
+func (s *Server) Actions() []Action {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Action(nil), s.actions...)
+}
+
+// Requests returns the number of requests made to the path
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// After calls f once each request to the path has been handled, before the response is sent,
+// as when Morpheus is slow to respond to a request it has acted on
+func (s *Server) After(path string, f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.after[path] = f
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.serve(w, r)
+
+	s.mu.Lock()
+	f := s.after[r.URL.Path]
+	s.mu.Unlock()
+	if f != nil {
+		f()
+	}
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	path := r.URL.Path
+	s.requests[path]++
+
+	if r.Header.Get("Authorization") != "BEARER "+s.Token {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if statuses := s.failures[path]; len(statuses) > 0 {
+		s.failures[path] = statuses[1:]
+		w.WriteHeader(statuses[0])
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case r.Method == http.MethodGet && path == "/api/whoami":
+		s.write(w, map[string]interface{}{"user": map[string]interface{}{"id": 1, "username": "morpheustest"}})
+	case r.Method == http.MethodGet && path == "/api/approvals":
+		s.listApprovals(w, r)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "approvals":
+		if a, ok := s.approvals[atoi(parts[2])]; ok {
+			s.write(w, morpheus.ApprovalResponse{Approval: *a})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "instances":
+		if instance, ok := s.instances[atoi(parts[2])]; ok {
+			s.write(w, morpheus.InstanceResponse{Instance: instance})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodGet && len(parts) == 3 && parts[1] == "apps":
+		if app, ok := s.apps[atoi(parts[2])]; ok {
+			s.write(w, morpheus.AppResponse{App: app})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	case r.Method == http.MethodPut && len(parts) == 4 && parts[1] == "approval-items":
+		s.actOnItem(w, atoi(parts[2]), parts[3])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// listApprovals serves a page of the approvals, ordered by id as requested with max and offset
+func (s *Server) listApprovals(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	max, err := strconv.Atoi(query.Get("max"))
+	if err != nil || max <= 0 {
+		max = 25
+	}
+	offset, _ := strconv.Atoi(query.Get("offset"))
+
+	ids := make([]int, 0, len(s.approvals))
+	for id := range s.approvals {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	if strings.EqualFold(query.Get("direction"), "desc") {
+		sort.Sort(sort.Reverse(sort.IntSlice(ids)))
+	}
+
+	res := morpheus.ApprovalsResponse{Approvals: []approval.Approval{}}
+	for i := offset; i >= 0 && i < len(ids) && i < offset+max; i++ {
+		res.Approvals = append(res.Approvals, *s.approvals[ids[i]])
+	}
+	s.write(w, res)
+}
+
+// actOnItem applies the action to the item if it is still requested, updating the status
+// of its approval
+func (s *Server) actOnItem(w http.ResponseWriter, itemId int, action string) {
+	newStatus, ok := actionStatus[action]
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	for _, a := range s.approvals {
+		for i := range a.Items {
+			if a.Items[i].Id != itemId {
+				continue
+			}
+			if !a.Items[i].Status.CanTransition(newStatus) {
+				w.WriteHeader(http.StatusBadRequest)
+				s.write(w, map[string]interface{}{"success": false, "msg": "Approval item is not requested"})
+				return
+			}
+			a.Items[i].Status = newStatus
+			a.Items[i].LastUpdated = time.Now().UTC()
+			switch newStatus {
+			case approval.ITEM_APPROVED:
+				a.Items[i].ApprovedBy, a.Items[i].DateApproved = "morpheustest", a.Items[i].LastUpdated
+			case approval.ITEM_DENIED:
+				a.Items[i].DeniedBy, a.Items[i].DateDenied = "morpheustest", a.Items[i].LastUpdated
+			}
+			a.Status = status(a.Items)
+			s.actions = append(s.actions, Action{ItemId: itemId, Action: action})
+			s.write(w, map[string]interface{}{"success": true})
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+}
+
+// write encodes the response as JSON
+func (s *Server) write(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// status counts the items in each status as Morpheus does, e.g. '1 approved, 1 requested'
+func status(items []approval.Item) string {
+	counts := map[string]int{}
+	for _, item := range items {
+		counts[string(item.Status)]++
+	}
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, fmt.Sprintf("%d %s", counts[name], name))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
@@ -0,0 +1,164 @@
+// Package replay evaluates historical approvals against a candidate approvals config and
+// reports how their routing would differ from the config in use
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spoonboy-io/link/internal/approval"
+)
+
+const (
+	CHANGE_NONE      = "unchanged"
+	CHANGE_ROUTED    = "changed"
+	CHANGE_NEW_MATCH = "now matched"
+	CHANGE_NO_MATCH  = "no longer matched"
+)
+
+// Routing is how an approval is routed by a config
+type Routing struct {
+	Descriptions []string `json:"descriptions"`
+	Recipients   []string `json:"recipients"`
+}
+
+// Result compares the routing of one approval by the current and candidate configs
+type Result struct {
+	ApprovalId   int     `json:"approvalId"`
+	ApprovalName string  `json:"approvalName"`
+	Action       string  `json:"action"`
+	RequestBy    string  `json:"requestBy"`
+	Current      Routing `json:"current"`
+	Candidate    Routing `json:"candidate"`
+	Change       string  `json:"change"`
+}
+
+// Report is the result of replaying approvals, with the number of approvals for each change
+type Report struct {
+	Results []Result       `json:"results"`
+	Summary map[string]int `json:"summary"`
+}
+
+// Evaluate routes each approval with the current and candidate configs and compares them
+func Evaluate(approvals []approval.Approval, current, candidate approval.ApprovalsConfig, mode string) Report {
+	report := Report{
+		Summary: map[string]int{CHANGE_NONE: 0, CHANGE_ROUTED: 0, CHANGE_NEW_MATCH: 0, CHANGE_NO_MATCH: 0},
+	}
+
+	for _, a := range approvals {
+		res := Result{
+			ApprovalId:   a.Id,
+			ApprovalName: a.Name,
+			Action:       a.Action(),
+			RequestBy:    a.RequestBy,
+			Current:      routing(current.Route(a, mode)),
+			Candidate:    routing(candidate.Route(a, mode)),
+		}
+
+		switch {
+		case len(res.Current.Descriptions) == 0 && len(res.Candidate.Descriptions) > 0:
+			res.Change = CHANGE_NEW_MATCH
+		case len(res.Current.Descriptions) > 0 && len(res.Candidate.Descriptions) == 0:
+			res.Change = CHANGE_NO_MATCH
+		case same(res.Current.Descriptions, res.Candidate.Descriptions) && same(res.Current.Recipients, res.Candidate.Recipients):
+			res.Change = CHANGE_NONE
+		default:
+			res.Change = CHANGE_ROUTED
+		}
+
+		report.Summary[res.Change]++
+		report.Results = append(report.Results, res)
+	}
+
+	return report
+}
+
+// routing flattens the routes of an approval to the configs matched and the recipients notified
+func routing(routes []approval.Route) Routing {
+	r := Routing{Descriptions: []string{}, Recipients: []string{}}
+	seen := map[string]bool{}
+	for _, route := range routes {
+		r.Descriptions = append(r.Descriptions, route.Descriptions()...)
+		for _, recipient := range route.Recipients {
+			if key := strings.ToLower(recipient); !seen[key] {
+				seen[key] = true
+				r.Recipients = append(r.Recipients, recipient)
+			}
+		}
+	}
+	return r
+}
+
+// same compares two lists ignoring order and case
+func same(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	x := make([]string, len(a))
+	y := make([]string, len(b))
+	for i := range a {
+		x[i], y[i] = strings.ToLower(a[i]), strings.ToLower(b[i])
+	}
+	sort.Strings(x)
+	sort.Strings(y)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Changed returns the results where the routing differs
+func (r Report) Changed() []Result {
+	var changed []Result
+	for _, res := range r.Results {
+		if res.Change != CHANGE_NONE {
+			changed = append(changed, res)
+		}
+	}
+	return changed
+}
+
+// WriteJSON writes the report as indented JSON
+func (r Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteText writes a summary and a table of the approvals whose routing differs, or of
+// every approval if all is set
+func (r Report) WriteText(w io.Writer, all bool) error {
+	if _, err := fmt.Fprintf(w, "Replayed %d approvals: %d unchanged, %d changed, %d now matched, %d no longer matched\n\n",
+		len(r.Results), r.Summary[CHANGE_NONE], r.Summary[CHANGE_ROUTED], r.Summary[CHANGE_NEW_MATCH], r.Summary[CHANGE_NO_MATCH]); err != nil {
+		return err
+	}
+
+	results := r.Results
+	if !all {
+		results = r.Changed()
+	}
+	if len(results) == 0 {
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tAPPROVAL\tACTION\tCHANGE\tCURRENT\tCANDIDATE")
+	for _, res := range results {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\n", res.ApprovalId, res.ApprovalName, res.Action, res.Change,
+			describe(res.Current), describe(res.Candidate))
+	}
+	return tw.Flush()
+}
+
+func describe(r Routing) string {
+	if len(r.Descriptions) == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%s -> %s", strings.Join(r.Descriptions, "; "), strings.Join(r.Recipients, ", "))
+}
@@ -0,0 +1,71 @@
+package replay
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+)
+
+func testConfig(t *testing.T, configs ...approval.ApprovalConfig) approval.ApprovalsConfig {
+	var c approval.ApprovalsConfig
+	for _, ac := range configs {
+		c = append(c, struct {
+			approval.ApprovalConfig `yaml:"approval"`
+		}{ac})
+	}
+	if err := c.Validate(); err != nil {
+		t.Fatalf("could not validate test config %v", err)
+	}
+	return c
+}
+
+func TestEvaluate(t *testing.T) {
+	current := testConfig(t,
+		approval.ApprovalConfig{Description: "azure", OnProvision: true, RecipientList: []string{"azure@test.io"}, Scope: approval.Scope{Cloud: "Azure"}},
+		approval.ApprovalConfig{Description: "aws", OnProvision: true, RecipientList: []string{"aws@test.io"}, Scope: approval.Scope{Cloud: "AWS"}},
+	)
+	candidate := testConfig(t,
+		approval.ApprovalConfig{Description: "azure", OnProvision: true, RecipientList: []string{"azure@test.io"}, Scope: approval.Scope{Cloud: "Azure"}},
+		approval.ApprovalConfig{Description: "aws", OnProvision: true, RecipientList: []string{"aws@test.io", "finance@test.io"}, Scope: approval.Scope{Cloud: "AWS"}},
+		approval.ApprovalConfig{Description: "vmware", OnProvision: true, RecipientList: []string{"dc@test.io"}, Scope: approval.Scope{Match: "cloud == VMware and group != Sandbox"}},
+	)
+
+	approvals := []approval.Approval{
+		{Id: 1, Name: "APPROVAL-1", Scope: approval.Scope{Cloud: "Azure"}},
+		{Id: 2, Name: "APPROVAL-2", Scope: approval.Scope{Cloud: "AWS"}},
+		{Id: 3, Name: "APPROVAL-3", Scope: approval.Scope{Cloud: "VMware"}},
+		{Id: 4, Name: "APPROVAL-4", Scope: approval.Scope{Cloud: "VMware", Group: "Sandbox"}},
+	}
+
+	report := Evaluate(approvals, current, candidate, internal.MATCH_MODE_ALL)
+
+	var gotChanges []string
+	for _, res := range report.Results {
+		gotChanges = append(gotChanges, res.Change)
+	}
+	wantChanges := []string{CHANGE_NONE, CHANGE_ROUTED, CHANGE_NEW_MATCH, CHANGE_NONE}
+	if !reflect.DeepEqual(gotChanges, wantChanges) {
+		t.Errorf("wanted %v got %v", wantChanges, gotChanges)
+	}
+
+	wantSummary := map[string]int{CHANGE_NONE: 2, CHANGE_ROUTED: 1, CHANGE_NEW_MATCH: 1, CHANGE_NO_MATCH: 0}
+	if !reflect.DeepEqual(report.Summary, wantSummary) {
+		t.Errorf("wanted %v got %v", wantSummary, report.Summary)
+	}
+
+	var buf bytes.Buffer
+	if err := report.WriteText(&buf, false); err != nil {
+		t.Fatalf("could not write report %v", err)
+	}
+	out := buf.String()
+	if !strings.HasPrefix(out, "Replayed 4 approvals: 2 unchanged, 1 changed, 1 now matched, 0 no longer matched") {
+		t.Errorf("unexpected summary\n%s", out)
+	}
+	if !strings.Contains(out, "aws -> aws@test.io, finance@test.io") || strings.Contains(out, "APPROVAL-1") {
+		t.Errorf("unexpected report\n%s", out)
+	}
+}
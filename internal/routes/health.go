@@ -0,0 +1,34 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/spoonboy-io/link/internal/health"
+)
+
+// Healthz is the liveness probe, it responds 503 Service Unavailable if the poller has stalled
+func (r *Routes) Healthz(w http.ResponseWriter, req *http.Request) {
+	settings := r.App.Settings()
+	r.writeReport(w, req, r.Health.Live(time.Now(), time.Duration(settings.PollInterval)*time.Second))
+}
+
+// Readyz is the readiness probe, it responds 503 Service Unavailable if polling a Morpheus
+// appliance or saving its state is failing, the SMTP server cannot be reached or the server
+// certificate has expired
+func (r *Routes) Readyz(w http.ResponseWriter, req *http.Request) {
+	r.writeReport(w, req, r.Health.Ready(time.Now(), r.App.Settings()))
+}
+
+// writeReport writes the report as JSON, probes are frequent so only failures are logged
+func (r *Routes) writeReport(w http.ResponseWriter, req *http.Request, report health.Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if !report.OK() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		r.log(req).Warn(fmt.Sprintf("Served GET %s request - 503 Service Unavailable", req.URL.Path))
+	}
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,71 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/certificate"
+	"github.com/spoonboy-io/link/internal/health"
+	"github.com/spoonboy-io/link/internal/logging"
+)
+
+// probe makes the request to the handler, returning the status and report
+func probe(t *testing.T, handler http.HandlerFunc, path string) (int, health.Report) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, path, nil))
+	report := health.Report{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatalf("could not read report %v\n%s", err, w.Body.String())
+	}
+	if w.Header().Get("Content-Type") != "application/json" || w.Header().Get("Cache-Control") != "no-store" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+	return w.Code, report
+}
+
+func TestRoutes_Healthz(t *testing.T) {
+	r := testRoutes(internal.Config{PollInterval: 30})
+
+	// the poller has not started a poll since the server started long ago
+	r.Health = health.NewTracker(time.Now().Add(-time.Hour))
+	if status, report := probe(t, r.Healthz, "/healthz"); status != http.StatusServiceUnavailable || report.OK() {
+		t.Errorf("wanted a stalled poller to fail got %d %+v", status, report)
+	}
+
+	r.Health.Polled(internal.DEFAULT_APPLIANCE, nil, time.Now())
+	if status, report := probe(t, r.Healthz, "/healthz"); status != http.StatusOK || !report.OK() {
+		t.Errorf("wanted a running poller to pass got %d %+v", status, report)
+	}
+}
+
+func TestRoutes_Readyz(t *testing.T) {
+	dir := t.TempDir()
+	if err := certificate.Make(logging.NewWriter(io.Discard, logging.LEVEL_INFO, logging.FORMAT_TEXT), dir); err != nil {
+		t.Fatalf("could not make certificate %v", err)
+	}
+	r := testRoutes(internal.Config{
+		TLSFolder:  dir,
+		DryRun:     true,
+		Appliances: []internal.Appliance{{Name: internal.DEFAULT_APPLIANCE}},
+	})
+	r.Health = health.NewTracker(time.Now())
+
+	// the appliance cannot be polled
+	r.Health.Polled(internal.DEFAULT_APPLIANCE, errors.New("connection refused"), time.Now())
+	if status, report := probe(t, r.Readyz, "/readyz"); status != http.StatusServiceUnavailable || report.OK() {
+		t.Errorf("wanted a failing poll to fail got %d %+v", status, report)
+	}
+
+	r.Health.Polled(internal.DEFAULT_APPLIANCE, nil, time.Now().Add(time.Second))
+	r.Health.Saved(internal.DEFAULT_APPLIANCE, nil, time.Now())
+	if status, report := probe(t, r.Readyz, "/readyz"); status != http.StatusOK || !report.OK() {
+		t.Errorf("wanted a ready server to pass got %d %+v", status, report)
+	}
+}
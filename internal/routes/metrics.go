@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"net/http"
+
+	"github.com/spoonboy-io/link/internal/metrics"
+)
+
+// Metrics provides the metrics of the server in the Prometheus text format, for scraping
+func (r *Routes) Metrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", metrics.CONTENT_TYPE)
+	if err := metrics.Write(w); err != nil {
+		r.log(req).Error("Could not write metrics", err)
+	}
+}
@@ -1,24 +1,141 @@
 package routes
 
 import (
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/health"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/workflow"
 )
 
 // Routes makes the application context, logger and config availalble to the handlers
 type Routes struct {
 	App *internal.App
+
+	// PollNow asks the poller to check for new approvals without waiting for the poll interval
+	PollNow chan<- struct{}
+
+	// Health tracks the polls and state saves reported by the health endpoints
+	Health *health.Tracker
+
+	// Workflows records the votes of recipients following the links in their emails
+	Workflows *workflow.Engine
+}
+
+// REQUEST_ID_HEADER carries the id of a request, an id given by a proxy is used if valid
+const (
+	REQUEST_ID_HEADER = "X-Request-Id"
+	REQUEST_ID_MAX    = 64
+)
+
+// RequestId is middleware giving each request an id, which is returned in the X-Request-Id
+// header and logged with every line logged for the request
+func (r *Routes) RequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		id := req.Header.Get(REQUEST_ID_HEADER)
+		if !validRequestId(id) {
+			id = logging.NewId()
+		}
+		w.Header().Set(REQUEST_ID_HEADER, id)
+		log := r.App.Logger.With(logging.RequestId(id))
+		next.ServeHTTP(w, req.WithContext(logging.NewContext(req.Context(), log)))
+	})
+}
+
+// validRequestId reports whether an id given with a request is safe to log
+func validRequestId(id string) bool {
+	if id == "" || len(id) > REQUEST_ID_MAX {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// log returns the logger of the request, which logs its id
+func (r *Routes) log(req *http.Request) *logging.Logger {
+	return logging.FromContext(req.Context(), r.App.Logger)
 }
 
 // Ping provides an endpoint to check the server is running and responding
-func (r *Routes) Ping(w http.ResponseWriter, _ *http.Request) {
+func (r *Routes) Ping(w http.ResponseWriter, req *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Content-Type", "text/plain")
 
 	res := "Hello from Link!\n"
 
-	r.App.Logger.Info("Served GET / request - 200 OK")
+	r.log(req).Info("Served GET / request - 200 OK")
 	_, _ = fmt.Fprint(w, res)
 }
+
+// AuditExport provides the audit trail of approval workflows as CSV or JSON, filtered by
+// the 'from' and 'to' query parameters (YYYY-MM-DD). The endpoint requires the bearer
+// token configured as AUDIT_EXPORT_TOKEN and is disabled if no token is configured
+func (r *Routes) AuditExport(w http.ResponseWriter, req *http.Request) {
+	settings := r.App.Settings()
+	if !authorized(bearerToken(req), settings.AuditToken) {
+		r.log(req).Warn("Refused GET /audit/export request - 401 Unauthorized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	query := req.URL.Query()
+	format := strings.ToLower(query.Get("format"))
+	if format == "" {
+		format = audit.FORMAT_CSV
+	}
+	if format != audit.FORMAT_CSV && format != audit.FORMAT_JSON {
+		http.Error(w, audit.ERR_BAD_FORMAT.Error(), http.StatusBadRequest)
+		return
+	}
+
+	start, end, err := audit.ParseDateRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	records, err := audit.Read(settings.AuditFile)
+	if err != nil {
+		r.log(req).Error("Could not read audit trail", err)
+		http.Error(w, "Could not read audit trail", http.StatusInternalServerError)
+		return
+	}
+
+	if format == audit.FORMAT_CSV {
+		w.Header().Set("Content-Type", "text/csv")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"link-audit.%s\"", format))
+
+	if err := audit.Export(w, audit.Filter(records, start, end), format); err != nil {
+		r.log(req).Error("Could not export audit trail", err)
+		return
+	}
+
+	r.log(req).Info("Served GET /audit/export request - 200 OK")
+}
+
+// bearerToken returns the token in the Authorization header of the request, if it has one
+func bearerToken(req *http.Request) string {
+	auth := strings.TrimSpace(req.Header.Get("Authorization"))
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "bearer ") {
+		return ""
+	}
+	return auth[7:]
+}
+
+// authorized reports whether the token given is the token configured, nothing is authorized
+// if no token is configured
+func authorized(given, token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
@@ -0,0 +1,121 @@
+package routes
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/logging"
+)
+
+func TestRoutes_Ping(t *testing.T) {
+	w := httptest.NewRecorder()
+	testRoutes(internal.Config{}).Ping(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "Hello from Link!\n" {
+		t.Errorf("unexpected response %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRoutes_AuditExport(t *testing.T) {
+	trail := filepath.Join(t.TempDir(), "audit.jsonl")
+	for _, rec := range []audit.Record{
+		{ApprovalId: 1, ApprovalName: "APPROVAL-1", Created: time.Date(2026, 1, 10, 9, 0, 0, 0, time.UTC), Outcome: audit.OUTCOME_PENDING},
+		{ApprovalId: 2, ApprovalName: "APPROVAL-2", Created: time.Date(2026, 2, 10, 9, 0, 0, 0, time.UTC), Outcome: audit.OUTCOME_PENDING},
+	} {
+		if err := audit.Write(trail, rec); err != nil {
+			t.Fatalf("could not write audit record %v", err)
+		}
+	}
+
+	testCases := []struct {
+		name        string
+		token       string
+		auth        string
+		query       string
+		wantStatus  int
+		wantType    string
+		wantBody    []string
+		notWantBody string
+	}{
+		{"disabled", "", "Bearer ", "", http.StatusUnauthorized, "", nil, ""},
+		{"bad token", "secret", "Bearer guess", "", http.StatusUnauthorized, "", nil, ""},
+		{"query token refused", "secret", "", "?token=secret", http.StatusUnauthorized, "", nil, ""},
+		{"csv", "secret", "Bearer secret", "", http.StatusOK, "text/csv", []string{"approval_id,", "1,APPROVAL-1", "2,APPROVAL-2"}, ""},
+		{"json filtered", "secret", "bearer secret", "?format=JSON&from=2026-02-01&to=2026-02-28", http.StatusOK, "application/json", []string{`"approvalName": "APPROVAL-2"`}, "APPROVAL-1"},
+		{"bad format", "secret", "Bearer secret", "?format=xml", http.StatusBadRequest, "", []string{audit.ERR_BAD_FORMAT.Error()}, ""},
+		{"bad date", "secret", "Bearer secret", "?from=10/01/2026", http.StatusBadRequest, "", []string{audit.ERR_BAD_DATE.Error()}, ""},
+		{"bad range", "secret", "Bearer secret", "?from=2026-02-01&to=2026-01-01", http.StatusBadRequest, "", []string{audit.ERR_BAD_DATE_RANGE.Error()}, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := testRoutes(internal.Config{AuditToken: tc.token, AuditFile: trail})
+			req := httptest.NewRequest(http.MethodGet, "/audit/export"+tc.query, nil)
+			if tc.auth != "" {
+				req.Header.Set("Authorization", tc.auth)
+			}
+			w := httptest.NewRecorder()
+			r.AuditExport(w, req)
+
+			if w.Code != tc.wantStatus {
+				t.Fatalf("wanted %d got %d %s", tc.wantStatus, w.Code, w.Body.String())
+			}
+			if tc.wantType != "" && w.Header().Get("Content-Type") != tc.wantType {
+				t.Errorf("wanted content type %s got %s", tc.wantType, w.Header().Get("Content-Type"))
+			}
+			for _, want := range tc.wantBody {
+				if !strings.Contains(w.Body.String(), want) {
+					t.Errorf("wanted body to contain %q\n%s", want, w.Body.String())
+				}
+			}
+			if tc.notWantBody != "" && strings.Contains(w.Body.String(), tc.notWantBody) {
+				t.Errorf("wanted body without %q\n%s", tc.notWantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRoutes_RequestId(t *testing.T) {
+	var logged bytes.Buffer
+	r := testRoutes(internal.Config{})
+	r.App.Logger = logging.NewWriter(&logged, logging.LEVEL_INFO, logging.FORMAT_TEXT)
+	handler := r.RequestId(http.HandlerFunc(r.Ping))
+
+	testCases := []struct {
+		name  string
+		given string
+		keep  bool
+	}{
+		{"generated", "", false},
+		{"from proxy", "req-1.a_B", true},
+		{"unsafe", "req 1\nforged", false},
+		{"too long", strings.Repeat("a", REQUEST_ID_MAX+1), false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			logged.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/ping", nil)
+			if tc.given != "" {
+				req.Header.Set(REQUEST_ID_HEADER, tc.given)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			id := w.Header().Get(REQUEST_ID_HEADER)
+			if id == "" || (id == tc.given) != tc.keep {
+				t.Errorf("wanted the id given kept %v got %q", tc.keep, id)
+			}
+			// the line logged by the handler carries the id
+			if !strings.Contains(logged.String(), id) {
+				t.Errorf("wanted the request id %q logged got %s", id, logged.String())
+			}
+		})
+	}
+}
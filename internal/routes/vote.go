@@ -0,0 +1,144 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/state"
+	"github.com/spoonboy-io/link/internal/workflow"
+)
+
+// votePage confirms the vote of a recipient before it is made, so that an email scanner
+// following the links in an email does not vote
+var votePage = template.Must(template.New("vote").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif;">
+{{- if .Workflow.ApprovalName }}
+  <h2>{{ .Workflow.ApprovalName }}</h2>
+  {{- if .Done }}
+  <p>Thank you, your decision to {{ .Decision }} the request made by {{ .Workflow.RequestBy }} has been recorded.</p>
+  {{- else if .Accepted }}
+  <p>Thank you, your decision to {{ .Decision }} the request made by {{ .Workflow.RequestBy }} is being recorded in Morpheus.
+    If the request is still waiting for approval later, follow the link in the email again.</p>
+  {{- else }}
+  <p>{{ .Voter }}, {{ .Decision }} the request made by {{ .Workflow.RequestBy }}?</p>
+  <form method="POST">
+    <input type="hidden" name="appliance" value="{{ .Appliance }}">
+    <input type="hidden" name="approval" value="{{ .Workflow.ApprovalId }}">
+    <input type="hidden" name="voter" value="{{ .Voter }}">
+    <input type="hidden" name="decision" value="{{ .Decision }}">
+    <input type="hidden" name="token" value="{{ .Token }}">
+    <button type="submit">{{ .Decision }}</button>
+  </form>
+  {{- end }}
+{{- else }}
+  <p>{{ .Message }}</p>
+{{- end }}
+</body>
+</html>
+`))
+
+// voteData is the data of the vote page
+type voteData struct {
+	Appliance string
+	Voter     string
+	Decision  string
+	Token     string
+	Workflow  state.Workflow
+	Done      bool
+	Accepted  bool
+	Message   string
+}
+
+// VOTE_WAIT is how long a vote is waited for before the voter is told it has been accepted, it
+// is well within the write timeout of the server so the response is not cut off
+const VOTE_WAIT = 3 * time.Second
+
+// voteWait is VOTE_WAIT, shortened in tests
+var voteWait = VOTE_WAIT
+
+// Vote shows the recipient of an approval email following one of its links a page to confirm
+// their decision, which is made by the POST of the page. The link must be valid for the
+// recipient and the workflow of the approval still open
+func (r *Routes) Vote(w http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(w, "Could not read the vote", http.StatusBadRequest)
+		return
+	}
+	data := voteData{
+		Appliance: req.Form.Get("appliance"),
+		Voter:     req.Form.Get("voter"),
+		Decision:  req.Form.Get("decision"),
+		Token:     req.Form.Get("token"),
+	}
+	approvalId, _ := strconv.Atoi(req.Form.Get("approval"))
+	log := r.log(req).With(logging.ApprovalId(approvalId))
+
+	if req.Method == http.MethodGet {
+		_, wf, err := r.Workflows.Workflow(data.Appliance, approvalId, data.Voter, data.Token)
+		if err != nil {
+			log.Warn("Refused GET /vote request - 404 Not Found")
+			r.votePage(w, http.StatusNotFound, voteData{Message: err.Error()})
+			return
+		}
+		data.Workflow = wf
+		log.Info("Served GET /vote request - 200 OK")
+		r.votePage(w, http.StatusOK, data)
+		return
+	}
+
+	_, wf, err := r.Workflows.Workflow(data.Appliance, approvalId, data.Voter, data.Token)
+	if err == nil {
+		// deciding the approval in Morpheus may take longer than the server allows for a response,
+		// through retries and rate limiting, so the vote is made apart from the request. The voter
+		// is told it was accepted if it is not made in time
+		done := make(chan error, 1)
+		ctx := logging.NewContext(context.Background(), log)
+		go func() {
+			done <- r.Workflows.Vote(ctx, data.Appliance, approvalId, data.Voter, data.Token, data.Decision)
+		}()
+		select {
+		case err = <-done:
+		case <-time.After(voteWait):
+			go func() {
+				if err := <-done; err != nil {
+					log.Error("Could not record the vote", err)
+				}
+			}()
+			data.Workflow, data.Accepted = wf, true
+			log.Info("Served POST /vote request - 202 Accepted")
+			r.votePage(w, http.StatusAccepted, data)
+			return
+		}
+	}
+	switch {
+	case errors.Is(err, workflow.ERR_BAD_DECISION):
+		log.Warn("Refused POST /vote request - 400 Bad Request")
+		r.votePage(w, http.StatusBadRequest, voteData{Message: err.Error()})
+	case errors.Is(err, workflow.ERR_LINK_NOT_VALID), errors.Is(err, workflow.ERR_DECIDED):
+		log.Warn("Refused POST /vote request - 409 Conflict")
+		r.votePage(w, http.StatusConflict, voteData{Message: err.Error()})
+	case err != nil:
+		log.Error("Could not record the vote", err)
+		r.votePage(w, http.StatusBadGateway, voteData{Message: "The vote could not be recorded in Morpheus, please try again later"})
+	default:
+		data.Workflow, data.Done = wf, true
+		log.Info("Served POST /vote request - 200 OK")
+		r.votePage(w, http.StatusOK, data)
+	}
+}
+
+// votePage renders the vote page with the status
+func (r *Routes) votePage(w http.ResponseWriter, status int, data voteData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := votePage.Execute(w, data); err != nil {
+		r.App.Logger.Error(fmt.Sprintf("Could not render the vote page for approval %d", data.Workflow.ApprovalId), err)
+	}
+}
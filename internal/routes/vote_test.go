@@ -0,0 +1,140 @@
+package routes
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/health"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/morpheus"
+	"github.com/spoonboy-io/link/internal/morpheus/morpheustest"
+	"github.com/spoonboy-io/link/internal/state"
+	"github.com/spoonboy-io/link/internal/workflow"
+)
+
+// voteRoutes returns routes voting on the approvals of the fake Morpheus server, with open
+// workflows for approvals 1 and 2 whose recipient is ops@test.io
+func voteRoutes(t *testing.T, srv *morpheustest.Server) (*Routes, *state.State) {
+	t.Helper()
+	dir := t.TempDir()
+	for id := 1; id <= 2; id++ {
+		srv.AddApproval(approval.Approval{Id: id, Name: fmt.Sprintf("APPROVAL-%d", id), Items: []approval.Item{{Id: 100 + id}}})
+	}
+
+	cfg := srv.Appliance(internal.DEFAULT_APPLIANCE)
+	cfg.StateFile = filepath.Join(dir, "state.json")
+	logger := logging.NewWriter(io.Discard, logging.LEVEL_INFO, logging.FORMAT_TEXT)
+	app := &internal.App{Logger: logger, Config: internal.Config{
+		AuditFile:  filepath.Join(dir, "audit.jsonl"),
+		Appliances: []internal.Appliance{cfg},
+	}}
+	engine := workflow.New(app, logger, health.NewTracker(time.Now()))
+	if _, err := engine.OpenAppliances(app.Config.Appliances); err != nil {
+		t.Fatalf("could not open appliances %v", err)
+	}
+	ap, _ := engine.Appliance(internal.DEFAULT_APPLIANCE)
+	for id := 1; id <= 2; id++ {
+		ap.State.Open(state.Workflow{ApprovalId: id, ApprovalName: "APPROVAL", RequestBy: "admin", Recipients: []string{"ops@test.io"}, Token: "token"})
+	}
+	return &Routes{App: app, Workflows: engine}, ap.State
+}
+
+// voteForm returns the form of a vote by ops@test.io
+func voteForm(approvalId, decision, token string) url.Values {
+	return url.Values{
+		"appliance": {internal.DEFAULT_APPLIANCE},
+		"approval":  {approvalId},
+		"voter":     {"ops@test.io"},
+		"decision":  {decision},
+		"token":     {token},
+	}
+}
+
+func TestRoutes_Vote(t *testing.T) {
+	srv := morpheustest.NewServer()
+	defer srv.Close()
+	r, st := voteRoutes(t, srv)
+	token := state.LinkToken("token", "ops@test.io")
+
+	testCases := []struct {
+		name       string
+		method     string
+		form       url.Values
+		wantStatus int
+		wantBody   string
+	}{
+		{"confirm", http.MethodGet, voteForm("1", morpheus.ITEM_DENY, token), http.StatusOK, `<form method="POST">`},
+		{"confirm forged link", http.MethodGet, voteForm("1", morpheus.ITEM_DENY, "forged"), http.StatusNotFound, "The link is not valid"},
+		{"forged link", http.MethodPost, voteForm("1", morpheus.ITEM_DENY, "forged"), http.StatusConflict, "The link is not valid"},
+		{"bad decision", http.MethodPost, voteForm("1", "maybe", token), http.StatusBadRequest, "Decision must be"},
+		{"vote", http.MethodPost, voteForm("1", morpheus.ITEM_DENY, token), http.StatusOK, "has been recorded"},
+		{"vote again", http.MethodPost, voteForm("1", morpheus.ITEM_APPROVE, token), http.StatusConflict, "The link is not valid"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var req *http.Request
+			if tc.method == http.MethodGet {
+				req = httptest.NewRequest(tc.method, workflow.VOTE_PATH+"?"+tc.form.Encode(), nil)
+			} else {
+				req = httptest.NewRequest(tc.method, workflow.VOTE_PATH, strings.NewReader(tc.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			w := httptest.NewRecorder()
+			r.Vote(w, req)
+
+			if w.Code != tc.wantStatus || !strings.Contains(w.Body.String(), tc.wantBody) {
+				t.Errorf("wanted %d containing %q got %d\n%s", tc.wantStatus, tc.wantBody, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	// only the vote is made in Morpheus
+	if actions := srv.Actions(); len(actions) != 1 || actions[0] != (morpheustest.Action{ItemId: 101, Action: morpheus.ITEM_DENY}) {
+		t.Errorf("wanted APPROVAL-1 denied got %+v", actions)
+	}
+	if st.Managing(1) {
+		t.Error("wanted the workflow closed")
+	}
+}
+
+func TestRoutes_Vote_Slow(t *testing.T) {
+	srv := morpheustest.NewServer()
+	defer srv.Close()
+	r, st := voteRoutes(t, srv)
+
+	// Morpheus is slower to decide the approval than the vote is waited for
+	defer func(wait time.Duration) { voteWait = wait }(voteWait)
+	voteWait = 10 * time.Millisecond
+	srv.After("/api/approval-items/102/approve", func() { time.Sleep(200 * time.Millisecond) })
+
+	form := voteForm("2", morpheus.ITEM_APPROVE, state.LinkToken("token", "ops@test.io"))
+	req := httptest.NewRequest(http.MethodPost, workflow.VOTE_PATH, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	r.Vote(w, req)
+	if w.Code != http.StatusAccepted || !strings.Contains(w.Body.String(), "is being recorded") {
+		t.Fatalf("wanted the vote accepted got %d\n%s", w.Code, w.Body.String())
+	}
+
+	// the vote is still recorded once Morpheus responds
+	var records []audit.Record
+	var err error
+	for deadline := time.Now().Add(5 * time.Second); len(records) == 0 && time.Now().Before(deadline); {
+		time.Sleep(10 * time.Millisecond)
+		records, err = audit.Read(r.App.Config.AuditFile)
+	}
+	if err != nil || len(records) != 1 || records[0].Outcome != audit.OUTCOME_APPROVED || len(records[0].Votes) != 1 || st.Managing(2) {
+		t.Errorf("wanted the vote approving APPROVAL-2 in the audit trail got %+v %v", records, err)
+	}
+}
@@ -0,0 +1,61 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/spoonboy-io/link/internal/logging"
+)
+
+// WEBHOOK_MAX_BODY is the largest webhook payload read, larger payloads are truncated
+const WEBHOOK_MAX_BODY = 1 << 20
+
+// WebhookEvent holds the fields of a Morpheus webhook payload which identify the approval, if
+// the payload has them. Task, workflow and alert webhooks all have different payloads, so the
+// payload only triggers a poll and the approvals are always read from the API
+type WebhookEvent struct {
+	Approval struct {
+		Id   int    `json:"id"`
+		Name string `json:"name"`
+	} `json:"approval"`
+}
+
+// Webhook receives Morpheus webhook calls, such as from an approval policy task or an alert
+// webhook, and triggers an immediate poll for new approvals. The token configured as
+// WEBHOOK_TOKEN is required as a bearer token, or as the 'token' query parameter for webhooks
+// which cannot set headers. The endpoint is disabled if no token is configured
+func (r *Routes) Webhook(w http.ResponseWriter, req *http.Request) {
+	given := bearerToken(req)
+	if given == "" {
+		given = req.URL.Query().Get("token")
+	}
+	if !authorized(given, r.App.Settings().WebhookToken) {
+		r.log(req).Warn("Refused POST /webhook request - 401 Unauthorized")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(req.Body, WEBHOOK_MAX_BODY))
+	if err != nil {
+		http.Error(w, "Could not read request body", http.StatusBadRequest)
+		return
+	}
+
+	event := WebhookEvent{}
+	if len(body) > 0 && json.Unmarshal(body, &event) == nil && event.Approval.Id != 0 {
+		r.log(req).Info(fmt.Sprintf("Received webhook for approval '%s' (%d)", event.Approval.Name, event.Approval.Id), logging.ApprovalId(event.Approval.Id))
+	} else {
+		r.log(req).Info("Received webhook")
+	}
+
+	// a poll already asked for will also find this approval
+	select {
+	case r.PollNow <- struct{}{}:
+	default:
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	r.log(req).Info("Served POST /webhook request - 202 Accepted")
+}
@@ -0,0 +1,85 @@
+package routes
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/logging"
+)
+
+// testRoutes returns routes for an app with the configuration given, logging nothing
+func testRoutes(cfg internal.Config) *Routes {
+	return &Routes{App: &internal.App{
+		Logger: logging.NewWriter(io.Discard, logging.LEVEL_INFO, logging.FORMAT_TEXT),
+		Config: cfg,
+	}}
+}
+
+func TestRoutes_Webhook(t *testing.T) {
+	testCases := []struct {
+		name       string
+		token      string
+		header     string
+		query      string
+		wantStatus int
+	}{
+		{"bearer token", "secret", "Bearer secret", "", http.StatusAccepted},
+		{"query token", "secret", "", "?token=secret", http.StatusAccepted},
+		{"bad token", "secret", "Bearer guess", "?token=guess", http.StatusUnauthorized},
+		{"no token given", "secret", "", "", http.StatusUnauthorized},
+		{"disabled", "", "Bearer ", "?token=", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			pollNow := make(chan struct{}, 1)
+			r := testRoutes(internal.Config{WebhookToken: tc.token})
+			r.PollNow = pollNow
+
+			req := httptest.NewRequest(http.MethodPost, "/webhook"+tc.query, strings.NewReader(`{"approval":{"id":7,"name":"APPROVAL-7"}}`))
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+			w := httptest.NewRecorder()
+			r.Webhook(w, req)
+
+			if w.Code != tc.wantStatus {
+				t.Errorf("wanted %d got %d", tc.wantStatus, w.Code)
+			}
+			if polled := len(pollNow) == 1; polled != (tc.wantStatus == http.StatusAccepted) {
+				t.Errorf("wanted a poll asked for only when accepted got %v", polled)
+			}
+		})
+	}
+}
+
+func TestRoutes_Webhook_Coalesce(t *testing.T) {
+	// a poll already asked for is not asked for again, and the webhook does not wait for the poller
+	pollNow := make(chan struct{}, 1)
+	r := testRoutes(internal.Config{WebhookToken: "secret"})
+	r.PollNow = pollNow
+
+	for i := 0; i < 3; i++ {
+		req := httptest.NewRequest(http.MethodPost, "/webhook?token=secret", nil)
+		w := httptest.NewRecorder()
+		r.Webhook(w, req)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("wanted %d got %d", http.StatusAccepted, w.Code)
+		}
+	}
+	if len(pollNow) != 1 {
+		t.Errorf("wanted one poll asked for got %d", len(pollNow))
+	}
+
+	// nor when the poller is busy and not receiving
+	r.PollNow = make(chan struct{})
+	w := httptest.NewRecorder()
+	r.Webhook(w, httptest.NewRequest(http.MethodPost, "/webhook?token=secret", nil))
+	if w.Code != http.StatusAccepted {
+		t.Errorf("wanted %d got %d", http.StatusAccepted, w.Code)
+	}
+}
@@ -0,0 +1,177 @@
+// Package secret resolves secret references in the configuration, such as 'file:/run/secrets/token',
+// 'env:SMTP_PASSWORD' or 'vault:secret/data/link#smtpPassword', to the secret they refer to
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	SCHEME_FILE  = "file"
+	SCHEME_ENV   = "env"
+	SCHEME_VAULT = "vault"
+
+	VAULT_TIMEOUT = 10 * time.Second
+)
+
+var (
+	ERR_EMPTY_SECRET     = errors.New("Secret is empty")
+	ERR_NO_VAULT_ADDR    = errors.New("No Vault address is configured")
+	ERR_NO_VAULT_TOKEN   = errors.New("No Vault token is configured")
+	ERR_BAD_VAULT_REF    = errors.New("Vault reference must be in the form 'vault:<path>#<key>'")
+	ERR_SECRET_NOT_FOUND = errors.New("Secret not found")
+)
+
+// Backend looks up a secret by reference, the reference is the part after the scheme
+type Backend interface {
+	Lookup(ctx context.Context, ref string) (string, error)
+}
+
+// Resolver resolves secret references using the backend registered for the scheme
+type Resolver struct {
+	backends map[string]Backend
+}
+
+// NewResolver returns a resolver for 'file:' and 'env:' references, other backends, such as
+// Vault, are added with Register
+func NewResolver() *Resolver {
+	r := &Resolver{backends: map[string]Backend{}}
+	r.Register(SCHEME_FILE, File{})
+	r.Register(SCHEME_ENV, Env{})
+	return r
+}
+
+// Register adds the backend used to resolve references with the scheme
+func (r *Resolver) Register(scheme string, backend Backend) {
+	r.backends[scheme] = backend
+}
+
+// Resolve returns the secret the value refers to, a value which is not a reference is
+// returned unchanged so plain secrets continue to work
+func (r *Resolver) Resolve(ctx context.Context, value string) (string, error) {
+	backend, ref, ok := r.split(value)
+	if !ok {
+		return value, nil
+	}
+
+	secret, err := backend.Lookup(ctx, ref)
+	if err != nil {
+		return "", err
+	}
+	if secret == "" {
+		return "", fmt.Errorf("%w, '%s'", ERR_EMPTY_SECRET, value)
+	}
+	return secret, nil
+}
+
+// split returns the backend and the reference if the value has a registered scheme
+func (r *Resolver) split(value string) (Backend, string, bool) {
+	i := strings.Index(value, ":")
+	if i < 1 {
+		return nil, "", false
+	}
+	backend, ok := r.backends[value[:i]]
+	if !ok {
+		return nil, "", false
+	}
+	return backend, value[i+1:], true
+}
+
+// File reads a secret from a file, surrounding whitespace is removed
+type File struct{}
+
+func (File) Lookup(_ context.Context, ref string) (string, error) {
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// Env reads a secret from an environment variable
+type Env struct{}
+
+func (Env) Lookup(_ context.Context, ref string) (string, error) {
+	return os.Getenv(ref), nil
+}
+
+// Vault reads a secret from a HashiCorp Vault KV secrets engine. The reference is the API path
+// of the secret and the key within it, 'secret/data/link#smtpPassword' for KV version 2 or
+// 'kv/link#smtpPassword' for version 1
+type Vault struct {
+	Addr      string
+	Token     string
+	Namespace string
+	Client    *http.Client
+}
+
+// vaultResponse is the response to a KV read, version 2 nests the secret in a second 'data'
+type vaultResponse struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []string               `json:"errors"`
+}
+
+func (v Vault) Lookup(ctx context.Context, ref string) (string, error) {
+	if v.Addr == "" {
+		return "", ERR_NO_VAULT_ADDR
+	}
+	if v.Token == "" {
+		return "", ERR_NO_VAULT_TOKEN
+	}
+
+	i := strings.LastIndex(ref, "#")
+	if i < 1 || i == len(ref)-1 {
+		return "", ERR_BAD_VAULT_REF
+	}
+	path, key := strings.Trim(ref[:i], "/"), ref[i+1:]
+
+	url := fmt.Sprintf("%s/v1/%s", strings.TrimRight(v.Addr, "/"), path)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+	if v.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.Namespace)
+	}
+
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: VAULT_TIMEOUT}
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	vaultRes := vaultResponse{}
+	if err := json.NewDecoder(res.Body).Decode(&vaultRes); err != nil && res.StatusCode == http.StatusOK {
+		return "", fmt.Errorf("Could not parse Vault response for '%s': %v", path, err)
+	}
+	if res.StatusCode == http.StatusNotFound {
+		return "", fmt.Errorf("%w, '%s'", ERR_SECRET_NOT_FOUND, path)
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Vault returned %s for '%s': %s", res.Status, path, strings.Join(vaultRes.Errors, ", "))
+	}
+
+	data := vaultRes.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, found := nested[key]; found {
+			data = nested
+		}
+	}
+	value, ok := data[key]
+	if !ok {
+		return "", fmt.Errorf("%w, '%s' in '%s'", ERR_SECRET_NOT_FOUND, key, path)
+	}
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,84 @@
+package secret_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/spoonboy-io/link/internal/secret"
+)
+
+// fakeVault stands in for a Vault dev server with a KV version 2 engine mounted at 'secret'
+// and a version 1 engine mounted at 'kv'
+func fakeVault(token string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			_, _ = w.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/link":
+			_, _ = w.Write([]byte(`{"data":{"data":{"smtpPassword":"kv2-secret"},"metadata":{"version":3}}}`))
+		case "/v1/kv/link":
+			_, _ = w.Write([]byte(`{"data":{"smtpPassword":"kv1-secret"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			_, _ = w.Write([]byte(`{"errors":[]}`))
+		}
+	}))
+}
+
+func TestResolver_Resolve(t *testing.T) {
+	vault := fakeVault("root")
+	defer vault.Close()
+
+	if err := os.WriteFile("test_secret", []byte("file-secret\n"), 0600); err != nil {
+		t.Fatalf("could not write test secret %v", err)
+	}
+	defer os.Remove("test_secret")
+	os.Setenv("TEST_SECRET", "env-secret")
+	defer os.Unsetenv("TEST_SECRET")
+
+	r := secret.NewResolver()
+	r.Register(secret.SCHEME_VAULT, secret.Vault{Addr: vault.URL, Token: "root"})
+
+	testCases := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr error
+	}{
+		{name: "plain value, unchanged", value: "plain-secret", want: "plain-secret"},
+		{name: "unknown scheme, unchanged", value: "https://host", want: "https://host"},
+		{name: "file", value: "file:test_secret", want: "file-secret"},
+		{name: "env", value: "env:TEST_SECRET", want: "env-secret"},
+		{name: "env not set", value: "env:TEST_NOT_SET", wantErr: secret.ERR_EMPTY_SECRET},
+		{name: "vault kv2", value: "vault:secret/data/link#smtpPassword", want: "kv2-secret"},
+		{name: "vault kv1", value: "vault:kv/link#smtpPassword", want: "kv1-secret"},
+		{name: "vault missing key", value: "vault:kv/link#token", wantErr: secret.ERR_SECRET_NOT_FOUND},
+		{name: "vault missing path", value: "vault:kv/other#token", wantErr: secret.ERR_SECRET_NOT_FOUND},
+		{name: "vault bad reference", value: "vault:kv/link", wantErr: secret.ERR_BAD_VAULT_REF},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := r.Resolve(context.Background(), tc.value)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("wanted error %v got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("wanted '%s' got '%s'", tc.want, got)
+			}
+		})
+	}
+
+	// a bad token is reported
+	r.Register(secret.SCHEME_VAULT, secret.Vault{Addr: vault.URL, Token: "wrong"})
+	if _, err := r.Resolve(context.Background(), "vault:kv/link#smtpPassword"); err == nil {
+		t.Error("wanted an error for a bad vault token")
+	}
+}
@@ -1,11 +1,329 @@
 package state
 
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// failed approvals are retried after a delay doubling from the base, up to the maximum
+	RETRY_BASE = 30 * time.Second
+	RETRY_MAX  = time.Hour
+)
+
+var ERR_NOT_QUEUED = errors.New("The approval is not queued")
+
 // State holds information about the last poll against the API
 // and approvals which are in process (i.e link is managing) when the application was terminated
 type State struct {
-	LastPollId int
+	LastPollId int               `json:"lastPollId"`
+	Workflows  map[int]*Workflow `json:"workflows"`
+	Queue      map[int]*Queued   `json:"queue"`
+
+	// File is where the state is saved, it is not saved if empty
+	File string `json:"-"`
+
+	mu sync.Mutex
+	// saveMu serialises saves, which may be made by the poller and by a vote
+	saveMu sync.Mutex
 }
 
-func (s *State) CreateAndWrite() error {
+// Workflow is an approval which Link has notified recipients about and is managing until the
+// approval is resolved. Id identifies the workflow in the log. Token authenticates the links in
+// the notification emails, each recipient's links carry the LinkToken derived from it for them.
+// It is cleared when the workflow is closed so that outstanding links no longer work
+type Workflow struct {
+	Id            string    `json:"id"`
+	ApprovalId    int       `json:"approvalId"`
+	ApprovalName  string    `json:"approvalName"`
+	RequestBy     string    `json:"requestBy"`
+	Descriptions  []string  `json:"descriptions"`
+	Recipients    []string  `json:"recipients"`
+	ConfigVersion int       `json:"configVersion"`
+	Token         string    `json:"token"`
+	Created       time.Time `json:"created"`
+}
+
+// Queued is an approval which has been listed but not yet processed. It stays queued until it
+// has been processed, being retried with a growing delay each time processing fails. The id and
+// token of its workflow are kept across attempts with the recipients already notified, so they
+// are not notified again and the links they were sent stay valid
+type Queued struct {
+	ApprovalId  int       `json:"approvalId"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"lastError,omitempty"`
+	NextAttempt time.Time `json:"nextAttempt"`
+	WorkflowId  string    `json:"workflowId,omitempty"`
+	Token       string    `json:"token,omitempty"`
+	Notified    []string  `json:"notified,omitempty"`
+}
+
+// NewToken returns a random token for the links in a workflow's notification emails
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// LinkToken returns the token for the links emailed to a recipient of a workflow, derived from
+// the workflow token so that a link only works for the recipient it was sent to
+func LinkToken(token, recipient string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(strings.ToLower(recipient)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewWorkflowId returns a random id for a workflow, which unlike its token can be logged
+func NewWorkflowId() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Open starts managing the workflow, replacing any workflow for the same approval. A workflow
+// without an id is given one
+func (s *State) Open(wf Workflow) {
+	if wf.Id == "" {
+		wf.Id = NewWorkflowId()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Workflows == nil {
+		s.Workflows = map[int]*Workflow{}
+	}
+	s.Workflows[wf.ApprovalId] = &wf
+}
+
+// Close stops managing the workflow for the approval, returning it. Its token is discarded
+// so links in the emails sent for it are no longer valid
+func (s *State) Close(approvalId int) (Workflow, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wf, ok := s.Workflows[approvalId]
+	if !ok {
+		return Workflow{}, false
+	}
+	delete(s.Workflows, approvalId)
+	closed := *wf
+	closed.Token = ""
+	return closed, true
+}
+
+// InFlight returns the open workflows ordered by approval id
+func (s *State) InFlight() []Workflow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	workflows := make([]Workflow, 0, len(s.Workflows))
+	for _, wf := range s.Workflows {
+		workflows = append(workflows, *wf)
+	}
+	sort.Slice(workflows, func(i, j int) bool {
+		return workflows[i].ApprovalId < workflows[j].ApprovalId
+	})
+	return workflows
+}
+
+// Managing reports whether there is an open workflow for the approval
+func (s *State) Managing(approvalId int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.Workflows[approvalId]
+	return ok
+}
+
+// Enqueue queues the approval for processing and advances LastPollId past it. As the approval
+// is held in the queue until Done it is not lost if processing it fails
+func (s *State) Enqueue(approvalId int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.Queue == nil {
+		s.Queue = map[int]*Queued{}
+	}
+	if _, ok := s.Queue[approvalId]; !ok {
+		s.Queue[approvalId] = &Queued{ApprovalId: approvalId}
+	}
+	s.advance(approvalId)
+}
+
+// Advance advances LastPollId past an approval which needs no processing, without queueing it
+func (s *State) Advance(approvalId int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.advance(approvalId)
+}
+
+func (s *State) advance(approvalId int) {
+	if approvalId > s.LastPollId {
+		s.LastPollId = approvalId
+	}
+}
+
+// Due returns the ids of the queued approvals due to be processed at now, in id order
+func (s *State) Due(now time.Time) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var due []int
+	for id, q := range s.Queue {
+		if !q.NextAttempt.After(now) {
+			due = append(due, id)
+		}
+	}
+	sort.Ints(due)
+	return due
+}
+
+// Retry records that processing the queued approval failed, delaying the next attempt
+func (s *State) Retry(approvalId int, err error, now time.Time) Queued {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.Queue[approvalId]
+	if !ok {
+		return Queued{}
+	}
+	q.Attempts++
+	q.LastError = err.Error()
+	q.NextAttempt = now.Add(retryDelay(q.Attempts))
+	return *q
+}
+
+// Prepare gives the queued approval the id and token of its workflow, if it does not have them
+// from an earlier attempt, returning it
+func (s *State) Prepare(approvalId int) (Queued, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.Queue[approvalId]
+	if !ok {
+		return Queued{}, ERR_NOT_QUEUED
+	}
+	if q.Token == "" {
+		token, err := NewToken()
+		if err != nil {
+			return Queued{}, err
+		}
+		q.WorkflowId, q.Token = NewWorkflowId(), token
+	}
+	prepared := *q
+	prepared.Notified = append([]string(nil), q.Notified...)
+	return prepared, nil
+}
+
+// Notify records that a recipient of the queued approval has been notified
+func (s *State) Notify(approvalId int, recipient string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.Queue[approvalId]; ok {
+		q.Notified = append(q.Notified, recipient)
+	}
+}
+
+// Done removes the approval from the queue once it has been processed
+func (s *State) Done(approvalId int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Queue, approvalId)
+}
+
+// Pending returns the approvals waiting to be processed, in id order
+func (s *State) Pending() []Queued {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	queued := make([]Queued, 0, len(s.Queue))
+	for _, q := range s.Queue {
+		queued = append(queued, *q)
+	}
+	sort.Slice(queued, func(i, j int) bool {
+		return queued[i].ApprovalId < queued[j].ApprovalId
+	})
+	return queued
+}
+
+// retryDelay returns the delay before the next attempt after a number of failed attempts
+func retryDelay(attempts int) time.Duration {
+	d := RETRY_BASE << uint(attempts-1)
+	if d <= 0 || d > RETRY_MAX {
+		d = RETRY_MAX
+	}
+	return d
+}
+
+// ValidLink reports whether the token of an email link is the LinkToken of the open workflow
+// for the approval, for the recipient voting
+func (s *State) ValidLink(approvalId int, voter, token string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	wf, ok := s.Workflows[approvalId]
+	if !ok || wf.Token == "" || token == "" {
+		return false
+	}
+	recipient := false
+	for _, r := range wf.Recipients {
+		recipient = recipient || strings.EqualFold(r, voter)
+	}
+	return recipient && subtle.ConstantTimeCompare([]byte(LinkToken(wf.Token, voter)), []byte(token)) == 1
+}
+
+// Load reads the state saved to File, there is nothing to load if the file does not exist
+func (s *State) Load() error {
+	if s.File == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.File)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := json.Unmarshal(data, s); err != nil {
+		return err
+	}
+
+	// workflows saved before workflows had ids
+	for _, wf := range s.Workflows {
+		if wf.Id == "" {
+			wf.Id = NewWorkflowId()
+		}
+	}
 	return nil
 }
+
+// CreateAndWrite saves the state to File, writing a temporary file first so the saved
+// state is never partly written
+func (s *State) CreateAndWrite() error {
+	if s.File == "" {
+		return nil
+	}
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	data, err := json.MarshalIndent(s, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := s.File + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.File)
+}
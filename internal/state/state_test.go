@@ -0,0 +1,126 @@
+package state_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+func TestState_Workflows(t *testing.T) {
+	file := "test_state.json"
+	defer os.Remove(file)
+
+	token, err := state.NewToken()
+	if err != nil || len(token) != 64 {
+		t.Fatalf("wanted a 64 character token got '%s' %v", token, err)
+	}
+
+	st := &state.State{File: file, LastPollId: 42}
+	st.Open(state.Workflow{ApprovalId: 7, ApprovalName: "APPROVAL-7", Recipients: []string{"ops@test.io", "cfo@test.io"}, Token: token})
+	st.Open(state.Workflow{ApprovalId: 3, ApprovalName: "APPROVAL-3", Token: "other"})
+
+	if inFlight := st.InFlight(); len(inFlight) != 2 || inFlight[0].ApprovalId != 3 {
+		t.Fatalf("wanted 2 workflows ordered by id got %+v", inFlight)
+	}
+	if inFlight := st.InFlight(); inFlight[0].Id == "" || inFlight[0].Id == inFlight[1].Id {
+		t.Errorf("wanted each workflow given an id got %+v", inFlight)
+	}
+	// a link is valid only for the recipient it was sent to
+	link := state.LinkToken(token, "ops@test.io")
+	if !st.ValidLink(7, "ops@test.io", link) || !st.ValidLink(7, "OPS@test.io", link) {
+		t.Error("wanted the link of the recipient to be valid")
+	}
+	if st.ValidLink(7, "cfo@test.io", link) || st.ValidLink(7, "ops@test.io", token) || st.ValidLink(8, "ops@test.io", link) {
+		t.Error("wanted only the recipient's link token to be valid")
+	}
+	if st.ValidLink(7, "eve@test.io", state.LinkToken(token, "eve@test.io")) {
+		t.Error("wanted a link for someone not a recipient to be invalid")
+	}
+
+	// saved and loaded
+	if err := st.CreateAndWrite(); err != nil {
+		t.Fatalf("could not save state %v", err)
+	}
+	loaded := &state.State{File: file}
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("could not load state %v", err)
+	}
+	if loaded.LastPollId != 42 || len(loaded.InFlight()) != 2 || !loaded.ValidLink(7, "ops@test.io", link) {
+		t.Errorf("wanted the saved state got %+v", loaded)
+	}
+
+	// closing a workflow invalidates its links
+	closed, ok := loaded.Close(7)
+	if !ok || closed.ApprovalName != "APPROVAL-7" || closed.Token != "" {
+		t.Errorf("wanted the closed workflow without its token got %+v", closed)
+	}
+	if loaded.ValidLink(7, "ops@test.io", link) {
+		t.Error("wanted the link of a closed workflow to be invalid")
+	}
+	if _, ok := loaded.Close(7); ok {
+		t.Error("wanted a workflow to be closed only once")
+	}
+
+	// no state file is an empty state
+	empty := &state.State{File: "missing.json"}
+	if err := empty.Load(); err != nil || len(empty.InFlight()) != 0 {
+		t.Errorf("wanted an empty state got %+v %v", empty, err)
+	}
+}
+
+func TestState_Queue(t *testing.T) {
+	now := time.Now()
+	st := &state.State{LastPollId: 10}
+
+	st.Enqueue(12)
+	st.Enqueue(11)
+	st.Enqueue(12)
+	if st.LastPollId != 12 || len(st.Pending()) != 2 {
+		t.Fatalf("wanted 2 queued and last poll id 12 got %d %+v", st.LastPollId, st.Pending())
+	}
+	if due := st.Due(now); len(due) != 2 || due[0] != 11 {
+		t.Fatalf("wanted 11 and 12 due got %v", due)
+	}
+
+	// each failure doubles the delay before the next attempt
+	q := st.Retry(11, errors.New("timeout"), now)
+	if q.Attempts != 1 || q.LastError != "timeout" || !q.NextAttempt.Equal(now.Add(state.RETRY_BASE)) {
+		t.Errorf("wanted the first retry after %s got %+v", state.RETRY_BASE, q)
+	}
+	q = st.Retry(11, errors.New("timeout"), now)
+	if !q.NextAttempt.Equal(now.Add(2 * state.RETRY_BASE)) {
+		t.Errorf("wanted the second retry after %s got %+v", 2*state.RETRY_BASE, q)
+	}
+	for i := 0; i < 20; i++ {
+		q = st.Retry(11, errors.New("timeout"), now)
+	}
+	if !q.NextAttempt.Equal(now.Add(state.RETRY_MAX)) {
+		t.Errorf("wanted retries capped at %s got %+v", state.RETRY_MAX, q)
+	}
+	if due := st.Due(now); len(due) != 1 || due[0] != 12 {
+		t.Errorf("wanted only 12 due got %v", due)
+	}
+
+	// the workflow id and token of an approval are kept across attempts, with the recipients notified
+	first, err := st.Prepare(11)
+	if err != nil || first.WorkflowId == "" || first.Token == "" {
+		t.Fatalf("wanted a workflow id and token got %+v %v", first, err)
+	}
+	st.Notify(11, "ops@test.io")
+	again, err := st.Prepare(11)
+	if err != nil || again.WorkflowId != first.WorkflowId || again.Token != first.Token || len(again.Notified) != 1 || again.Notified[0] != "ops@test.io" {
+		t.Errorf("wanted the same workflow with ops notified got %+v %v", again, err)
+	}
+	if _, err := st.Prepare(13); err != state.ERR_NOT_QUEUED {
+		t.Errorf("wanted %v got %v", state.ERR_NOT_QUEUED, err)
+	}
+
+	st.Done(11)
+	st.Done(12)
+	if len(st.Pending()) != 0 || st.LastPollId != 12 {
+		t.Errorf("wanted an empty queue got %+v", st.Pending())
+	}
+}
@@ -0,0 +1,71 @@
+// Package watch provides a polling file watcher, used to pick up changes to configuration
+// files without restarting the application
+package watch
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+	exists  bool
+}
+
+func stat(file string) fileState {
+	info, err := os.Stat(file)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{modTime: info.ModTime(), size: info.Size(), exists: true}
+}
+
+// Paths checks the files returned by paths at each interval, sending the name of a file on the
+// returned channel when its modification time or size has changed. A file which is removed is
+// not reported until it exists again. paths is called at each interval so the files watched can
+// change, a file newly returned is reported once it changes after it is first checked and files
+// no longer returned are forgotten. Watching stops when the context is cancelled
+func Paths(ctx context.Context, interval time.Duration, paths func() []string) <-chan string {
+	changed := make(chan string)
+
+	states := map[string]fileState{}
+	for _, file := range paths() {
+		states[file] = stat(file)
+	}
+
+	go func() {
+		defer close(changed)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			files := paths()
+			watched := make(map[string]fileState, len(files))
+			for _, file := range files {
+				current := stat(file)
+				last, ok := states[file]
+				watched[file] = current
+				if !ok || current == last || !current.exists {
+					continue
+				}
+
+				select {
+				case changed <- file:
+				case <-ctx.Done():
+					return
+				}
+			}
+			states = watched
+		}
+	}()
+
+	return changed
+}
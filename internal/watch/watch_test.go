@@ -0,0 +1,82 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPaths_Change(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "approvals.yaml")
+	if err := os.WriteFile(file, []byte("---\n"), 0644); err != nil {
+		t.Fatalf("could not write test file %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := Paths(ctx, 10*time.Millisecond, func() []string { return []string{file} })
+
+	if err := os.WriteFile(file, []byte("---\n- approval:\n"), 0644); err != nil {
+		t.Fatalf("could not write test file %v", err)
+	}
+
+	select {
+	case got := <-changed:
+		if got != file {
+			t.Errorf("wanted %s got %s", file, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("change was not detected")
+	}
+
+	cancel()
+	for range changed {
+	}
+}
+
+func TestPaths(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "approvals.yaml")
+	second := filepath.Join(dir, "approvals-v2.yaml")
+	for _, file := range []string{first, second} {
+		if err := os.WriteFile(file, []byte("---\n"), 0644); err != nil {
+			t.Fatalf("could not write test file %v", err)
+		}
+	}
+
+	current := make(chan string, 1)
+	current <- first
+	watching := first
+	paths := func() []string {
+		select {
+		case watching = <-current:
+		default:
+		}
+		return []string{watching}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	changed := Paths(ctx, 10*time.Millisecond, paths)
+
+	// the file watched is replaced, changes to the new file are reported
+	current <- second
+	time.Sleep(50 * time.Millisecond)
+	if err := os.WriteFile(second, []byte("---\n- approval:\n"), 0644); err != nil {
+		t.Fatalf("could not write test file %v", err)
+	}
+
+	select {
+	case got := <-changed:
+		if got != second {
+			t.Errorf("wanted %s got %s", second, got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("change was not detected")
+	}
+
+	cancel()
+	for range changed {
+	}
+}
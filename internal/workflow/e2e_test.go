@@ -0,0 +1,286 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/email"
+	"github.com/spoonboy-io/link/internal/health"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/metrics"
+	"github.com/spoonboy-io/link/internal/morpheus"
+	"github.com/spoonboy-io/link/internal/morpheus/morpheustest"
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+const e2eApprovals = `- approval:
+    description: production changes
+    onProvision: true
+    recipientList:
+      - prod@test.io
+    scope:
+      group: Production
+- approval:
+    description: expensive
+    onProvision: true
+    recipientList:
+      - finance@test.io
+      - cfo@test.io
+    scope:
+      match: cost > 1000
+`
+
+// outbox records the emails the engine sends, failing while err is set for the emails to
+// failTo, or all emails if it is empty
+type outbox struct {
+	sent   []email.Message
+	err    error
+	failTo string
+}
+
+func (o *outbox) send(_ internal.Config, msg email.Message) error {
+	if o.err != nil && (o.failTo == "" || msg.To[0] == o.failTo) {
+		return o.err
+	}
+	o.sent = append(o.sent, msg)
+	return nil
+}
+
+// take returns the emails sent since last taken
+func (o *outbox) take() []email.Message {
+	sent := o.sent
+	o.sent = nil
+	return sent
+}
+
+// makeDue makes the queued approvals due to be retried
+func makeDue(st *state.State) {
+	for _, q := range st.Pending() {
+		st.Retry(q.ApprovalId, context.DeadlineExceeded, time.Now().Add(-2*state.RETRY_MAX))
+	}
+}
+
+// voteLink returns the link of the email with the text
+func voteLink(t *testing.T, body, text string) *url.URL {
+	t.Helper()
+	m := regexp.MustCompile(`<a href="([^"]+)">` + text + `</a>`).FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("wanted a %s link in the email\n%s", text, body)
+	}
+	link, err := url.Parse(html.UnescapeString(m[1]))
+	if err != nil {
+		t.Fatalf("could not parse link %v", err)
+	}
+	return link
+}
+
+func TestEndToEnd(t *testing.T) {
+	dir := t.TempDir()
+	approvalsFile := filepath.Join(dir, "approvals.yaml")
+	if err := os.WriteFile(approvalsFile, []byte(e2eApprovals), 0644); err != nil {
+		t.Fatalf("could not write approvals %v", err)
+	}
+	approval.SetTemplateFolder(dir)
+	approval.SetMatchMode(internal.MATCH_MODE_ALL)
+	if err := approval.ReadAndParseConfig(approvalsFile); err != nil {
+		t.Fatalf("could not read approvals %v", err)
+	}
+	if err := approval.ValidateConfig(); err != nil {
+		t.Fatalf("could not validate approvals %v", err)
+	}
+
+	srv := morpheustest.NewServer()
+	defer srv.Close()
+
+	// a production instance, and an app of two instances costing over 1000 a month
+	price := &morpheus.Price{Price: 1, Currency: "USD", Unit: "hour"}
+	srv.AddInstance(morpheus.Instance{Id: 11, Name: "web-1", Group: approval.Ref{Name: "Production"}, Cloud: approval.Ref{Name: "VMware"}})
+	srv.AddInstance(morpheus.Instance{Id: 21, Name: "ml-1", Group: approval.Ref{Name: "Research"}, InstancePrice: price})
+	srv.AddInstance(morpheus.Instance{Id: 22, Name: "ml-2", Group: approval.Ref{Name: "Research"}, InstancePrice: price})
+	srv.AddApp(morpheus.App{Id: 2, Name: "ml", Group: approval.Ref{Name: "Research"}, Instances: []approval.Ref{{Id: 21}, {Id: 22}}})
+	srv.AddApproval(approval.Approval{
+		Id: 1, Name: "APPROVAL-1", RequestType: "Instance Approval", DateCreated: time.Now().Add(-time.Hour),
+		Items: []approval.Item{{Id: 101, Reference: approval.Reference{Id: 11, Type: morpheus.REFERENCE_INSTANCE}}},
+	})
+	srv.AddApproval(approval.Approval{
+		Id: 2, Name: "APPROVAL-2", RequestType: "App Approval", DateCreated: time.Now().Add(-time.Hour),
+		Items: []approval.Item{{Id: 201, Reference: approval.Reference{Id: 2, Type: morpheus.REFERENCE_APP}}},
+	})
+	srv.AddApproval(approval.Approval{
+		Id: 3, Name: "APPROVAL-3", RequestType: "Instance Approval",
+		Items: []approval.Item{{Id: 301, Status: approval.ITEM_APPROVED, Reference: approval.Reference{Id: 11, Type: morpheus.REFERENCE_INSTANCE}}},
+	})
+
+	cfg := srv.Appliance(internal.DEFAULT_APPLIANCE)
+	cfg.StateFile = filepath.Join(dir, "state.json")
+	app := &internal.App{Config: internal.Config{
+		AuditFile:  filepath.Join(dir, "audit.jsonl"),
+		SmtpFrom:   "link@test.io",
+		ServerURL:  "https://link.test.io",
+		Appliances: []internal.Appliance{cfg},
+	}}
+	engine := New(app, logging.NewWriter(io.Discard, logging.LEVEL_INFO, logging.FORMAT_TEXT), health.NewTracker(time.Now()))
+	mail := &outbox{}
+	engine.send = mail.send
+	if _, err := engine.OpenAppliances(app.Config.Appliances); err != nil {
+		t.Fatalf("could not open appliances %v", err)
+	}
+	ap, _ := engine.Appliance(internal.DEFAULT_APPLIANCE)
+	st := ap.State
+	ctx := context.Background()
+
+	// poll, the lookup of the app fails so its approval is retried on a later poll, and the
+	// approval already approved is not fetched
+	srv.Fail("/api/apps/2", http.StatusBadGateway)
+	engine.Poll(ctx)
+	sent := mail.take()
+	if len(sent) != 1 || sent[0].To[0] != "prod@test.io" || !strings.Contains(sent[0].Subject, "APPROVAL-1") {
+		t.Fatalf("wanted production to be emailed about APPROVAL-1 got %+v", sent)
+	}
+	if pending := st.Pending(); len(pending) != 1 || pending[0].ApprovalId != 2 {
+		t.Fatalf("wanted APPROVAL-2 queued for retry got %+v", pending)
+	}
+	if n := srv.Requests("/api/approvals/3"); n != 0 || st.LastPollId != 3 {
+		t.Fatalf("wanted APPROVAL-3 skipped and polled to 3 got %d requests, last poll id %d", n, st.LastPollId)
+	}
+
+	// once due the approval is routed on its cost, but the email to the CFO cannot be sent so it
+	// stays queued
+	makeDue(st)
+	mail.err, mail.failTo = errors.New("connection refused"), "cfo@test.io"
+	engine.Poll(ctx)
+	if pending := st.Pending(); len(pending) != 1 || pending[0].ApprovalId != 2 || st.Managing(2) {
+		t.Fatalf("wanted APPROVAL-2 queued until notified got %+v", pending)
+	}
+	sent = mail.take()
+	if len(sent) != 1 || sent[0].To[0] != "finance@test.io" {
+		t.Fatalf("wanted finance to be emailed about APPROVAL-2 got %+v", sent)
+	}
+	vote := voteLink(t, sent[0].Body, "Deny")
+
+	// only the CFO is emailed again, and the link finance was sent stays valid
+	makeDue(st)
+	mail.err = nil
+	engine.Poll(ctx)
+	sent = mail.take()
+	if len(sent) != 1 || sent[0].To[0] != "cfo@test.io" {
+		t.Fatalf("wanted only the CFO to be emailed about APPROVAL-2 got %+v", sent)
+	}
+	if !st.ValidLink(2, "finance@test.io", vote.Query().Get("token")) {
+		t.Error("wanted the link sent to finance before the retry to be valid")
+	}
+	if len(st.Pending()) != 0 || len(st.InFlight()) != 2 {
+		t.Fatalf("wanted an empty queue and 2 workflows got %+v %+v", st.Pending(), st.InFlight())
+	}
+
+	// the state is saved each poll
+	saved := &state.State{File: cfg.StateFile}
+	if err := saved.Load(); err != nil || len(saved.Workflows) != 2 {
+		t.Fatalf("wanted 2 workflows saved got %+v %v", saved.Workflows, err)
+	}
+
+	// an approval decided in Morpheus closes its workflow on the next poll
+	wf := st.InFlight()[0]
+	a, err := ap.API.GetApproval(ctx, wf.ApprovalId)
+	if err != nil {
+		t.Fatalf("could not get approval %v", err)
+	}
+	if err := ap.API.Decide(ctx, a, morpheus.ITEM_APPROVE); err != nil {
+		t.Fatalf("could not approve %v", err)
+	}
+	engine.Poll(ctx)
+	if st.Managing(wf.ApprovalId) || st.ValidLink(wf.ApprovalId, "prod@test.io", state.LinkToken(wf.Token, "prod@test.io")) {
+		t.Error("wanted the workflow closed and its link invalid")
+	}
+	if sent := mail.take(); len(sent) != 1 || sent[0].To[0] != "prod@test.io" || !strings.Contains(sent[0].Subject, "APPROVAL-1") {
+		t.Errorf("wanted production told APPROVAL-1 was resolved got %+v", sent)
+	}
+
+	records, err := audit.Read(app.Config.AuditFile)
+	if err != nil {
+		t.Fatalf("could not read audit trail %v", err)
+	}
+	outcomes := map[int]string{}
+	for _, rec := range records {
+		outcomes[rec.ApprovalId] = rec.Outcome
+		if decided := rec.Decided != nil; decided != (rec.Outcome != audit.OUTCOME_PENDING) || len(rec.Votes) != 0 {
+			t.Errorf("wanted a decided date only once decided, and no votes, got %+v", rec)
+		}
+	}
+	if len(records) != 2 || outcomes[1] != audit.OUTCOME_APPROVED || outcomes[2] != audit.OUTCOME_PENDING {
+		t.Errorf("wanted APPROVAL-1 approved and APPROVAL-2 pending in the audit trail got %+v", records)
+	}
+
+	// a vote needs the link of a recipient, and its decision is made in Morpheus
+	q := vote.Query()
+	if vote.Scheme+"://"+vote.Host+vote.Path != "https://link.test.io"+VOTE_PATH || q.Get("appliance") != internal.DEFAULT_APPLIANCE ||
+		q.Get("approval") != "2" || q.Get("voter") != "finance@test.io" || q.Get("decision") != morpheus.ITEM_DENY {
+		t.Fatalf("unexpected vote link %s", vote)
+	}
+	if err := engine.Vote(ctx, q.Get("appliance"), 2, "prod@test.io", q.Get("token"), morpheus.ITEM_DENY); err != ERR_LINK_NOT_VALID {
+		t.Errorf("wanted %v for the link of another recipient got %v", ERR_LINK_NOT_VALID, err)
+	}
+	if err := engine.Vote(ctx, q.Get("appliance"), 2, q.Get("voter"), q.Get("token"), "maybe"); err != ERR_BAD_DECISION {
+		t.Errorf("wanted %v got %v", ERR_BAD_DECISION, err)
+	}
+	if err := engine.Vote(ctx, q.Get("appliance"), 2, q.Get("voter"), q.Get("token"), q.Get("decision")); err != nil {
+		t.Fatalf("could not vote %v", err)
+	}
+	if a, err := ap.API.GetApproval(ctx, 2); err != nil || st.Managing(2) {
+		t.Errorf("wanted the workflow closed got %v", err)
+	} else if outcome, _ := morpheus.Resolution(a.Status); outcome != audit.OUTCOME_DENIED {
+		t.Errorf("wanted APPROVAL-2 denied in Morpheus got %s", a.Status)
+	}
+
+	// the vote is counted, and the time the approval took to be decided by it and in Morpheus
+	var exposition bytes.Buffer
+	if err := metrics.Write(&exposition); err != nil {
+		t.Fatalf("could not write metrics %v", err)
+	}
+	for _, want := range []string{
+		`link_votes_total{decision="deny"} 1`,
+		`link_approval_decision_seconds_count{config="expensive",outcome="denied"} 1`,
+		`link_approval_decision_seconds_count{config="production changes",outcome="approved"} 1`,
+	} {
+		if !strings.Contains(exposition.String(), want+"\n") {
+			t.Errorf("wanted metrics to contain %q\n%s", want, exposition.String())
+		}
+	}
+	if strings.Contains(exposition.String(), `decision="maybe"`) {
+		t.Error("wanted only votes recorded to be counted")
+	}
+
+	if err := engine.Vote(ctx, q.Get("appliance"), 2, q.Get("voter"), q.Get("token"), morpheus.ITEM_APPROVE); err != ERR_LINK_NOT_VALID {
+		t.Errorf("wanted %v voting twice got %v", ERR_LINK_NOT_VALID, err)
+	}
+	records, err = audit.Read(app.Config.AuditFile)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("could not read audit trail %+v %v", records, err)
+	}
+	if rec := records[1]; rec.Outcome != audit.OUTCOME_DENIED || rec.Decided == nil || rec.ResolvedExternally ||
+		len(rec.Votes) != 1 || rec.Votes[0].Voter != "finance@test.io" || rec.Votes[0].Decision != audit.OUTCOME_DENIED || !rec.Votes[0].Date.Equal(*rec.Decided) {
+		t.Errorf("wanted the vote denying APPROVAL-2 in the audit trail got %+v", rec)
+	}
+
+	// an item can only be decided once
+	if err := ap.API.ActOnItem(ctx, 101, morpheus.ITEM_DENY); err == nil {
+		t.Error("wanted an error deciding an item twice")
+	}
+	if err := ap.API.ActOnItem(ctx, 201, "approve-all"); err == nil {
+		t.Error("wanted an error for an unknown action")
+	}
+}
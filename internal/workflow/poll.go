@@ -0,0 +1,186 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/email"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/metrics"
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+// Poll checks each appliance for new approvals concurrently, each poll has an id which is
+// logged with every line logged for it
+func (e *Engine) Poll(ctx context.Context) {
+	log := e.logger.With(logging.PollId(logging.NewId()))
+	ctx = logging.NewContext(ctx, log)
+	log.Info(fmt.Sprintf("Checking for new Morpheus Approvals at %s", time.Now()))
+
+	cfg := e.app.Settings()
+	var wg sync.WaitGroup
+	for _, ap := range e.Appliances() {
+		wg.Add(1)
+		go func(ap *Appliance) {
+			defer wg.Done()
+			e.poll(ctx, cfg, ap)
+		}(ap)
+	}
+	wg.Wait()
+}
+
+// poll checks the appliance for new approvals, routes them according to the approval
+// configuration and records them in the audit trail. Workflows in flight are then reconciled
+// with their approval in Morpheus
+func (e *Engine) poll(ctx context.Context, cfg internal.Config, ap *Appliance) {
+	log := logging.FromContext(ctx, e.logger).With(logging.Appliance(ap.Name))
+	ctx = logging.NewContext(ctx, log)
+
+	metrics.Polls.Inc(ap.Name)
+	newApprovals, err := ap.API.CheckNewApprovals(ctx, ap.State)
+	e.monitor.Polled(ap.Name, err, time.Now())
+	if err != nil {
+		metrics.PollErrors.Inc(ap.Name)
+		log.Error(fmt.Sprintf("Morpheus API request error for appliance '%s'", ap.Name), err)
+	}
+	metrics.ApprovalsDetected.Add(float64(len(newApprovals)), ap.Name)
+
+	// match against the configuration and record in the audit trail, each approval is removed
+	// from the queue only once it has been routed
+	for _, a := range newApprovals {
+		alog := log.With(logging.ApprovalId(a.Id))
+		routes := approval.RouteApproval(a)
+		if len(routes) == 0 {
+			metrics.ApprovalsUnmatched.Inc(ap.Name)
+			alog.Info(fmt.Sprintf("Approval '%s' (%d) on '%s' matched no approval configuration", a.Name, a.Id, ap.Name))
+			ap.State.Done(a.Id)
+			continue
+		}
+		metrics.ApprovalsMatched.Inc(ap.Name)
+
+		// the workflow id is logged from the first notification sent, and the token signs the
+		// links in the emails so both are created first. They are kept while the approval is
+		// queued, so recipients notified on an earlier attempt keep valid links
+		q, err := ap.State.Prepare(a.Id)
+		if err != nil {
+			q = ap.State.Retry(a.Id, err, time.Now())
+			alog.Error(fmt.Sprintf("Could not create link token for approval '%s', retrying at %s", a.Name, q.NextAttempt.Format(time.RFC3339)), err)
+			continue
+		}
+		workflowId, token := q.WorkflowId, q.Token
+		alog = alog.With(logging.WorkflowId(workflowId))
+		notified := map[string]bool{}
+		for _, recipient := range q.Notified {
+			notified[strings.ToLower(recipient)] = true
+		}
+
+		rec := audit.Record{
+			ApprovalId:    a.Id,
+			ApprovalName:  a.Name,
+			Appliance:     ap.Name,
+			RequestBy:     a.RequestBy,
+			ConfigVersion: routes[0].Version,
+			Created:       a.DateCreated,
+			Outcome:       audit.OUTCOME_PENDING,
+		}
+		var notifyErr error
+		for _, route := range routes {
+			rec.Descriptions = append(rec.Descriptions, route.Descriptions()...)
+			rec.Recipients = append(rec.Recipients, route.Recipients...)
+			if err := e.notify(alog, cfg, ap, a, route, token, notified); err != nil && notifyErr == nil {
+				notifyErr = err
+			}
+		}
+
+		// the approval stays queued until its notifications are sent, so those which failed are
+		// sent on a later poll rather than dropped
+		if notifyErr != nil {
+			q := ap.State.Retry(a.Id, notifyErr, time.Now())
+			alog.Warn(fmt.Sprintf("Could not notify the recipients of approval '%s' (attempt %d), retrying at %s",
+				a.Name, q.Attempts, q.NextAttempt.Format(time.RFC3339)))
+			continue
+		}
+		if err := audit.Write(cfg.AuditFile, rec); err != nil {
+			alog.Error("Could not write to audit trail", err)
+		}
+
+		ap.State.Open(state.Workflow{
+			Id:            workflowId,
+			ApprovalId:    a.Id,
+			ApprovalName:  a.Name,
+			RequestBy:     a.RequestBy,
+			Descriptions:  rec.Descriptions,
+			Recipients:    rec.Recipients,
+			ConfigVersion: rec.ConfigVersion,
+			Token:         token,
+			Created:       a.DateCreated,
+		})
+		ap.State.Done(a.Id)
+	}
+
+	if queued := ap.State.Pending(); len(queued) > 0 {
+		log.Warn(fmt.Sprintf("%d approvals on '%s' queued for retry, the oldest is approval %d", len(queued), ap.Name, queued[0].ApprovalId))
+	}
+
+	e.reconcile(ctx, log, cfg, ap)
+	metrics.WorkflowsInFlight.Set(float64(len(ap.State.InFlight())), ap.Name)
+	metrics.ApprovalsQueued.Set(float64(len(ap.State.Pending())), ap.Name)
+
+	err = ap.State.CreateAndWrite()
+	e.monitor.Saved(ap.Name, err, time.Now())
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to save the state of appliance '%s'", ap.Name), err)
+	}
+}
+
+// notify emails each recipient of the route about the approval, with their own links to vote
+// signed by the token of the workflow. A recipient already notified, on an earlier attempt or
+// for another route, is not emailed again, and each recipient emailed is recorded
+func (e *Engine) notify(log *logging.Logger, cfg internal.Config, ap *Appliance, a approval.Approval, route approval.Route, token string, notified map[string]bool) error {
+	var notifyErr error
+	for _, recipient := range route.Recipients {
+		if notified[strings.ToLower(recipient)] {
+			continue
+		}
+		links := voteLinks(cfg.ServerURL, ap.Name, a.Id, recipient, state.LinkToken(token, recipient))
+		msg, err := email.Compose(cfg.SmtpFrom, a, route, recipient, links)
+		if err != nil {
+			metrics.NotificationsFailed.Inc(metrics.CHANNEL_EMAIL)
+			log.Error(fmt.Sprintf("Could not compose email for approval '%s'", a.Name), err)
+			return err
+		}
+		if err := e.deliver(log, cfg, msg); err != nil {
+			if notifyErr == nil {
+				notifyErr = err
+			}
+			continue
+		}
+		notified[strings.ToLower(recipient)] = true
+		ap.State.Notify(a.Id, recipient)
+	}
+	return notifyErr
+}
+
+// deliver sends the email, in dry run mode the email is logged but not sent
+func (e *Engine) deliver(log *logging.Logger, cfg internal.Config, msg email.Message) error {
+	recipients := strings.Join(msg.To, ", ")
+	if cfg.DryRun {
+		log.Info(fmt.Sprintf("Dry run, not sending '%s' to %s", msg.Subject, recipients))
+		return nil
+	}
+
+	if err := e.send(cfg, msg); err != nil {
+		metrics.NotificationsFailed.Inc(metrics.CHANNEL_EMAIL)
+		log.Error(fmt.Sprintf("Could not send '%s' to %s", msg.Subject, recipients), err)
+		return err
+	}
+	metrics.NotificationsSent.Inc(metrics.CHANNEL_EMAIL)
+	log.Info(fmt.Sprintf("Sent '%s' to %s", msg.Subject, recipients))
+	return nil
+}
@@ -0,0 +1,111 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/email"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/metrics"
+	"github.com/spoonboy-io/link/internal/morpheus"
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+// reconcile checks the approval of each workflow in flight, an approval which has been
+// approved, denied or cancelled directly in Morpheus closes its workflow, invalidating
+// the links in the emails sent. The recipients are told and the audit trail updated
+func (e *Engine) reconcile(ctx context.Context, log *logging.Logger, cfg internal.Config, ap *Appliance) {
+	for _, wf := range ap.State.InFlight() {
+		wlog := log.With(logging.ApprovalId(wf.ApprovalId), logging.WorkflowId(wf.Id))
+		closed, outcome, ok := e.resolve(logging.NewContext(ctx, wlog), wlog, ap, wf)
+		if !ok {
+			continue
+		}
+		wlog.Info(fmt.Sprintf("Approval '%s' (%d) was %s in Morpheus, closing the workflow", wf.ApprovalName, wf.ApprovalId, outcome))
+
+		decided := time.Now().UTC()
+		rec := audit.Record{
+			ApprovalId:         closed.ApprovalId,
+			ApprovalName:       closed.ApprovalName,
+			Appliance:          ap.Name,
+			RequestBy:          closed.RequestBy,
+			Descriptions:       closed.Descriptions,
+			ConfigVersion:      closed.ConfigVersion,
+			Recipients:         closed.Recipients,
+			Created:            closed.Created,
+			Decided:            &decided,
+			Outcome:            outcome,
+			ResolvedExternally: true,
+		}
+		if err := audit.Write(cfg.AuditFile, rec); err != nil {
+			wlog.Error("Could not write to audit trail", err)
+		}
+		observeDecision(rec)
+
+		// each recipient is told once, in their own email
+		told := map[string]bool{}
+		for _, recipient := range closed.Recipients {
+			if told[strings.ToLower(recipient)] {
+				continue
+			}
+			told[strings.ToLower(recipient)] = true
+
+			msg, err := email.ComposeResolved(cfg.SmtpFrom, closed, outcome, recipient)
+			if err != nil {
+				metrics.NotificationsFailed.Inc(metrics.CHANNEL_EMAIL)
+				wlog.Error(fmt.Sprintf("Could not compose resolved email for approval '%s'", closed.ApprovalName), err)
+				break
+			}
+			// the workflow is closed, a resolved notice which fails is logged but not retried
+			_ = e.deliver(wlog, cfg, msg)
+		}
+	}
+}
+
+// resolve closes the workflow if its approval has been approved, denied or cancelled in Morpheus,
+// returning it with the outcome. Votes are held off while it checks, so an approval decided by
+// a vote still being recorded is not taken as resolved in Morpheus
+func (e *Engine) resolve(ctx context.Context, log *logging.Logger, ap *Appliance, wf state.Workflow) (state.Workflow, string, bool) {
+	e.voteMu.Lock()
+	defer e.voteMu.Unlock()
+
+	// a vote made since the workflows were listed has closed it
+	if !ap.State.Managing(wf.ApprovalId) {
+		return state.Workflow{}, "", false
+	}
+
+	outcome, resolved := "", false
+	a, err := ap.API.GetApproval(ctx, wf.ApprovalId)
+	switch {
+	case errors.Is(err, morpheus.ERR_NOT_FOUND):
+		// the approval, or what it was for, has been deleted
+		outcome, resolved = audit.OUTCOME_CANCELLED, true
+	case err != nil:
+		log.Error(fmt.Sprintf("Could not check the status of approval '%s' (%d)", wf.ApprovalName, wf.ApprovalId), err)
+		return state.Workflow{}, "", false
+	default:
+		outcome, resolved = morpheus.Resolution(a.Status)
+	}
+	if !resolved {
+		return state.Workflow{}, "", false
+	}
+
+	closed, ok := ap.State.Close(wf.ApprovalId)
+	return closed, outcome, ok
+}
+
+// observeDecision records the time an approval took to be decided for each of its approval
+// configs, once the record has both its created and decided dates
+func observeDecision(rec audit.Record) {
+	if rec.Decided == nil || rec.Created.IsZero() {
+		return
+	}
+	for _, desc := range rec.Descriptions {
+		metrics.TimeToDecision.ObserveDuration(rec.Decided.Sub(rec.Created), desc, rec.Outcome)
+	}
+}
@@ -0,0 +1,128 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/email"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/metrics"
+	"github.com/spoonboy-io/link/internal/morpheus"
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+// VOTE_PATH is the path of the links in the notification emails
+const VOTE_PATH = "/vote"
+
+var (
+	ERR_LINK_NOT_VALID = errors.New("The link is not valid, the approval may already have been decided")
+	ERR_BAD_DECISION   = errors.New("Decision must be 'approve' or 'deny'")
+	ERR_DECIDED        = errors.New("The approval has already been decided")
+)
+
+// voteLinks returns the links of a recipient to approve or deny an approval, signed by their link token
+func voteLinks(serverURL, appliance string, approvalId int, voter, token string) email.Links {
+	link := func(decision string) string {
+		q := url.Values{}
+		q.Set("appliance", appliance)
+		q.Set("approval", strconv.Itoa(approvalId))
+		q.Set("voter", voter)
+		q.Set("decision", decision)
+		q.Set("token", token)
+		return serverURL + VOTE_PATH + "?" + q.Encode()
+	}
+	return email.Links{Approve: link(morpheus.ITEM_APPROVE), Deny: link(morpheus.ITEM_DENY)}
+}
+
+// Workflow returns the open workflow of an approval for the link of a recipient, or
+// ERR_LINK_NOT_VALID if the link is not theirs or the workflow has been closed
+func (e *Engine) Workflow(appliance string, approvalId int, voter, token string) (*Appliance, state.Workflow, error) {
+	ap, ok := e.Appliance(appliance)
+	if !ok || !ap.State.ValidLink(approvalId, voter, token) {
+		return nil, state.Workflow{}, ERR_LINK_NOT_VALID
+	}
+	for _, wf := range ap.State.InFlight() {
+		if wf.ApprovalId == approvalId {
+			return ap, wf, nil
+		}
+	}
+	return nil, state.Workflow{}, ERR_LINK_NOT_VALID
+}
+
+// Vote decides an approval in Morpheus for a recipient following a link in their email, the
+// first vote decides it. The workflow is closed, so the links sent for it are no longer valid,
+// and the decision recorded in the audit trail
+func (e *Engine) Vote(ctx context.Context, appliance string, approvalId int, voter, token, decision string) error {
+	outcome := ""
+	switch decision {
+	case morpheus.ITEM_APPROVE:
+		outcome = audit.OUTCOME_APPROVED
+	case morpheus.ITEM_DENY:
+		outcome = audit.OUTCOME_DENIED
+	default:
+		return ERR_BAD_DECISION
+	}
+
+	// votes are made one at a time, and not while a workflow is reconciled, so an approval is
+	// decided once and its vote recorded
+	e.voteMu.Lock()
+	defer e.voteMu.Unlock()
+
+	ap, wf, err := e.Workflow(appliance, approvalId, voter, token)
+	if err != nil {
+		return err
+	}
+	log := logging.FromContext(ctx, e.logger).With(logging.Appliance(ap.Name), logging.ApprovalId(wf.ApprovalId), logging.WorkflowId(wf.Id))
+	ctx = logging.NewContext(ctx, log)
+
+	a, err := ap.API.GetApproval(ctx, approvalId)
+	if err != nil {
+		return err
+	}
+	// an approval decided in Morpheus is closed on the next poll
+	if _, resolved := morpheus.Resolution(a.Status); resolved {
+		return ERR_DECIDED
+	}
+	if err := ap.API.Decide(ctx, a, decision); err != nil {
+		return err
+	}
+
+	closed, ok := ap.State.Close(approvalId)
+	if !ok {
+		return ERR_DECIDED
+	}
+	log.Info(fmt.Sprintf("Approval '%s' (%d) was %s by %s", closed.ApprovalName, closed.ApprovalId, outcome, voter))
+
+	// the vote is recorded with the decision it made
+	decided := time.Now().UTC()
+	rec := audit.Record{
+		ApprovalId:    closed.ApprovalId,
+		ApprovalName:  closed.ApprovalName,
+		Appliance:     ap.Name,
+		RequestBy:     closed.RequestBy,
+		Descriptions:  closed.Descriptions,
+		ConfigVersion: closed.ConfigVersion,
+		Recipients:    closed.Recipients,
+		Votes:         []audit.Vote{{Voter: voter, Decision: outcome, Date: decided}},
+		Created:       closed.Created,
+		Decided:       &decided,
+		Outcome:       outcome,
+	}
+	if err := audit.Write(e.app.Settings().AuditFile, rec); err != nil {
+		log.Error("Could not write to audit trail", err)
+	}
+	metrics.Votes.Inc(decision)
+	observeDecision(rec)
+
+	err = ap.State.CreateAndWrite()
+	e.monitor.Saved(ap.Name, err, time.Now())
+	if err != nil {
+		log.Error(fmt.Sprintf("Failed to save the state of appliance '%s'", ap.Name), err)
+	}
+	return nil
+}
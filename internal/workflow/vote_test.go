@@ -0,0 +1,69 @@
+package workflow
+
+import (
+	"context"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/approval"
+	"github.com/spoonboy-io/link/internal/audit"
+	"github.com/spoonboy-io/link/internal/health"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/morpheus"
+	"github.com/spoonboy-io/link/internal/morpheus/morpheustest"
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+func TestVote_ConcurrentPoll(t *testing.T) {
+	dir := t.TempDir()
+	srv := morpheustest.NewServer()
+	defer srv.Close()
+	srv.AddApproval(approval.Approval{Id: 1, Name: "APPROVAL-1", Items: []approval.Item{{Id: 101}}})
+
+	cfg := srv.Appliance(internal.DEFAULT_APPLIANCE)
+	cfg.StateFile = filepath.Join(dir, "state.json")
+	app := &internal.App{Config: internal.Config{
+		AuditFile:  filepath.Join(dir, "audit.jsonl"),
+		Appliances: []internal.Appliance{cfg},
+	}}
+	engine := New(app, logging.NewWriter(io.Discard, logging.LEVEL_INFO, logging.FORMAT_TEXT), health.NewTracker(time.Now()))
+	mail := &outbox{}
+	engine.send = mail.send
+	if _, err := engine.OpenAppliances(app.Config.Appliances); err != nil {
+		t.Fatalf("could not open appliances %v", err)
+	}
+	ap, _ := engine.Appliance(internal.DEFAULT_APPLIANCE)
+	ap.State.LastPollId = 1
+	ap.State.Open(state.Workflow{Id: "wf-1", ApprovalId: 1, ApprovalName: "APPROVAL-1", Recipients: []string{"ops@test.io"}, Token: "token"})
+	ctx := context.Background()
+
+	// a poll made once Morpheus has decided the item, but before the vote is recorded, waits
+	// for the vote rather than taking the approval as resolved in Morpheus
+	polled := make(chan struct{})
+	srv.After("/api/approval-items/101/approve", func() {
+		go func() {
+			engine.Poll(ctx)
+			close(polled)
+		}()
+		time.Sleep(100 * time.Millisecond)
+	})
+	err := engine.Vote(ctx, internal.DEFAULT_APPLIANCE, 1, "ops@test.io", state.LinkToken("token", "ops@test.io"), morpheus.ITEM_APPROVE)
+	<-polled
+	if err != nil {
+		t.Fatalf("wanted the vote recorded got %v", err)
+	}
+	if sent := mail.take(); len(sent) != 0 {
+		t.Errorf("wanted no resolved email got %+v", sent)
+	}
+
+	records, err := audit.Read(app.Config.AuditFile)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("wanted one audit record got %+v %v", records, err)
+	}
+	if rec := records[0]; rec.ResolvedExternally || rec.Outcome != audit.OUTCOME_APPROVED || len(rec.Votes) != 1 {
+		t.Errorf("wanted the vote approving APPROVAL-1 in the audit trail got %+v", rec)
+	}
+}
@@ -0,0 +1,125 @@
+// Package workflow runs the approval workflows of Link. Each Morpheus appliance is polled for new
+// approvals, which are routed by the approval configuration, their recipients notified and a
+// workflow opened which is managed until the approval is decided
+package workflow
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/spoonboy-io/link/internal"
+	"github.com/spoonboy-io/link/internal/email"
+	"github.com/spoonboy-io/link/internal/health"
+	"github.com/spoonboy-io/link/internal/logging"
+	"github.com/spoonboy-io/link/internal/morpheus"
+	"github.com/spoonboy-io/link/internal/state"
+)
+
+// Appliance is a Morpheus appliance, or subtenant, which is polled for approvals with its own
+// API client and state, so approval ids and the last approval polled are kept per appliance
+type Appliance struct {
+	Name  string
+	API   *morpheus.Client
+	State *state.State
+}
+
+// Engine polls the appliances and manages the workflows of their approvals, it is safe for use by
+// multiple goroutines. The configuration is read from the app for each poll
+type Engine struct {
+	app     *internal.App
+	logger  *logging.Logger
+	monitor *health.Tracker
+
+	// send sends an email with the SMTP settings of the configuration, replaced in tests
+	send func(cfg internal.Config, msg email.Message) error
+
+	mu         sync.Mutex
+	appliances []*Appliance
+
+	// voteMu makes votes one at a time, and holds off reconciling a workflow while a vote is made
+	voteMu sync.Mutex
+}
+
+// New creates an engine for the app, polls and state saves are recorded by the monitor
+func New(app *internal.App, logger *logging.Logger, monitor *health.Tracker) *Engine {
+	return &Engine{
+		app:     app,
+		logger:  logger,
+		monitor: monitor,
+		send: func(cfg internal.Config, msg email.Message) error {
+			return email.Send(cfg.SmtpServer, cfg.SmtpPort, cfg.SmtpUser, cfg.SmtpPassword, msg)
+		},
+	}
+}
+
+// Appliances returns the appliances in use
+func (e *Engine) Appliances() []*Appliance {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.appliances
+}
+
+// Appliance returns the appliance in use with the name
+func (e *Engine) Appliance(name string) (*Appliance, bool) {
+	for _, ap := range e.Appliances() {
+		if ap.Name == name {
+			return ap, true
+		}
+	}
+	return nil, false
+}
+
+// OpenAppliances creates an API client for each configured appliance and replaces the appliances
+// in use, returning the names of those removed. The state of an appliance in use is kept, the
+// state of a new appliance is loaded from its state file. On error the appliances are not replaced
+func (e *Engine) OpenAppliances(configs []internal.Appliance) ([]string, error) {
+	current := e.Appliances()
+
+	var opened []*Appliance
+	for _, cfg := range configs {
+		client, err := morpheus.NewClient(cfg, e.logger)
+		if err != nil {
+			return nil, fmt.Errorf("Could not create the Morpheus API client for appliance '%s': %w", cfg.Name, err)
+		}
+
+		var st *state.State
+		for _, ap := range current {
+			if ap.Name == cfg.Name && ap.State.File == cfg.StateFile {
+				st = ap.State
+			}
+		}
+		if st == nil {
+			st = &state.State{File: cfg.StateFile}
+			if err := st.Load(); err != nil {
+				return nil, fmt.Errorf("Failed to load the state of appliance '%s': %w", cfg.Name, err)
+			}
+		}
+
+		opened = append(opened, &Appliance{Name: cfg.Name, API: client, State: st})
+	}
+
+	var removed []string
+	for _, ap := range current {
+		kept := false
+		for _, cfg := range configs {
+			kept = kept || cfg.Name == ap.Name
+		}
+		if !kept {
+			removed = append(removed, ap.Name)
+		}
+	}
+
+	e.mu.Lock()
+	e.appliances = opened
+	e.mu.Unlock()
+	return removed, nil
+}
+
+// SaveState saves the state of each appliance
+func (e *Engine) SaveState() {
+	for _, ap := range e.Appliances() {
+		if err := ap.State.CreateAndWrite(); err != nil {
+			e.logger.Error(fmt.Sprintf("Failed to save the state of appliance '%s'", ap.Name), err)
+		}
+	}
+}